@@ -113,10 +113,9 @@ type RiskConfig struct {
 
 // FeatureFlags for enabling/disabling features
 type FeatureFlags struct {
-	EnableWebSocket        bool
-	EnableStopOrderMonitor bool
-	EnableRiskManager      bool
-	EnableSwagger          bool
+	EnableWebSocket   bool
+	EnableRiskManager bool
+	EnableSwagger     bool
 }
 
 // MonitoringConfig for observability
@@ -196,10 +195,9 @@ func LoadConfig() (*Config, error) {
 			LargeWithdrawalThreshold: getEnvAsFloat64("LARGE_WITHDRAWAL_THRESHOLD", 10000),
 		},
 		Features: FeatureFlags{
-			EnableWebSocket:        getEnvAsBool("ENABLE_WEBSOCKET", true),
-			EnableStopOrderMonitor: getEnvAsBool("ENABLE_STOP_ORDER_MONITOR", true),
-			EnableRiskManager:      getEnvAsBool("ENABLE_RISK_MANAGER", true),
-			EnableSwagger:          getEnvAsBool("ENABLE_SWAGGER", true),
+			EnableWebSocket:   getEnvAsBool("ENABLE_WEBSOCKET", true),
+			EnableRiskManager: getEnvAsBool("ENABLE_RISK_MANAGER", true),
+			EnableSwagger:     getEnvAsBool("ENABLE_SWAGGER", true),
 		},
 		Monitoring: MonitoringConfig{
 			PrometheusEnabled: getEnvAsBool("PROMETHEUS_ENABLED", true),
@@ -112,6 +112,11 @@ func autoMigrate() error {
 		&models.Deposit{},
 		&models.Withdrawal{},
 		&models.TradingPair{},
+		&models.UserFeeOverride{},
+		&models.FeeTier{},
+		&models.UserFeeTier{},
+		&models.Kline{},
+		&models.MarkPrice{},
 	)
 }
 
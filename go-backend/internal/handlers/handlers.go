@@ -5,11 +5,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/easitradecoins/backend/internal/matching"
 	"github.com/easitradecoins/backend/internal/models"
 	"github.com/easitradecoins/backend/internal/services"
 	"github.com/gin-gonic/gin"
@@ -31,12 +35,80 @@ func NewOrderHandler(orderService *services.OrderService) *OrderHandler {
 
 // CreateOrderRequest represents a create order request
 type CreateOrderRequest struct {
-	Symbol      string  `json:"symbol" binding:"required"`
-	Side        string  `json:"side" binding:"required,oneof=buy sell"`
-	Type        string  `json:"type" binding:"required,oneof=limit market"`
-	Price       string  `json:"price"`
-	Quantity    string  `json:"quantity" binding:"required"`
-	TimeInForce string  `json:"timeInForce" binding:"omitempty,oneof=GTC IOC FOK"`
+	Symbol        string `json:"symbol" binding:"required"`
+	Side          string `json:"side" binding:"required,oneof=buy sell"`
+	Type          string `json:"type" binding:"required,oneof=limit market stop_loss take_profit stop_limit trailing_stop"`
+	Price         string `json:"price"`
+	Quantity      string `json:"quantity"`
+	QuoteOrderQty string `json:"quoteOrderQty"` // market and conditional market buys: quote currency to spend, instead of or on top of quantity
+	TimeInForce   string `json:"timeInForce" binding:"omitempty,oneof=GTC IOC FOK GTD"`
+	PostOnly      bool   `json:"postOnly"`
+	PostOnlyMode  string `json:"postOnlyMode" binding:"omitempty,oneof=reject reprice"`
+	ReduceOnly    bool   `json:"reduceOnly"`
+	ExpireTime    int64  `json:"expireTime"` // Unix milliseconds, required for GTD
+	STPMode       string `json:"stpMode" binding:"omitempty,oneof=cancel_newest cancel_oldest cancel_both decrement_cancel"`
+
+	// Conditional orders: stopPrice is the trigger price, also of take-profit
+	// orders; trailing stops take a delta or a percentage instead, and the
+	// stop price then only sets where they start
+	StopPrice        string `json:"stopPrice"`
+	TrailingDelta    string `json:"trailingDelta"`
+	TrailingPercent  string `json:"trailingPercent"`
+	TriggerPriceType string `json:"triggerPriceType" binding:"omitempty,oneof=last mark index"`
+
+	// Pegged limit orders are priced by the engine from pegType plus
+	// pegOffset and never beyond pegLimit, which buy orders require
+	PegType   string `json:"pegType" binding:"omitempty,oneof=primary market mid"`
+	PegOffset string `json:"pegOffset"`
+	PegLimit  string `json:"pegLimit"`
+}
+
+// optionalDecimal parses an optional decimal request field
+func optionalDecimal(value, name string) (*decimal.Decimal, error) {
+	if value == "" {
+		return nil, nil
+	}
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return &d, nil
+}
+
+// conditionalFields parses the trigger fields of a conditional order request
+func conditionalFields(req *CreateOrderRequest, order *models.Order) error {
+	stopPrice, err := optionalDecimal(req.StopPrice, "stop price")
+	if err != nil {
+		return err
+	}
+	if order.Type == models.OrderTypeTakeProfit {
+		order.TakeProfitPrice = stopPrice
+	} else {
+		order.StopPrice = stopPrice
+	}
+
+	if order.TrailingDelta, err = optionalDecimal(req.TrailingDelta, "trailing delta"); err != nil {
+		return err
+	}
+	if order.TrailingPercent, err = optionalDecimal(req.TrailingPercent, "trailing percentage"); err != nil {
+		return err
+	}
+
+	order.TriggerPriceType = models.TriggerPriceType(req.TriggerPriceType)
+	return nil
+}
+
+// pegFields parses the peg fields of a pegged order request
+func pegFields(req *CreateOrderRequest, order *models.Order) error {
+	var err error
+	order.PegType = models.PegType(req.PegType)
+	if order.PegOffset, err = optionalDecimal(req.PegOffset, "peg offset"); err != nil {
+		return err
+	}
+	if order.PegLimit, err = optionalDecimal(req.PegLimit, "peg limit"); err != nil {
+		return err
+	}
+	return nil
 }
 
 // CreateOrder creates a new order
@@ -50,16 +122,29 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	// Get user ID from JWT
 	userID := getUserIDFromContext(c)
 
-	// Parse quantity
-	quantity, err := decimal.NewFromString(req.Quantity)
+	// Parse quantity; market buys may be sized by the quote amount instead
+	if req.Quantity == "" && req.QuoteOrderQty == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity or quoteOrderQty is required"})
+		return
+	}
+	var quantity decimal.Decimal
+	var err error
+	if req.Quantity != "" {
+		quantity, err = decimal.NewFromString(req.Quantity)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quantity"})
+			return
+		}
+	}
+	quoteOrderQty, err := optionalDecimal(req.QuoteOrderQty, "quoteOrderQty")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quantity"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Parse price for limit orders
+	// Parse price for limit and stop-limit orders; pegged orders are priced by the engine
 	var price decimal.Decimal
-	if req.Type == "limit" {
+	if (req.Type == "limit" && req.PegType == "") || req.Type == "stop_limit" {
 		price, err = decimal.NewFromString(req.Price)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid price"})
@@ -73,17 +158,56 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		timeInForce = "GTC"
 	}
 
+	// Post-only orders are rejected by default when they would take
+	var postOnly models.PostOnlyMode
+	if req.PostOnly {
+		postOnly = models.PostOnlyReject
+		if req.PostOnlyMode != "" {
+			postOnly = models.PostOnlyMode(req.PostOnlyMode)
+		}
+	}
+
+	var expireTime *time.Time
+	if req.ExpireTime > 0 {
+		t := time.UnixMilli(req.ExpireTime)
+		expireTime = &t
+	}
+
+	order := &models.Order{
+		UserID:        userID,
+		Symbol:        req.Symbol,
+		Side:          models.OrderSide(req.Side),
+		Type:          models.OrderType(req.Type),
+		Price:         price,
+		Quantity:      quantity,
+		QuoteOrderQty: quoteOrderQty,
+		TimeInForce:   models.TimeInForce(timeInForce),
+		PostOnly:      postOnly,
+		ReduceOnly:    req.ReduceOnly,
+		ExpireTime:    expireTime,
+		STPMode:       models.STPMode(req.STPMode),
+	}
+	if matching.IsConditional(order) {
+		if err := conditionalFields(&req, order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.PegType != "" {
+		if err := pegFields(&req, order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Create order
-	order, trades, err := h.orderService.CreateOrder(&models.Order{
-		UserID:      userID,
-		Symbol:      req.Symbol,
-		Side:        models.OrderSide(req.Side),
-		Type:        models.OrderType(req.Type),
-		Price:       price,
-		Quantity:    quantity,
-		TimeInForce: models.TimeInForce(timeInForce),
-	})
+	order, trades, err := h.orderService.CreateOrder(order)
 
+	var filterErr *matching.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": filterErr.Message, "code": filterErr.Code})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -95,6 +219,59 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	})
 }
 
+// AmendOrderRequest represents an amend order request; omitted fields keep their value
+type AmendOrderRequest struct {
+	Price    string `json:"price"`
+	Quantity string `json:"quantity"`
+}
+
+// AmendOrder changes the price and/or quantity of an open order
+func (h *OrderHandler) AmendOrder(c *gin.Context) {
+	var req AmendOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Price == "" && req.Quantity == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price or quantity is required"})
+		return
+	}
+
+	price, quantity := decimal.Zero, decimal.Zero
+	var err error
+	if req.Price != "" {
+		if price, err = decimal.NewFromString(req.Price); err != nil || !price.IsPositive() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid price"})
+			return
+		}
+	}
+	if req.Quantity != "" {
+		if quantity, err = decimal.NewFromString(req.Quantity); err != nil || !quantity.IsPositive() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quantity"})
+			return
+		}
+	}
+
+	userID := getUserIDFromContext(c)
+	order, trades, err := h.orderService.AmendOrder(c.Param("orderId"), userID, price, quantity)
+
+	var filterErr *matching.FilterError
+	if errors.As(err, &filterErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": filterErr.Message, "code": filterErr.Code})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order":  order,
+		"trades": trades,
+	})
+}
+
 // CancelOrder cancels an order
 func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	orderID := c.Param("orderId")
@@ -108,6 +285,29 @@ func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully"})
 }
 
+// CancelAllOrders cancels all open orders of the user, optionally filtered
+// by symbol and side
+func (h *OrderHandler) CancelAllOrders(c *gin.Context) {
+	symbol := c.Query("symbol")
+	side := models.OrderSide(c.Query("side"))
+	if side != "" && side != models.OrderSideBuy && side != models.OrderSideSell {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid side"})
+		return
+	}
+
+	userID := getUserIDFromContext(c)
+	orders, err := h.orderService.CancelAllOrders(userID, symbol, side, models.CancelReasonUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cancelled": orders,
+		"count":     len(orders),
+	})
+}
+
 // GetOrder gets order details
 func (h *OrderHandler) GetOrder(c *gin.Context) {
 	orderID := c.Param("orderId")
@@ -136,15 +336,12 @@ func (h *OrderHandler) GetOpenOrders(c *gin.Context) {
 	c.JSON(http.StatusOK, orders)
 }
 
-// GetOrderHistory gets order history
-func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
+// GetConditionalOrders gets a user's untriggered stop, take-profit and trailing orders
+func (h *OrderHandler) GetConditionalOrders(c *gin.Context) {
 	userID := getUserIDFromContext(c)
 	symbol := c.Query("symbol")
 
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-
-	orders, err := h.orderService.GetOrderHistory(userID, symbol, limit, offset)
+	orders, err := h.orderService.GetConditionalOrders(userID, symbol)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -153,6 +350,66 @@ func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
 	c.JSON(http.StatusOK, orders)
 }
 
+// GetOrderHistory gets a page of order history, newest first, as
+// {"orders": [...], "next_cursor": "..."}. Pass the returned next_cursor as
+// cursor to get the following page. This replaces the former bare array
+// paged by offset; offset is rejected rather than silently ignored.
+func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+
+	if _, exists := c.GetQuery("offset"); exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset is no longer supported, page with cursor and next_cursor instead"})
+		return
+	}
+
+	filter, err := historyFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, status := range splitList(c.Query("status")) {
+		filter.Statuses = append(filter.Statuses, models.OrderStatus(status))
+	}
+	for _, orderType := range splitList(c.Query("type")) {
+		filter.Types = append(filter.Types, models.OrderType(orderType))
+	}
+
+	page, err := h.orderService.GetOrderHistory(userID, filter)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetUserTrades gets a page of the user's fills, newest first
+func (h *OrderHandler) GetUserTrades(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+
+	filter, err := historyFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.orderService.GetUserTrades(userID, filter)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
 // UserHandler handles user-related requests
 type UserHandler struct {
 	userService  *services.UserService
@@ -249,32 +506,210 @@ func (h *UserHandler) GetBalance(c *gin.Context) {
 	c.JSON(http.StatusOK, assets)
 }
 
+// FeeHandler handles fee schedule requests
+type FeeHandler struct {
+	feeTierService *services.FeeTierService
+}
+
+// NewFeeHandler creates a new fee handler
+func NewFeeHandler(feeTierService *services.FeeTierService) *FeeHandler {
+	return &FeeHandler{
+		feeTierService: feeTierService,
+	}
+}
+
+// GetFeeTier gets the user's VIP fee tier and progress to the next tier
+func (h *FeeHandler) GetFeeTier(c *gin.Context) {
+	userID := getUserIDFromContext(c)
+
+	progress, err := h.feeTierService.GetUserTier(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// AdminHandler handles operator requests
+type AdminHandler struct {
+	symbols *services.SymbolRegistry
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(symbols *services.SymbolRegistry) *AdminHandler {
+	return &AdminHandler{
+		symbols: symbols,
+	}
+}
+
+// SetTradingStatusRequest represents a trading status change
+type SetTradingStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=trading halted cancel_only post_only"`
+}
+
+// SetTradingStatus sets a symbol's trading status in the engine and the
+// trading pair, so it also survives reloads and restarts
+func (h *AdminHandler) SetTradingStatus(c *gin.Context) {
+	symbol := c.Param("symbol")
+	if _, exists := h.symbols.GetSymbol(symbol); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "symbol not found"})
+		return
+	}
+
+	var req SetTradingStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.symbols.SetTradingStatus(symbol, matching.TradingStatus(req.Status)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	info, _ := h.symbols.GetSymbol(symbol)
+	c.JSON(http.StatusOK, info)
+}
+
 // MarketHandler handles market data requests
 type MarketHandler struct {
 	orderService *services.OrderService
+	symbols      *services.SymbolRegistry
+	klines       *services.KlineService
+	tickers      *services.TickerService
+	markPrices   *services.MarkPriceService
 }
 
 // NewMarketHandler creates a new market handler
-func NewMarketHandler(orderService *services.OrderService) *MarketHandler {
+func NewMarketHandler(orderService *services.OrderService, symbols *services.SymbolRegistry, klines *services.KlineService, tickers *services.TickerService, markPrices *services.MarkPriceService) *MarketHandler {
 	return &MarketHandler{
 		orderService: orderService,
+		symbols:      symbols,
+		klines:       klines,
+		tickers:      tickers,
+		markPrices:   markPrices,
 	}
 }
 
+// GetSymbols lists tradable symbols and their order filters
+func (h *MarketHandler) GetSymbols(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"symbols": h.symbols.GetSymbols(),
+	})
+}
+
 // GetDepth gets order book depth
 func (h *MarketHandler) GetDepth(c *gin.Context) {
 	symbol := c.Param("symbol")
 	depth, _ := strconv.Atoi(c.DefaultQuery("depth", "20"))
 
-	bids, asks := h.orderService.GetOrderBookDepth(symbol, depth)
+	snapshot := h.orderService.GetOrderBookDepth(symbol, depth)
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":       symbol,
+		"lastUpdateId": snapshot.LastUpdateID,
+		"bids":         snapshot.Bids,
+		"asks":         snapshot.Asks,
+	})
+}
+
+// GetL3 gets the order-by-order book with the sequence of the last L3
+// update it includes
+func (h *MarketHandler) GetL3(c *gin.Context) {
+	symbol := c.Param("symbol")
+
+	snapshot := h.orderService.GetOrderBookL3(symbol)
 
 	c.JSON(http.StatusOK, gin.H{
 		"symbol": symbol,
-		"bids":   bids,
-		"asks":   asks,
+		"seq":    snapshot.Seq,
+		"bids":   snapshot.Bids,
+		"asks":   snapshot.Asks,
 	})
 }
 
+// GetKlines gets OHLCV bars of an interval. startTime and endTime are in
+// milliseconds and bound the bar open times.
+func (h *MarketHandler) GetKlines(c *gin.Context) {
+	symbol := c.Param("symbol")
+	interval := c.DefaultQuery("interval", "1m")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+
+	start, end, err := timeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	klines, err := h.klines.GetKlines(symbol, interval, start, end, limit)
+	if errors.Is(err, services.ErrInvalidKlineInterval) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, klines)
+}
+
+// GetTicker gets the 24-hour ticker of one symbol, or of every symbol when
+// none is given
+func (h *MarketHandler) GetTicker(c *gin.Context) {
+	symbol := c.Query("symbol")
+	if symbol == "" {
+		c.JSON(http.StatusOK, h.tickers.GetTickers())
+		return
+	}
+
+	ticker, exists := h.tickers.GetTicker(symbol)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "symbol not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ticker)
+}
+
+// GetMarkPrice gets the index and mark price of one symbol, or of every
+// symbol when none is given
+func (h *MarketHandler) GetMarkPrice(c *gin.Context) {
+	symbol := c.Query("symbol")
+	if symbol == "" {
+		c.JSON(http.StatusOK, h.markPrices.GetMarkPrices())
+		return
+	}
+
+	price, exists := h.markPrices.GetMarkPrice(symbol)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "symbol not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, price)
+}
+
+// GetHistoricalTrades gets the public views of trades of a symbol oldest
+// first, starting at trade fromId, or the latest ones without it
+func (h *MarketHandler) GetHistoricalTrades(c *gin.Context) {
+	symbol := c.Param("symbol")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+
+	trades, err := h.orderService.GetHistoricalTrades(symbol, c.Query("fromId"), limit)
+	if errors.Is(err, services.ErrTradeNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, trades)
+}
+
 // GetTrades gets recent trades
 func (h *MarketHandler) GetTrades(c *gin.Context) {
 	symbol := c.Param("symbol")
@@ -309,6 +744,65 @@ func generateJWT(userID uint) (string, error) {
 	return token.SignedString([]byte(jwtSecret))
 }
 
+// timeRange parses the optional startTime and endTime query parameters,
+// given in milliseconds
+func timeRange(c *gin.Context) (start, end *time.Time, err error) {
+	bounds := [2]*time.Time{}
+	for i, param := range []string{"startTime", "endTime"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, nil, errors.New("invalid " + param)
+		}
+		t := time.UnixMilli(ms)
+		bounds[i] = &t
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// historyFilter parses the query parameters shared by the history
+// endpoints: symbol or a comma-separated symbols list, side, time range,
+// cursor and limit
+func historyFilter(c *gin.Context) (services.HistoryFilter, error) {
+	var filter services.HistoryFilter
+
+	filter.Symbols = splitList(c.Query("symbols"))
+	if symbol := c.Query("symbol"); symbol != "" {
+		filter.Symbols = append(filter.Symbols, symbol)
+	}
+
+	switch side := models.OrderSide(c.Query("side")); side {
+	case "", models.OrderSideBuy, models.OrderSideSell:
+		filter.Side = side
+	default:
+		return filter, errors.New("invalid side")
+	}
+
+	start, end, err := timeRange(c)
+	if err != nil {
+		return filter, err
+	}
+	filter.Start, filter.End = start, end
+
+	filter.Cursor = c.Query("cursor")
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	return filter, nil
+}
+
+// splitList splits a comma-separated query value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // getUserIDFromContext gets user ID from context
 func getUserIDFromContext(c *gin.Context) uint {
 	userID, exists := c.Get("user_id")
@@ -0,0 +1,182 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// MatchingAlgorithm names how a symbol shares a taker among the orders of a
+// price level
+type MatchingAlgorithm string
+
+const (
+	AlgorithmFIFO     MatchingAlgorithm = "fifo"      // strict time priority
+	AlgorithmProRata  MatchingAlgorithm = "pro_rata"  // in proportion to open quantity
+	AlgorithmTopOrder MatchingAlgorithm = "top_order" // front order first, then pro-rata
+)
+
+// Allocation is the part of a taker one resting order trades
+type Allocation struct {
+	Order    *models.Order
+	Quantity decimal.Decimal
+}
+
+// Allocator shares a taker's quantity among the orders of a price level.
+// Allocate is given at most the level's volume and returns each order's
+// share in the order the trades execute; shares are whole lots except for a
+// remainder smaller than a lot.
+type Allocator interface {
+	Allocate(level *PriceLevel, quantity, lot decimal.Decimal) []Allocation
+}
+
+// allocators holds the built-in matching algorithms
+var allocators = map[MatchingAlgorithm]Allocator{
+	AlgorithmFIFO:     FIFOAllocator{},
+	AlgorithmProRata:  ProRataAllocator{},
+	AlgorithmTopOrder: TopOrderAllocator{},
+}
+
+// ValidAlgorithm reports whether a matching algorithm exists; empty is FIFO
+func ValidAlgorithm(algorithm MatchingAlgorithm) bool {
+	if algorithm == "" {
+		return true
+	}
+	_, exists := allocators[algorithm]
+	return exists
+}
+
+// allocator returns the allocator of a book's symbol, FIFO by default
+func (me *MatchingEngine) allocator(ob *OrderBook) Allocator {
+	if f, exists := me.GetSymbolFilter(ob.Symbol); exists {
+		if allocator, exists := allocators[f.Algorithm]; exists {
+			return allocator
+		}
+	}
+	return FIFOAllocator{}
+}
+
+// lotSize returns the unit pro-rata shares are rounded to
+func (me *MatchingEngine) lotSize(ob *OrderBook) decimal.Decimal {
+	if f, exists := me.GetSymbolFilter(ob.Symbol); exists && f.StepSize.IsPositive() {
+		return f.StepSize
+	}
+	return decimal.New(1, -8)
+}
+
+// FIFOAllocator fills orders in time priority
+type FIFOAllocator struct{}
+
+// Allocate fills each order in full before the next
+func (FIFOAllocator) Allocate(level *PriceLevel, quantity, lot decimal.Decimal) []Allocation {
+	var allocations []Allocation
+	for e := level.Orders.Front(); e != nil && quantity.IsPositive(); e = e.Next() {
+		order := e.Value.(*models.Order)
+		share := decimal.Min(quantity, openQuantity(order))
+		allocations = append(allocations, Allocation{Order: order, Quantity: share})
+		quantity = quantity.Sub(share)
+	}
+	return allocations
+}
+
+// ProRataAllocator shares quantity in proportion to each order's open
+// quantity, so size rather than arrival earns fills
+type ProRataAllocator struct{}
+
+// Allocate shares quantity pro-rata across the whole level
+func (ProRataAllocator) Allocate(level *PriceLevel, quantity, lot decimal.Decimal) []Allocation {
+	return proRata(levelOrders(level), quantity, lot)
+}
+
+// TopOrderAllocator fills the order at the front of the queue first and
+// shares the rest pro-rata, rewarding whoever set the level
+type TopOrderAllocator struct{}
+
+// Allocate fills the front order, then shares the rest pro-rata
+func (TopOrderAllocator) Allocate(level *PriceLevel, quantity, lot decimal.Decimal) []Allocation {
+	orders := levelOrders(level)
+	if len(orders) == 0 {
+		return nil
+	}
+
+	top := Allocation{Order: orders[0], Quantity: decimal.Min(quantity, openQuantity(orders[0]))}
+	return append([]Allocation{top}, proRata(orders[1:], quantity.Sub(top.Quantity), lot)...)
+}
+
+// proRata shares quantity among orders in proportion to their open
+// quantity, rounded down to whole lots. Whole lots left over by the rounding
+// go one at a time to the orders in time priority, and a remainder smaller
+// than a lot to the oldest order with room for it.
+func proRata(orders []*models.Order, quantity, lot decimal.Decimal) []Allocation {
+	if !quantity.IsPositive() {
+		return nil
+	}
+
+	total := decimal.Zero
+	for _, order := range orders {
+		total = total.Add(openQuantity(order))
+	}
+	if !total.IsPositive() {
+		return nil
+	}
+	quantity = decimal.Min(quantity, total)
+
+	shares := make([]decimal.Decimal, len(orders))
+	left := quantity
+	for i, order := range orders {
+		exact, _ := quantity.Mul(openQuantity(order)).QuoRem(total, int32(decimal.DivisionPrecision))
+		shares[i] = exact.Div(lot).Floor().Mul(lot)
+		left = left.Sub(shares[i])
+	}
+
+	for left.GreaterThanOrEqual(lot) {
+		granted := false
+		for i, order := range orders {
+			if left.LessThan(lot) {
+				break
+			}
+			if openQuantity(order).Sub(shares[i]).GreaterThanOrEqual(lot) {
+				shares[i] = shares[i].Add(lot)
+				left = left.Sub(lot)
+				granted = true
+			}
+		}
+		if !granted {
+			break
+		}
+	}
+
+	for i, order := range orders {
+		if !left.IsPositive() {
+			break
+		}
+		extra := decimal.Min(left, openQuantity(order).Sub(shares[i]))
+		shares[i] = shares[i].Add(extra)
+		left = left.Sub(extra)
+	}
+
+	var allocations []Allocation
+	for i, order := range orders {
+		if shares[i].IsPositive() {
+			allocations = append(allocations, Allocation{Order: order, Quantity: shares[i]})
+		}
+	}
+	return allocations
+}
+
+// levelOrders returns a level's orders in time priority
+func levelOrders(level *PriceLevel) []*models.Order {
+	orders := make([]*models.Order, 0, level.Orders.Len())
+	for e := level.Orders.Front(); e != nil; e = e.Next() {
+		orders = append(orders, e.Value.(*models.Order))
+	}
+	return orders
+}
+
+// openQuantity returns the unfilled quantity of an order
+func openQuantity(order *models.Order) decimal.Decimal {
+	return order.Quantity.Sub(order.FilledQty)
+}
@@ -0,0 +1,132 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// levelWith builds a price level holding orders of the given open quantities
+func levelWith(quantities ...string) *PriceLevel {
+	level := NewPriceLevel(decimal.NewFromInt(100))
+	for i, qty := range quantities {
+		level.AddOrder(limitOrder(fmt.Sprintf("m%d", i+1), uint(i+10), models.OrderSideSell, "100", qty))
+	}
+	return level
+}
+
+// shares returns the allocated quantity per order ID
+func shares(allocations []Allocation) map[string]string {
+	byID := make(map[string]string, len(allocations))
+	for _, a := range allocations {
+		byID[a.Order.ID] = a.Quantity.String()
+	}
+	return byID
+}
+
+// TestAllocators checks how each algorithm shares a taker among a level
+func TestAllocators(t *testing.T) {
+	lot := decimal.RequireFromString("1")
+	five := decimal.RequireFromString("5")
+
+	// FIFO fills in arrival order
+	fifo := FIFOAllocator{}.Allocate(levelWith("1", "3", "6"), five, lot)
+	assert.Equal(t, map[string]string{"m1": "1", "m2": "3", "m3": "1"}, shares(fifo))
+
+	// Pro-rata gives 0.5, 1.5 and 3; the lot lost to rounding goes to the oldest order
+	proRata := ProRataAllocator{}.Allocate(levelWith("1", "3", "6"), five, lot)
+	assert.Equal(t, map[string]string{"m1": "1", "m2": "1", "m3": "3"}, shares(proRata))
+
+	// Top order fills the front order, then shares the rest pro-rata
+	top := TopOrderAllocator{}.Allocate(levelWith("1", "2", "6"), five, lot)
+	assert.Equal(t, map[string]string{"m1": "1", "m2": "1", "m3": "3"}, shares(top))
+	assert.Equal(t, "m1", top[0].Order.ID)
+
+	// Both round down to nothing; the leftover lot and the remainder smaller
+	// than a lot go to the oldest order
+	odd := ProRataAllocator{}.Allocate(levelWith("2", "2"), decimal.RequireFromString("1.5"), lot)
+	assert.Equal(t, map[string]string{"m1": "1.5"}, shares(odd))
+}
+
+// TestProRataFairness checks on random levels that pro-rata shares fill the
+// taker exactly, never exceed an order and stay within a lot of its
+// proportional share
+func TestProRataFairness(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	lot := decimal.RequireFromString("0.01")
+
+	for round := 0; round < 200; round++ {
+		quantities := make([]string, 1+rng.Intn(8))
+		for i := range quantities {
+			quantities[i] = decimal.New(int64(1+rng.Intn(1000)), -2).String()
+		}
+		level := levelWith(quantities...)
+		quantity := decimal.New(int64(1+rng.Intn(int(level.Volume.Shift(2).IntPart()))), -2)
+
+		allocations := ProRataAllocator{}.Allocate(level, quantity, lot)
+
+		total := decimal.Zero
+		for _, a := range allocations {
+			open := openQuantity(a.Order)
+			proportional := quantity.Mul(open).Div(level.Volume)
+			require.True(t, a.Quantity.LessThanOrEqual(open), "round %d: %s gets %s of %s", round, a.Order.ID, a.Quantity, open)
+			require.True(t, a.Quantity.Sub(proportional).Abs().LessThanOrEqual(lot),
+				"round %d: %s gets %s, proportional %s", round, a.Order.ID, a.Quantity, proportional)
+			total = total.Add(a.Quantity)
+		}
+		require.True(t, total.Equal(quantity), "round %d: allocated %s of %s", round, total, quantity)
+	}
+}
+
+// TestProRataMatching checks a pro-rata symbol trades every resting order
+// of the level, while FIFO symbols keep time priority
+func TestProRataMatching(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm MatchingAlgorithm
+		fills     map[string]string
+	}{
+		{AlgorithmFIFO, map[string]string{"a1": "1", "a2": "1"}},
+		{AlgorithmProRata, map[string]string{"a1": "0.5", "a2": "1.5"}},
+	} {
+		t.Run(string(tc.algorithm), func(t *testing.T) {
+			me := newTestEngine(t)
+			me.SetSymbolFilters([]*SymbolFilter{NewSymbolFilter(&models.TradingPair{
+				Symbol:            "BTC_USDT",
+				PricePrecision:    2,
+				QuantityPrecision: 3,
+				IsActive:          true,
+				MatchingAlgorithm: string(tc.algorithm),
+			})})
+
+			for _, order := range []*models.Order{
+				limitOrder("a1", 2, models.OrderSideSell, "100", "1"),
+				limitOrder("a2", 3, models.OrderSideSell, "100", "3"),
+			} {
+				_, err := me.Execute(order)
+				require.NoError(t, err)
+			}
+
+			report, err := me.Execute(limitOrder("b1", 1, models.OrderSideBuy, "100", "2"))
+			require.NoError(t, err)
+			assert.Equal(t, models.OrderStatusFilled, report.Order.Status)
+
+			fills := make(map[string]string)
+			for _, trade := range report.Trades {
+				fills[trade.SellOrderID] = trade.Quantity.String()
+			}
+			assert.Equal(t, tc.fills, fills)
+
+			ob, _ := me.GetOrderBook("BTC_USDT")
+			assert.True(t, ob.BestAskLevel().Volume.Equal(decimal.NewFromInt(2)))
+		})
+	}
+}
@@ -0,0 +1,317 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// Trading phases of a symbol
+const (
+	PhaseContinuous = "continuous"
+	PhaseAuction    = "auction"
+)
+
+// ErrAuctionOrderType is returned for orders that cannot rest in a call auction
+var ErrAuctionOrderType = errors.New("only GTC and GTD limit orders without a peg are accepted during the auction")
+
+// AuctionInfo is the indicative outcome of a call auction. It is published
+// after every change to a book in auction, and once more when it uncrosses.
+type AuctionInfo struct {
+	Symbol     string          `json:"symbol"`
+	Phase      string          `json:"phase"`
+	Price      decimal.Decimal `json:"price"`       // equilibrium price, zero if the book does not cross
+	Volume     decimal.Decimal `json:"volume"`      // quantity executable at the price
+	BuyVolume  decimal.Decimal `json:"buy_volume"`  // bids at or above the price
+	SellVolume decimal.Decimal `json:"sell_volume"` // asks at or below the price
+	EndTime    *time.Time      `json:"end_time,omitempty"`
+	Time       time.Time       `json:"time"`
+}
+
+// StartAuction puts a symbol into a call auction that uncrosses at end.
+// Orders are collected without matching until then. Calling it again moves
+// the end time.
+func (me *MatchingEngine) StartAuction(symbol string, end time.Time) error {
+	report, err := me.submit(symbol, &command{
+		kind:  CommandStartAuction,
+		until: end,
+	})
+	if err != nil {
+		return err
+	}
+	return report.Err
+}
+
+// AuctionEnd returns when a symbol's auction uncrosses, or false when the
+// symbol trades continuously
+func (me *MatchingEngine) AuctionEnd(symbol string) (time.Time, bool) {
+	ob, exists := me.GetOrderBook(symbol)
+	if !exists {
+		return time.Time{}, false
+	}
+
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if ob.auctionEnd == nil {
+		return time.Time{}, false
+	}
+	return *ob.auctionEnd, true
+}
+
+// GetIndicativePrice returns the current indicative auction outcome of a symbol
+func (me *MatchingEngine) GetIndicativePrice(symbol string) (*AuctionInfo, bool) {
+	ob, exists := me.GetOrderBook(symbol)
+	if !exists {
+		return nil, false
+	}
+
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if ob.auctionEnd == nil {
+		return nil, false
+	}
+	info := ob.equilibrium()
+	info.Phase = PhaseAuction
+	info.EndTime = ob.auctionEnd
+	info.Time = time.Now()
+	return info, true
+}
+
+// GetAuctionChan returns the channel of indicative auction updates
+func (me *MatchingEngine) GetAuctionChan() <-chan *AuctionInfo {
+	return me.auctionChan
+}
+
+// validateAuctionOrder rejects orders that would need immediate matching
+func validateAuctionOrder(order *models.Order) error {
+	if order.Type != models.OrderTypeLimit || order.PostOnly != "" || order.PegType != "" {
+		return ErrAuctionOrderType
+	}
+	if order.TimeInForce != models.TimeInForceGTC && order.TimeInForce != models.TimeInForceGTD {
+		return ErrAuctionOrderType
+	}
+	return nil
+}
+
+// applyStartAuction switches a book into auction mode and schedules the uncross
+func (me *MatchingEngine) applyStartAuction(ob *OrderBook, end time.Time, report *ExecutionReport) {
+	if end.IsZero() {
+		report.Err = errors.New("auction end time is required")
+		return
+	}
+
+	// The auction reopens a halted book; it trades continuously after the uncross
+	ob.auctionEnd = &end
+	ob.status = ""
+	ob.reopenAt = nil
+	me.expiry.scheduleCommand(end, CommandUncross, ob.Symbol, "")
+}
+
+// applyUncross executes the auction at the equilibrium price and returns the
+// book to continuous trading. Orders trade in price-time priority, and every
+// trade prices at the equilibrium; the older order of each pair is the maker.
+func (me *MatchingEngine) applyUncross(ob *OrderBook, report *ExecutionReport) {
+	if ob.auctionEnd == nil {
+		report.Err = errors.New("symbol is not in auction")
+		return
+	}
+	if ob.auctionEnd.After(report.Time) {
+		// The auction was extended after this uncross was scheduled
+		report.Err = errors.New("auction has not ended")
+		return
+	}
+
+	info := ob.equilibrium()
+	info.Phase = PhaseContinuous
+	info.Time = report.Time
+	report.Auction = info
+	ob.auctionEnd = nil
+	ob.prices.reset()
+	if info.Volume.IsZero() {
+		return
+	}
+	me.recordPrice(ob, info.Price, report.Time)
+
+	touched := make(map[string]bool)
+	touch := func(order *models.Order) {
+		if !touched[order.ID] {
+			touched[order.ID] = true
+			report.Makers = append(report.Makers, order)
+		}
+	}
+
+	for {
+		bid, ask := ob.bestLevel(models.OrderSideBuy), ob.bestLevel(models.OrderSideSell)
+		if bid == nil || ask == nil || bid.Price.LessThan(info.Price) || ask.Price.GreaterThan(info.Price) {
+			break
+		}
+		buy, sell := bid.GetFirstOrder(), ask.GetFirstOrder()
+
+		// Never trade a user against themselves: the newer order is cancelled
+		if buy.UserID == sell.UserID {
+			newer := sell
+			if buy.CreateTime.After(sell.CreateTime) {
+				newer = buy
+			}
+			me.cancelMaker(ob, newer, report)
+			touched[newer.ID] = true
+			continue
+		}
+
+		buyerIsMaker := !buy.CreateTime.After(sell.CreateTime)
+		trade := me.executeTrade(buy, sell, info.Price, decimal.Min(openQuantity(buy), openQuantity(sell)), buyerIsMaker, report)
+		if trade == nil {
+			break
+		}
+		report.Trades = append(report.Trades, trade)
+
+		for _, side := range []struct {
+			order *models.Order
+			level *PriceLevel
+		}{{buy, bid}, {sell, ask}} {
+			ob.subVolume(side.order.Side, side.level, trade.Quantity)
+			ob.recordL3(L3Execute, side.order, trade.Quantity, trade.ID)
+			if side.order.FilledQty.Equal(side.order.Quantity) {
+				side.order.Status = models.OrderStatusFilled
+				ob.removeOrder(side.order.ID)
+			} else {
+				side.order.Status = models.OrderStatusPartial
+			}
+			touch(side.order)
+		}
+	}
+}
+
+// equilibrium finds the auction price: the level price that maximizes the
+// executable volume, then minimizes the imbalance, then follows the side of
+// the imbalance. Remaining ties go to the price nearest the middle of the
+// candidates. The caller must hold the lock.
+func (ob *OrderBook) equilibrium() *AuctionInfo {
+	info := &AuctionInfo{Symbol: ob.Symbol}
+
+	var bids, asks []*PriceLevel
+	ob.bids.walk(func(level *PriceLevel) bool {
+		bids = append(bids, level)
+		return true
+	})
+	ob.asks.walk(func(level *PriceLevel) bool {
+		asks = append(asks, level)
+		return true
+	})
+	if len(bids) == 0 || len(asks) == 0 || bids[0].Price.LessThan(asks[0].Price) {
+		return info
+	}
+
+	var best []*AuctionInfo
+	for _, price := range candidatePrices(bids, asks) {
+		candidate := &AuctionInfo{Symbol: ob.Symbol, Price: price}
+		for _, level := range bids {
+			if level.Price.LessThan(price) {
+				break
+			}
+			candidate.BuyVolume = candidate.BuyVolume.Add(level.Volume)
+		}
+		for _, level := range asks {
+			if level.Price.GreaterThan(price) {
+				break
+			}
+			candidate.SellVolume = candidate.SellVolume.Add(level.Volume)
+		}
+		candidate.Volume = decimal.Min(candidate.BuyVolume, candidate.SellVolume)
+
+		if len(best) == 0 {
+			best = []*AuctionInfo{candidate}
+			continue
+		}
+		switch cmp := compareAuction(candidate, best[0]); {
+		case cmp > 0:
+			best = []*AuctionInfo{candidate}
+		case cmp == 0:
+			best = append(best, candidate)
+		}
+	}
+
+	// best is sorted by price; buy pressure lifts the price, sell pressure lowers it
+	surplus := best[0].BuyVolume.Sub(best[0].SellVolume)
+	switch {
+	case len(best) == 1:
+		return best[0]
+	case surplus.IsPositive():
+		return best[len(best)-1]
+	case surplus.IsNegative():
+		return best[0]
+	default:
+		return best[(len(best)-1)/2]
+	}
+}
+
+// compareAuction orders candidates by executable volume, then by smaller imbalance
+func compareAuction(a, b *AuctionInfo) int {
+	if cmp := a.Volume.Cmp(b.Volume); cmp != 0 {
+		return cmp
+	}
+	return b.BuyVolume.Sub(b.SellVolume).Abs().Cmp(a.BuyVolume.Sub(a.SellVolume).Abs())
+}
+
+// candidatePrices returns the crossing level prices of both sides in
+// ascending order
+func candidatePrices(bids, asks []*PriceLevel) []decimal.Decimal {
+	low, high := asks[0].Price, bids[0].Price
+
+	seen := make(map[string]bool)
+	var prices []decimal.Decimal
+	for _, levels := range [][]*PriceLevel{bids, asks} {
+		for _, level := range levels {
+			key := level.Price.String()
+			if seen[key] || level.Price.LessThan(low) || level.Price.GreaterThan(high) {
+				continue
+			}
+			seen[key] = true
+			prices = append(prices, level.Price)
+		}
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i].LessThan(prices[j]) })
+	return prices
+}
+
+// auctionInfo returns the update to publish after a command changed a book
+// in auction or uncrossed it, or nil. The caller must hold the lock.
+func (me *MatchingEngine) auctionInfo(ob *OrderBook, cmd *command, report *ExecutionReport) *AuctionInfo {
+	if cmd.replay || report.Err != nil {
+		return nil
+	}
+	if report.Auction != nil {
+		return report.Auction
+	}
+	if ob.auctionEnd == nil {
+		return nil
+	}
+
+	info := ob.equilibrium()
+	info.Phase = PhaseAuction
+	info.EndTime = ob.auctionEnd
+	info.Time = cmd.time
+	return info
+}
+
+// publishAuction sends an auction update without blocking the worker
+func (me *MatchingEngine) publishAuction(info *AuctionInfo) {
+	if info == nil {
+		return
+	}
+
+	select {
+	case me.auctionChan <- info:
+	default:
+	}
+}
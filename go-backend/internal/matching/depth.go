@@ -0,0 +1,162 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"hash/crc32"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// DepthChecksumLevels is how many levels per side the depth checksum covers
+const DepthChecksumLevels = 10
+
+// DepthUpdate is the change one command made to a symbol's aggregated book,
+// following the diff-depth protocol: every changed level takes the next
+// update ID, so FirstUpdateID is the previous update's FinalUpdateID plus
+// one. A client buffers updates, loads a depth snapshot, drops updates with
+// FinalUpdateID at or below its LastUpdateID and applies the rest in order,
+// resyncing when an update does not follow on or the checksum differs.
+type DepthUpdate struct {
+	Symbol        string           `json:"symbol"`
+	FirstUpdateID uint64           `json:"first_update_id"`
+	FinalUpdateID uint64           `json:"final_update_id"`
+	Bids          []PriceLevelInfo `json:"bids"` // changed levels, zero volume when removed
+	Asks          []PriceLevelInfo `json:"asks"`
+	Checksum      uint32           `json:"checksum"` // see DepthChecksum, zero until SetChecksum
+	Time          time.Time        `json:"time"`
+	// BestBid and BestAsk are the top of the book after the update, nil for
+	// an empty side
+	BestBid *PriceLevelInfo `json:"best_bid,omitempty"`
+	BestAsk *PriceLevelInfo `json:"best_ask,omitempty"`
+
+	// topBids and topAsks are the levels the checksum covers
+	topBids []PriceLevelInfo
+	topAsks []PriceLevelInfo
+}
+
+// SetChecksum computes the checksum of the book as of the update. It is left
+// to the publisher, so the worker never formats the book and updates nobody
+// subscribed to are never checksummed.
+func (u *DepthUpdate) SetChecksum() {
+	u.Checksum = DepthChecksum(u.topBids, u.topAsks)
+}
+
+// DepthSnapshot is the aggregated book as of LastUpdateID
+type DepthSnapshot struct {
+	Symbol       string           `json:"symbol"`
+	LastUpdateID uint64           `json:"lastUpdateId"`
+	Bids         []PriceLevelInfo `json:"bids"`
+	Asks         []PriceLevelInfo `json:"asks"`
+}
+
+// levelKey identifies a price level of one side
+type levelKey struct {
+	side  models.OrderSide
+	price string
+}
+
+// touchLevel records that a level changed during the current command; the
+// caller must hold the write lock
+func (ob *OrderBook) touchLevel(side models.OrderSide, price decimal.Decimal) {
+	ob.changed[levelKey{side: side, price: price.String()}] = true
+}
+
+// subVolume reduces a level's volume by a filled or reduced quantity
+func (ob *OrderBook) subVolume(side models.OrderSide, level *PriceLevel, qty decimal.Decimal) {
+	level.SubVolume(qty)
+	ob.touchLevel(side, level.Price)
+}
+
+// takeDepthUpdate returns the levels changed since the last call with their
+// update IDs, or nil when none changed; the caller must hold the write lock
+func (ob *OrderBook) takeDepthUpdate(now time.Time) *DepthUpdate {
+	if len(ob.changed) == 0 {
+		return nil
+	}
+
+	update := &DepthUpdate{Symbol: ob.Symbol, Time: now}
+	for key := range ob.changed {
+		levels, _ := ob.sideOf(key.side)
+		info := PriceLevelInfo{Price: decimal.RequireFromString(key.price), Volume: decimal.Zero}
+		if level, exists := levels[key.price]; exists {
+			info.Volume, info.Count = level.GetVolume(), level.GetOrderCount()
+		}
+		if key.side == models.OrderSideBuy {
+			update.Bids = append(update.Bids, info)
+		} else {
+			update.Asks = append(update.Asks, info)
+		}
+	}
+	sort.Slice(update.Bids, func(i, j int) bool { return update.Bids[i].Price.GreaterThan(update.Bids[j].Price) })
+	sort.Slice(update.Asks, func(i, j int) bool { return update.Asks[i].Price.LessThan(update.Asks[j].Price) })
+
+	update.FirstUpdateID = ob.updateID + 1
+	ob.updateID += uint64(len(ob.changed))
+	update.FinalUpdateID = ob.updateID
+	bids, asks := collectDepth(ob.bids, DepthChecksumLevels), collectDepth(ob.asks, DepthChecksumLevels)
+	update.topBids, update.topAsks = bids, asks
+	if len(bids) > 0 {
+		update.BestBid = &bids[0]
+	}
+	if len(asks) > 0 {
+		update.BestAsk = &asks[0]
+	}
+	ob.changed = make(map[levelKey]bool)
+	return update
+}
+
+// DepthChecksum returns the CRC32 (IEEE) of the top levels of a book. The
+// checksummed string interleaves the best bid and ask levels, best first, as
+// "bidPrice:bidVolume:askPrice:askVolume:..." with decimals in their shortest
+// form; a side that runs out of levels is skipped.
+func DepthChecksum(bids, asks []PriceLevelInfo) uint32 {
+	var parts []string
+	for i := 0; i < DepthChecksumLevels; i++ {
+		if i < len(bids) {
+			parts = append(parts, bids[i].Price.String(), bids[i].Volume.String())
+		}
+		if i < len(asks) {
+			parts = append(parts, asks[i].Price.String(), asks[i].Volume.String())
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(strings.Join(parts, ":")))
+}
+
+// GetDepthSnapshot returns the top depth levels of a book together with the
+// ID of the last update they include
+func (ob *OrderBook) GetDepthSnapshot(depth int) *DepthSnapshot {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return &DepthSnapshot{
+		Symbol:       ob.Symbol,
+		LastUpdateID: ob.updateID,
+		Bids:         collectDepth(ob.bids, depth),
+		Asks:         collectDepth(ob.asks, depth),
+	}
+}
+
+// GetDepthChan returns the channel of incremental depth updates. Updates are
+// dropped when it is full; clients see the gap in the update IDs and resync.
+func (me *MatchingEngine) GetDepthChan() <-chan *DepthUpdate {
+	return me.depthChan
+}
+
+// publishDepth sends a depth update without blocking the worker
+func (me *MatchingEngine) publishDepth(update *DepthUpdate) {
+	if update == nil {
+		return
+	}
+
+	select {
+	case me.depthChan <- update:
+	default:
+	}
+}
@@ -6,6 +6,8 @@ package matching
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,37 +16,304 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-// MatchingEngine handles order matching
+var (
+	// ErrEngineStopped is returned for commands submitted after Stop
+	ErrEngineStopped = errors.New("matching engine is stopped")
+	// ErrPostOnlyWouldTake is returned when a post-only order in reject mode would cross the book
+	ErrPostOnlyWouldTake = errors.New("post-only order would immediately match")
+)
+
+// DefaultMarketSlippage caps how far from the best opposite price a market
+// order may execute
+var DefaultMarketSlippage = decimal.NewFromFloat(0.05)
+
+// tradeIDNamespace derives deterministic trade IDs from command sequence numbers
+var tradeIDNamespace = uuid.NewSHA1(uuid.NameSpaceOID, []byte("easitradecoins.trade"))
+
+// MatchingEngine handles order matching. Every symbol's order book is owned
+// by a single worker goroutine fed through a command queue; the exported
+// methods are synchronous wrappers that wait for the worker's reply.
 type MatchingEngine struct {
-	orderBooks map[string]*OrderBook // symbol -> OrderBook
-	mu         sync.RWMutex
-	tradeChan  chan *models.Trade
+	orderBooks  map[string]*OrderBook    // symbol -> OrderBook
+	workers     map[string]*symbolWorker // symbol -> owning goroutine
+	mu          sync.RWMutex
+	tradeChan   chan *models.Trade
+	reportChan  chan *ExecutionReport
+	auctionChan chan *AuctionInfo
+	depthChan   chan *DepthUpdate
+	l3Chan      chan *L3Update
+	stopChan    chan struct{}
+	stopped     bool
+
+	expiry     *expiryScheduler
+	replayGate sync.RWMutex // held exclusively while the journal is replayed
+
+	journal Journal
+	seq     uint64 // last sequence number handed out
+	seqMu   sync.Mutex
+
+	marketSlippage decimal.Decimal // fraction of the best price, zero for no cap
+
+	filters   map[string]*SymbolFilter // symbol -> trading rules
+	filtersMu sync.RWMutex
+
+	fees    FeeCalculator
+	breaker *CircuitBreaker
+	l3Key   []byte // nil for a random key per book
 }
 
 // NewMatchingEngine creates a new matching engine
 func NewMatchingEngine() *MatchingEngine {
-	return &MatchingEngine{
-		orderBooks: make(map[string]*OrderBook),
-		tradeChan:  make(chan *models.Trade, 10000),
+	me := &MatchingEngine{
+		orderBooks:  make(map[string]*OrderBook),
+		workers:     make(map[string]*symbolWorker),
+		tradeChan:   make(chan *models.Trade, 10000),
+		reportChan:  make(chan *ExecutionReport, 10000),
+		auctionChan: make(chan *AuctionInfo, 1000),
+		depthChan:   make(chan *DepthUpdate, 10000),
+		l3Chan:      make(chan *L3Update, 10000),
+		stopChan:    make(chan struct{}),
+		expiry:      newExpiryScheduler(),
+		filters:     make(map[string]*SymbolFilter),
+
+		marketSlippage: DefaultMarketSlippage,
+		fees:           &FlatFeeCalculator{Rate: DefaultFeeRate},
+	}
+	go me.runExpiry()
+	return me
+}
+
+// SetJournal makes the engine write every input to journal before applying
+// it. It must be called before any order is processed.
+func (me *MatchingEngine) SetJournal(journal Journal) {
+	me.seqMu.Lock()
+	defer me.seqMu.Unlock()
+
+	me.journal = journal
+	if last := journal.LastSeq(); last > me.seq {
+		me.seq = last
+	}
+}
+
+// SetMarketSlippage sets the maximum distance, as a fraction of the best
+// opposite price, that market orders may sweep; zero removes the cap. It
+// must be called before any order is processed so replays stay consistent.
+func (me *MatchingEngine) SetMarketSlippage(slippage decimal.Decimal) {
+	me.marketSlippage = slippage
+}
+
+// SetFeeCalculator sets how the fee rates of new orders are resolved
+func (me *MatchingEngine) SetFeeCalculator(fees FeeCalculator) {
+	me.fees = fees
+}
+
+// SetSymbolFilters replaces the trading rules of all symbols. Symbols
+// without a filter accept any order.
+func (me *MatchingEngine) SetSymbolFilters(filters []*SymbolFilter) {
+	bySymbol := make(map[string]*SymbolFilter, len(filters))
+	for _, f := range filters {
+		bySymbol[f.Symbol] = f
+	}
+
+	me.filtersMu.Lock()
+	me.filters = bySymbol
+	me.filtersMu.Unlock()
+}
+
+// GetSymbolFilter returns the trading rules of a symbol
+func (me *MatchingEngine) GetSymbolFilter(symbol string) (*SymbolFilter, bool) {
+	me.filtersMu.RLock()
+	defer me.filtersMu.RUnlock()
+
+	f, exists := me.filters[symbol]
+	return f, exists
+}
+
+// LastSeq returns the sequence number of the last command handed out
+func (me *MatchingEngine) LastSeq() uint64 {
+	me.seqMu.Lock()
+	defer me.seqMu.Unlock()
+	return me.seq
+}
+
+// sequence stamps a command with the next sequence number and the engine
+// time and journals it before it is applied. Replayed commands keep the
+// stamps they were journaled with.
+func (me *MatchingEngine) sequence(symbol string, cmd *command) error {
+	me.seqMu.Lock()
+	defer me.seqMu.Unlock()
+
+	if cmd.replay {
+		if cmd.seq > me.seq {
+			me.seq = cmd.seq
+		}
+		return nil
 	}
+
+	cmd.seq = me.seq + 1
+	cmd.time = time.Now()
+
+	if me.journal != nil {
+		entry := &JournalEntry{
+			Seq:     cmd.seq,
+			Type:    cmd.kind,
+			Symbol:  symbol,
+			Time:    cmd.time,
+			OrderID: cmd.orderID,
+			Status:  cmd.status,
+		}
+		if cmd.order != nil {
+			entry.Order = snapshotOrder(cmd.order)
+		}
+		if !cmd.until.IsZero() {
+			until := cmd.until
+			entry.Until = &until
+		}
+		if err := me.journal.Append(entry); err != nil {
+			return fmt.Errorf("failed to journal command: %w", err)
+		}
+	}
+
+	me.seq = cmd.seq
+	return nil
+}
+
+// Replay re-applies journaled inputs after afterSeq and returns the trades
+// they produce. Replayed trades are not published on the trade channel.
+func (me *MatchingEngine) Replay(journal Journal, afterSeq uint64) ([]*models.Trade, error) {
+	me.replayGate.Lock()
+	defer me.replayGate.Unlock()
+	return me.replay(journal, afterSeq)
+}
+
+// replay re-applies journaled inputs; the caller must hold the replay gate
+func (me *MatchingEngine) replay(journal Journal, afterSeq uint64) ([]*models.Trade, error) {
+	var trades []*models.Trade
+
+	err := journal.Replay(afterSeq, func(entry *JournalEntry) error {
+		cmd := &command{
+			kind:    entry.Type,
+			order:   entry.Order,
+			orderID: entry.OrderID,
+			status:  entry.Status,
+			seq:     entry.Seq,
+			time:    entry.Time,
+			replay:  true,
+		}
+		if entry.Until != nil {
+			cmd.until = *entry.Until
+		}
+
+		report, err := me.submit(entry.Symbol, cmd)
+		if err != nil {
+			return err
+		}
+
+		// Rejected inputs are reproduced as rejections, not replay failures
+		trades = append(trades, report.Trades...)
+		return nil
+	})
+
+	return trades, err
 }
 
 // GetOrCreateOrderBook gets or creates an order book for a symbol
 func (me *MatchingEngine) GetOrCreateOrderBook(symbol string) *OrderBook {
+	return me.getOrCreateWorker(symbol).book
+}
+
+// getOrCreateWorker returns the worker owning a symbol, starting it if needed
+func (me *MatchingEngine) getOrCreateWorker(symbol string) *symbolWorker {
+	me.mu.RLock()
+	w, exists := me.workers[symbol]
+	me.mu.RUnlock()
+	if exists {
+		return w
+	}
+
 	me.mu.Lock()
 	defer me.mu.Unlock()
 
-	ob, exists := me.orderBooks[symbol]
-	if !exists {
-		ob = NewOrderBook(symbol)
-		me.orderBooks[symbol] = ob
+	if w, exists = me.workers[symbol]; exists {
+		return w
+	}
+
+	ob := NewOrderBook(symbol)
+	if me.l3Key != nil {
+		ob.l3Key = me.l3Key
+	}
+	w = newSymbolWorker(ob)
+	me.orderBooks[symbol] = ob
+	me.workers[symbol] = w
+	if !me.stopped {
+		go w.run(me)
+	}
+
+	return w
+}
+
+// submit queues a command on the symbol's worker and waits for the report
+func (me *MatchingEngine) submit(symbol string, cmd *command) (*ExecutionReport, error) {
+	w := me.getOrCreateWorker(symbol)
+	cmd.reply = make(chan *ExecutionReport, 1)
+
+	me.mu.RLock()
+	if me.stopped {
+		me.mu.RUnlock()
+		return nil, ErrEngineStopped
 	}
+	w.commands <- cmd
+	me.mu.RUnlock()
 
-	return ob
+	return <-cmd.reply, nil
 }
 
-// ProcessOrder processes a new order
+// submitAsync queues an engine-initiated command; its report is published
+// on the report channel
+func (me *MatchingEngine) submitAsync(symbol string, cmd *command) {
+	w := me.getOrCreateWorker(symbol)
+
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	if !me.stopped {
+		w.commands <- cmd
+	}
+}
+
+// Stop drains every command queue and stops the symbol workers
+func (me *MatchingEngine) Stop() {
+	me.mu.Lock()
+	if me.stopped {
+		me.mu.Unlock()
+		return
+	}
+	me.stopped = true
+	close(me.stopChan)
+	workers := make([]*symbolWorker, 0, len(me.workers))
+	for _, w := range me.workers {
+		close(w.commands)
+		workers = append(workers, w)
+	}
+	me.mu.Unlock()
+
+	for _, w := range workers {
+		<-w.done
+	}
+}
+
+// ProcessOrder processes a new order. The caller's order is updated with the
+// engine's view of it once matching is complete.
 func (me *MatchingEngine) ProcessOrder(order *models.Order) ([]*models.Trade, error) {
+	report, err := me.Execute(order)
+	if err != nil {
+		return nil, err
+	}
+	return report.Trades, nil
+}
+
+// Execute processes a new order and returns the full execution report,
+// including snapshots of the resting orders it traded against
+func (me *MatchingEngine) Execute(order *models.Order) (*ExecutionReport, error) {
 	if order == nil {
 		return nil, errors.New("order is nil")
 	}
@@ -54,306 +323,711 @@ func (me *MatchingEngine) ProcessOrder(order *models.Order) ([]*models.Trade, er
 		return nil, err
 	}
 
-	ob := me.GetOrCreateOrderBook(order.Symbol)
+	if order.ID == "" {
+		order.ID = uuid.New().String()
+	}
+	if order.CreateTime.IsZero() {
+		order.CreateTime = time.Now()
+	}
 
-	var trades []*models.Trade
+	// Fix the fee rates now so a replay charges what was charged originally
+	makerRate, takerRate := me.fees.GetFeeRates(order.UserID, order.Symbol)
+	order.MakerFeeRate, order.TakerFeeRate = &makerRate, &takerRate
 
-	// Match market order or limit order
-	if order.Type == models.OrderTypeMarket {
-		trades = me.matchMarketOrder(ob, order)
-	} else {
-		trades = me.matchLimitOrder(ob, order)
+	report, err := me.submit(order.Symbol, &command{
+		kind:  CommandNewOrder,
+		order: snapshotOrder(order),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report.Err != nil {
+		return nil, report.Err
 	}
 
-	// If order is not fully filled and not IOC/FOK, add to order book
-	if order.Status == models.OrderStatusPending || order.Status == models.OrderStatusPartial {
-		if order.TimeInForce == models.TimeInForceGTC {
-			ob.AddOrder(order)
-		} else if order.TimeInForce == models.TimeInForceIOC {
-			// IOC: cancel remaining
-			order.Status = models.OrderStatusCancelled
-		} else if order.TimeInForce == models.TimeInForceFOK {
-			// FOK: if not fully filled, cancel
-			if !order.FilledQty.Equal(order.Quantity) {
-				order.Status = models.OrderStatusCancelled
-				// Rollback trades (in real implementation, use database transaction)
-				trades = nil
-			}
+	*order = *report.Order
+	return report, nil
+}
+
+// CancelOrder cancels an order
+func (me *MatchingEngine) CancelOrder(symbol, orderID string) error {
+	_, err := me.Cancel(symbol, orderID)
+	return err
+}
+
+// Cancel cancels an order and returns the execution report, whose Makers
+// hold the pegged orders repriced by the cancel
+func (me *MatchingEngine) Cancel(symbol, orderID string) (*ExecutionReport, error) {
+	report, err := me.submit(symbol, &command{
+		kind:    CommandCancelOrder,
+		orderID: orderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report.Err != nil {
+		return nil, report.Err
+	}
+	return report, nil
+}
+
+// AmendOrder changes the price and/or quantity of a resting order; a zero
+// price or quantity keeps the current value. Reducing the quantity keeps the
+// order's queue position, while a price change or a quantity increase
+// re-queues it and may match it against the book.
+func (me *MatchingEngine) AmendOrder(symbol, orderID string, userID uint, price, quantity decimal.Decimal) (*ExecutionReport, error) {
+	ob, exists := me.GetOrderBook(symbol)
+	if !exists {
+		return nil, errors.New("order not found")
+	}
+	current, exists := ob.GetOrder(orderID)
+	if !exists {
+		return nil, errors.New("order not found")
+	}
+
+	// Validate the amended order against the symbol rules up front
+	amended := current
+	if price.IsPositive() {
+		amended.Price = price
+	}
+	if quantity.IsPositive() {
+		amended.Quantity = quantity
+	}
+	if f, exists := me.GetSymbolFilter(symbol); exists {
+		if err := f.Validate(amended); err != nil {
+			return nil, err
 		}
 	}
 
-	// Send trades to channel
-	for _, trade := range trades {
-		me.tradeChan <- trade
+	report, err := me.submit(symbol, &command{
+		kind: CommandAmendOrder,
+		order: &models.Order{
+			ID:       orderID,
+			UserID:   userID,
+			Price:    price,
+			Quantity: quantity,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report.Err != nil {
+		return nil, report.Err
 	}
+	return report, nil
+}
 
-	return trades, nil
+// MassCancel cancels all of a user's resting orders on a symbol, or only
+// those on one side when side is set, as a single engine command. The
+// cancelled orders are returned in report.Cancelled.
+func (me *MatchingEngine) MassCancel(symbol string, userID uint, side models.OrderSide, reason string) (*ExecutionReport, error) {
+	if userID == 0 {
+		return nil, errors.New("user id is required")
+	}
+
+	report, err := me.submit(symbol, &command{
+		kind: CommandMassCancel,
+		order: &models.Order{
+			UserID:       userID,
+			Symbol:       symbol,
+			Side:         side,
+			CancelReason: reason,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if report.Err != nil {
+		return nil, report.Err
+	}
+	return report, nil
 }
 
-// matchLimitOrder matches a limit order
-func (me *MatchingEngine) matchLimitOrder(ob *OrderBook, order *models.Order) []*models.Trade {
-	var trades []*models.Trade
+// apply executes one command against a book. It runs on the book's worker
+// goroutine with the book write lock held.
+func (me *MatchingEngine) apply(ob *OrderBook, cmd *command, report *ExecutionReport) {
+	if err := checkStatus(ob, cmd.kind); err != nil {
+		report.Err = err
+		return
+	}
 
-	order.Status = models.OrderStatusPending
+	switch cmd.kind {
+	case CommandNewOrder:
+		me.applyNewOrder(ob, cmd.order, report)
+	case CommandCancelOrder:
+		me.applyCancel(ob, cmd.orderID, report)
+	case CommandExpireOrder:
+		me.applyExpire(ob, cmd.orderID, report)
+	case CommandAmendOrder:
+		me.applyAmend(ob, cmd.order, report)
+	case CommandMassCancel:
+		me.applyMassCancel(ob, cmd.order, report)
+	case CommandStartAuction:
+		me.applyStartAuction(ob, cmd.until, report)
+	case CommandUncross:
+		me.applyUncross(ob, report)
+	case CommandSetStatus:
+		me.applySetStatus(ob, cmd.status, report)
+	case CommandReopen:
+		me.applyReopen(ob, report)
+	case CommandTriggerPrice:
+		me.applyTriggerPrice(ob, cmd.order)
+	default:
+		report.Err = fmt.Errorf("unknown command %q", cmd.kind)
+	}
 
-	// Match buy order
-	if order.Side == models.OrderSideBuy {
-		bestAsk, hasAsk := ob.GetBestAsk()
+	// Conditional orders fire in sequence with the prices the command
+	// produced, then pegged orders follow the new top of book
+	if report.Err == nil {
+		me.runTriggers(ob, report)
+		me.repricePegs(ob, report)
+	}
+}
 
-		for hasAsk && bestAsk.LessThanOrEqual(order.Price) {
-			level := ob.SellLevels[bestAsk.String()]
-			if level == nil {
-				break
-			}
+// applyNewOrder matches a new order and rests any GTC or GTD remainder;
+// conditional orders go to the trigger book
+func (me *MatchingEngine) applyNewOrder(ob *OrderBook, order *models.Order, report *ExecutionReport) {
+	report.Order = order
 
-			makerOrder := level.GetFirstOrder()
-			if makerOrder == nil {
-				break
-			}
+	if _, exists := ob.openOrder(order.ID); exists {
+		report.Err = errors.New("duplicate order id")
+		return
+	}
 
-			// Execute trade
-			trade := me.executeTrade(order, makerOrder, bestAsk)
-			if trade != nil {
-				trades = append(trades, trade)
-			}
+	var err error
+	if IsConditional(order) {
+		err = me.applyConditional(ob, order, report)
+	} else {
+		err = me.executeOrder(ob, order, report)
+	}
+	if err != nil {
+		report.Err = err
+	}
+}
 
-			// Update maker order
-			if makerOrder.FilledQty.Equal(makerOrder.Quantity) {
-				makerOrder.Status = models.OrderStatusFilled
-				ob.RemoveOrder(makerOrder.ID)
-			} else {
-				makerOrder.Status = models.OrderStatusPartial
-				level.UpdateVolume()
-			}
+// executeOrder matches an order and rests its remainder. A rejected order
+// is cancelled and the reason returned.
+func (me *MatchingEngine) executeOrder(ob *OrderBook, order *models.Order, report *ExecutionReport) error {
+	// Call auctions collect orders without matching them
+	if ob.auctionEnd != nil {
+		if err := validateAuctionOrder(order); err != nil {
+			order.Status = models.OrderStatusCancelled
+			return err
+		}
+		order.Status = models.OrderStatusPending
+		me.restOrder(ob, order, report)
+		return nil
+	}
 
-			// Check if taker order is filled
-			if order.FilledQty.Equal(order.Quantity) {
-				order.Status = models.OrderStatusFilled
-				break
-			}
+	// Pegged orders rest at their pegged price without matching
+	if order.PegType != "" {
+		return me.applyPeg(ob, order, report)
+	}
+
+	// In post-only mode every order is post-only
+	if err := applyPostOnlyStatus(ob, order); err != nil {
+		order.Status = models.OrderStatusCancelled
+		return err
+	}
 
-			// Get next best ask
-			bestAsk, hasAsk = ob.GetBestAsk()
+	// Post-only orders must never take liquidity
+	if order.PostOnly != "" {
+		if err := me.applyPostOnly(ob, order); err != nil {
+			order.Status = models.OrderStatusCancelled
+			return err
 		}
+	}
+
+	// Market orders may only sweep down to the slippage bound
+	limit := me.priceLimit(ob, order)
+
+	// FOK orders are killed before touching the book if they cannot fill,
+	// including fills the circuit breaker would stop
+	if order.TimeInForce == models.TimeInForceFOK &&
+		ob.fillableQuantity(order, tighterLimit(order.Side, limit, me.breakerLimit(ob, order.Side, report.Time))).LessThan(order.Quantity) {
+		order.Status = models.OrderStatusCancelled
+		return nil
+	}
+
+	// Match market order or limit order
+	if order.Type == models.OrderTypeMarket {
+		me.matchMarketOrder(ob, order, limit, report)
 	} else {
-		// Match sell order
-		bestBid, hasBid := ob.GetBestBid()
+		me.matchLimitOrder(ob, order, limit, report)
+	}
 
-		for hasBid && bestBid.GreaterThanOrEqual(order.Price) {
-			level := ob.BuyLevels[bestBid.String()]
-			if level == nil {
-				break
-			}
+	// If order is not fully filled and not IOC, add to order book
+	if order.Status == models.OrderStatusPending || order.Status == models.OrderStatusPartial {
+		me.restOrder(ob, order, report)
+	}
+	return nil
+}
 
-			makerOrder := level.GetFirstOrder()
-			if makerOrder == nil {
-				break
-			}
+// restOrder rests the open part of an order according to its time in force
+func (me *MatchingEngine) restOrder(ob *OrderBook, order *models.Order, report *ExecutionReport) {
+	switch order.TimeInForce {
+	case models.TimeInForceGTC:
+		ob.addOrder(order)
+	case models.TimeInForceGTD:
+		if !order.ExpireTime.After(report.Time) {
+			order.Status = models.OrderStatusCancelled
+			order.CancelReason = models.CancelReasonExpired
+			break
+		}
+		ob.addOrder(order)
+		me.expiry.schedule(*order.ExpireTime, ob.Symbol, order.ID)
+	case models.TimeInForceIOC, models.TimeInForceFOK:
+		// IOC: cancel remaining; FOK was checked to fill in full
+		order.Status = models.OrderStatusCancelled
+	}
+}
 
-			// Execute trade
-			trade := me.executeTrade(makerOrder, order, bestBid)
-			if trade != nil {
-				trades = append(trades, trade)
-			}
+// applyPostOnly rejects or reprices a post-only order that would cross the book
+func (me *MatchingEngine) applyPostOnly(ob *OrderBook, order *models.Order) error {
+	opposite := ob.bestLevel(oppositeSide(order.Side))
+	if opposite == nil {
+		return nil
+	}
 
-			// Update maker order
-			if makerOrder.FilledQty.Equal(makerOrder.Quantity) {
-				makerOrder.Status = models.OrderStatusFilled
-				ob.RemoveOrder(makerOrder.ID)
-			} else {
-				makerOrder.Status = models.OrderStatusPartial
-				level.UpdateVolume()
-			}
+	if !crosses(order.Side, order.Price, opposite.Price) {
+		return nil
+	}
 
-			// Check if taker order is filled
-			if order.FilledQty.Equal(order.Quantity) {
-				order.Status = models.OrderStatusFilled
-				break
-			}
+	if order.PostOnly != models.PostOnlyReprice {
+		return ErrPostOnlyWouldTake
+	}
 
-			// Get next best bid
-			bestBid, hasBid = ob.GetBestBid()
-		}
+	// Slide one tick behind the opposite best so the order rests as a maker
+	tick := me.tickSize(ob, opposite.Price)
+	if order.Side == models.OrderSideBuy {
+		order.Price = opposite.Price.Sub(tick)
+	} else {
+		order.Price = opposite.Price.Add(tick)
+	}
+	if order.Price.LessThanOrEqual(decimal.Zero) {
+		return ErrPostOnlyWouldTake
 	}
 
-	return trades
+	return nil
 }
 
-// matchMarketOrder matches a market order
-func (me *MatchingEngine) matchMarketOrder(ob *OrderBook, order *models.Order) []*models.Trade {
-	var trades []*models.Trade
+// tickSize returns the symbol's minimum price increment, falling back to
+// the number of decimals the reference price is quoted with
+func (me *MatchingEngine) tickSize(ob *OrderBook, reference decimal.Decimal) decimal.Decimal {
+	if f, exists := me.GetSymbolFilter(ob.Symbol); exists {
+		return f.TickSize
+	}
+	if exp := reference.Exponent(); exp < 0 {
+		return decimal.New(1, exp)
+	}
+	return decimal.NewFromInt(1)
+}
 
-	order.Status = models.OrderStatusPending
+// priceLimit returns the worst price an order may trade at: the limit price
+// for limit orders and the slippage bound for market orders. It returns nil
+// when the order may sweep the whole book.
+func (me *MatchingEngine) priceLimit(ob *OrderBook, order *models.Order) *decimal.Decimal {
+	if order.Type != models.OrderTypeMarket {
+		return &order.Price
+	}
 
-	// Market buy order
-	if order.Side == models.OrderSideBuy {
-		bestAsk, hasAsk := ob.GetBestAsk()
+	best := ob.bestLevel(oppositeSide(order.Side))
+	if best == nil || me.marketSlippage.IsZero() {
+		return nil
+	}
 
-		for hasAsk && order.FilledQty.LessThan(order.Quantity) {
-			level := ob.SellLevels[bestAsk.String()]
-			if level == nil {
-				break
-			}
+	limit := best.Price.Mul(decimal.NewFromInt(1).Add(me.marketSlippage))
+	if order.Side == models.OrderSideSell {
+		limit = best.Price.Mul(decimal.NewFromInt(1).Sub(me.marketSlippage))
+	}
+	return &limit
+}
 
-			makerOrder := level.GetFirstOrder()
-			if makerOrder == nil {
-				break
-			}
+// tighterLimit returns the more restrictive of two optional price limits
+func tighterLimit(side models.OrderSide, a, b *decimal.Decimal) *decimal.Decimal {
+	if a == nil {
+		return b
+	}
+	if b == nil || crosses(side, *b, *a) {
+		return a
+	}
+	return b
+}
 
-			// Execute trade at maker's price
-			trade := me.executeTrade(order, makerOrder, makerOrder.Price)
-			if trade != nil {
-				trades = append(trades, trade)
-			}
+// crosses reports whether a taker on side with the given limit price can
+// trade against a resting price
+func crosses(side models.OrderSide, limit, price decimal.Decimal) bool {
+	if side == models.OrderSideBuy {
+		return limit.GreaterThanOrEqual(price)
+	}
+	return limit.LessThanOrEqual(price)
+}
 
-			// Update maker order
-			if makerOrder.FilledQty.Equal(makerOrder.Quantity) {
-				makerOrder.Status = models.OrderStatusFilled
-				ob.RemoveOrder(makerOrder.ID)
-			} else {
-				makerOrder.Status = models.OrderStatusPartial
-				level.UpdateVolume()
-			}
+// oppositeSide returns the side an order matches against
+func oppositeSide(side models.OrderSide) models.OrderSide {
+	if side == models.OrderSideBuy {
+		return models.OrderSideSell
+	}
+	return models.OrderSideBuy
+}
 
-			// Check if taker order is filled
-			if order.FilledQty.Equal(order.Quantity) {
-				order.Status = models.OrderStatusFilled
-				break
-			}
+// applyCancel removes a resting or conditional order from the book
+func (me *MatchingEngine) applyCancel(ob *OrderBook, orderID string, report *ExecutionReport) {
+	order, exists := ob.openOrder(orderID)
+	if !exists {
+		report.Err = errors.New("order not found")
+		return
+	}
 
-			// Get next best ask
-			bestAsk, hasAsk = ob.GetBestAsk()
+	if order.Status == models.OrderStatusFilled || order.Status == models.OrderStatusCancelled {
+		report.Err = errors.New("order cannot be cancelled")
+		return
+	}
+
+	ob.removeOpenOrder(orderID)
+	order.Status = models.OrderStatusCancelled
+	order.CancelReason = models.CancelReasonUser
+	order.UpdateTime = report.Time
+	report.Order = order
+}
+
+// applyAmend changes a resting order's price and/or quantity
+func (me *MatchingEngine) applyAmend(ob *OrderBook, amend *models.Order, report *ExecutionReport) {
+	order, exists := ob.OrderMap[amend.ID]
+	if !exists || (amend.UserID != 0 && amend.UserID != order.UserID) {
+		report.Err = errors.New("order not found")
+		return
+	}
+
+	if order.PegType != "" && amend.Price.IsPositive() {
+		report.Err = ErrPeggedPrice
+		return
+	}
+
+	candidate := *order
+	if amend.Price.IsPositive() {
+		candidate.Price = amend.Price
+	}
+	if amend.Quantity.IsPositive() {
+		candidate.Quantity = amend.Quantity
+	}
+	if !candidate.Quantity.GreaterThan(order.FilledQty) {
+		report.Err = errors.New("quantity must exceed filled quantity")
+		return
+	}
+
+	priceChanged := !candidate.Price.Equal(order.Price)
+	if ob.tradingStatus() == StatusPostOnly && candidate.PostOnly == "" {
+		candidate.PostOnly = models.PostOnlyReject
+	}
+	if priceChanged && candidate.PostOnly != "" {
+		if err := me.applyPostOnly(ob, &candidate); err != nil {
+			report.Err = err
+			return
 		}
-	} else {
-		// Market sell order
-		bestBid, hasBid := ob.GetBestBid()
+		priceChanged = !candidate.Price.Equal(order.Price)
+	}
 
-		for hasBid && order.FilledQty.LessThan(order.Quantity) {
-			level := ob.BuyLevels[bestBid.String()]
-			if level == nil {
-				break
-			}
+	report.Order = order
+	order.UpdateTime = report.Time
 
-			makerOrder := level.GetFirstOrder()
-			if makerOrder == nil {
-				break
-			}
+	// Shrinking in place keeps time priority
+	if !priceChanged && candidate.Quantity.LessThanOrEqual(order.Quantity) {
+		ob.subVolume(order.Side, ob.levelOf(order), order.Quantity.Sub(candidate.Quantity))
+		order.Quantity = candidate.Quantity
+		ob.recordL3(L3Modify, order, openQuantity(order), "")
+		return
+	}
 
-			// Execute trade at maker's price
-			trade := me.executeTrade(makerOrder, order, makerOrder.Price)
-			if trade != nil {
-				trades = append(trades, trade)
-			}
+	// Anything else goes to the back of the queue, possibly at a crossing price
+	ob.removeOrder(order.ID)
+	order.Price = candidate.Price
+	order.Quantity = candidate.Quantity
+	if ob.auctionEnd != nil {
+		ob.addOrder(order)
+		return
+	}
+	me.matchLimitOrder(ob, order, &order.Price, report)
+	if order.Status == models.OrderStatusPending || order.Status == models.OrderStatusPartial {
+		ob.addOrder(order)
+	}
+}
 
-			// Update maker order
-			if makerOrder.FilledQty.Equal(makerOrder.Quantity) {
-				makerOrder.Status = models.OrderStatusFilled
-				ob.RemoveOrder(makerOrder.ID)
-			} else {
-				makerOrder.Status = models.OrderStatusPartial
-				level.UpdateVolume()
+// applyMassCancel removes every resting and conditional order matching the
+// target's user and, if set, side. Orders are cancelled oldest first so the
+// report is the same on replay.
+func (me *MatchingEngine) applyMassCancel(ob *OrderBook, target *models.Order, report *ExecutionReport) {
+	var orders []*models.Order
+	for _, open := range []map[string]*models.Order{ob.OrderMap, ob.triggers.orders} {
+		for _, order := range open {
+			if order.UserID != target.UserID || (target.Side != "" && order.Side != target.Side) {
+				continue
 			}
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreateTime.Equal(orders[j].CreateTime) {
+			return orders[i].CreateTime.Before(orders[j].CreateTime)
+		}
+		return orders[i].ID < orders[j].ID
+	})
 
-			// Check if taker order is filled
-			if order.FilledQty.Equal(order.Quantity) {
-				order.Status = models.OrderStatusFilled
-				break
-			}
+	reason := target.CancelReason
+	if reason == "" {
+		reason = models.CancelReasonUser
+	}
+
+	for _, order := range orders {
+		ob.removeOpenOrder(order.ID)
+		order.Status = models.OrderStatusCancelled
+		order.CancelReason = reason
+		order.UpdateTime = report.Time
+		report.Cancelled = append(report.Cancelled, order)
+	}
+}
+
+// applyExpire cancels a GTD order whose expiry time has passed
+func (me *MatchingEngine) applyExpire(ob *OrderBook, orderID string, report *ExecutionReport) {
+	order, exists := ob.openOrder(orderID)
+	if !exists || order.ExpireTime == nil {
+		report.Err = errors.New("order not found")
+		return
+	}
 
-			// Get next best bid
-			bestBid, hasBid = ob.GetBestBid()
+	if order.ExpireTime.After(report.Time) {
+		report.Err = errors.New("order has not expired")
+		return
+	}
+
+	ob.removeOpenOrder(orderID)
+	order.Status = models.OrderStatusCancelled
+	order.CancelReason = models.CancelReasonExpired
+	order.UpdateTime = report.Time
+	report.Order = order
+}
+
+// matchLimitOrder matches a limit order
+func (me *MatchingEngine) matchLimitOrder(ob *OrderBook, order *models.Order, limit *decimal.Decimal, report *ExecutionReport) {
+	order.Status = models.OrderStatusPending
+	me.matchAgainstBook(ob, order, limit, report)
+}
+
+// matchMarketOrder matches a market order
+func (me *MatchingEngine) matchMarketOrder(ob *OrderBook, order *models.Order, limit *decimal.Decimal, report *ExecutionReport) {
+	order.Status = models.OrderStatusPending
+
+	// Orders sized in quote currency start with what the budget buys at the best ask
+	if order.QuoteOrderQty != nil && order.Quantity.IsZero() {
+		if best := ob.bestLevel(models.OrderSideSell); best != nil {
+			order.Quantity = me.affordable(ob, *order.QuoteOrderQty, best.Price)
 		}
 	}
 
+	me.matchAgainstBook(ob, order, limit, report)
+
 	// Market order must be filled or cancelled
-	if !order.FilledQty.Equal(order.Quantity) {
+	if !order.FilledQty.Equal(order.Quantity) || order.FilledQty.IsZero() {
 		order.Status = models.OrderStatusCancelled
 	}
-
-	return trades
 }
 
-// executeTrade executes a trade between two orders
-func (me *MatchingEngine) executeTrade(buyOrder, sellOrder *models.Order, price decimal.Decimal) *models.Trade {
-	// Calculate trade quantity
-	buyRemaining := buyOrder.Quantity.Sub(buyOrder.FilledQty)
-	sellRemaining := sellOrder.Quantity.Sub(sellOrder.FilledQty)
+// matchAgainstBook walks the opposite side from the best price, sharing the
+// taker among each level's resting orders by the symbol's matching
+// algorithm. Unless limit is nil the walk stops at the first level that no
+// longer crosses it.
+func (me *MatchingEngine) matchAgainstBook(ob *OrderBook, order *models.Order, limit *decimal.Decimal, report *ExecutionReport) {
+	opposite := oppositeSide(order.Side)
+	allocator := me.allocator(ob)
+
+	var plan []Allocation // what is left of the taker's allocation at planLevel
+	var planLevel *PriceLevel
+	for order.FilledQty.LessThan(order.Quantity) {
+		level := ob.bestLevel(opposite)
+		if level == nil || (limit != nil && !crosses(order.Side, *limit, level.Price)) {
+			break
+		}
 
-	var tradeQty decimal.Decimal
-	if buyRemaining.LessThan(sellRemaining) {
-		tradeQty = buyRemaining
-	} else {
-		tradeQty = sellRemaining
+		// Quote budgets only buy what they still afford at this price
+		if order.QuoteOrderQty != nil && !me.capToBudget(ob, order, level.Price) {
+			break
+		}
+
+		if level != planLevel || len(plan) == 0 {
+			want := decimal.Min(openQuantity(order), level.Volume)
+			plan, planLevel = allocator.Allocate(level, want, me.lotSize(ob)), level
+			if len(plan) == 0 {
+				break
+			}
+		}
+		makerOrder, share := plan[0].Order, plan[0].Quantity
+		plan = plan[1:]
+
+		// Stop before a trade that moves the price too far, too fast
+		if me.breakerTrips(ob, level.Price, report.Time) {
+			me.haltForBreaker(ob, report)
+			break
+		}
+
+		// Never trade a user against themselves; the level is shared again
+		if makerOrder.UserID == order.UserID {
+			plan = nil
+			if !me.preventSelfTrade(ob, order, makerOrder, level, report) {
+				break
+			}
+			continue
+		}
+
+		// Execute trade at maker's price
+		var trade *models.Trade
+		quantity := decimal.Min(share, openQuantity(order))
+		if order.Side == models.OrderSideBuy {
+			trade = me.executeTrade(order, makerOrder, level.Price, quantity, false, report)
+		} else {
+			trade = me.executeTrade(makerOrder, order, level.Price, quantity, true, report)
+		}
+		if trade == nil {
+			break
+		}
+		report.Trades = append(report.Trades, trade)
+		report.Makers = append(report.Makers, makerOrder)
+		ob.subVolume(opposite, level, trade.Quantity)
+		ob.recordL3(L3Execute, makerOrder, trade.Quantity, trade.ID)
+		me.recordPrice(ob, trade.Price, report.Time)
+
+		// Update maker order
+		if makerOrder.FilledQty.Equal(makerOrder.Quantity) {
+			makerOrder.Status = models.OrderStatusFilled
+			ob.removeOrder(makerOrder.ID)
+		} else {
+			makerOrder.Status = models.OrderStatusPartial
+		}
 	}
 
-	if tradeQty.LessThanOrEqual(decimal.Zero) {
-		return nil
+	// Check if taker order is filled
+	if order.Status == models.OrderStatusCancelled {
+		return
+	}
+	if order.FilledQty.Equal(order.Quantity) {
+		order.Status = models.OrderStatusFilled
+	} else if order.FilledQty.GreaterThan(decimal.Zero) {
+		order.Status = models.OrderStatusPartial
 	}
+}
 
-	// Calculate trade amount
-	tradeAmount := tradeQty.Mul(price)
+// preventSelfTrade resolves a taker meeting a resting order of the same user
+// according to the taker's STP mode. It returns false when the taker must
+// stop matching.
+func (me *MatchingEngine) preventSelfTrade(ob *OrderBook, taker, maker *models.Order, level *PriceLevel, report *ExecutionReport) bool {
+	switch stpMode(taker) {
+	case models.STPCancelOldest:
+		me.cancelMaker(ob, maker, report)
+		return true
+	case models.STPCancelBoth:
+		me.cancelMaker(ob, maker, report)
+		cancelForSelfTrade(taker, report)
+		return false
+	case models.STPDecrementCancel:
+		overlap := decimal.Min(taker.Quantity.Sub(taker.FilledQty), maker.Quantity.Sub(maker.FilledQty))
+		taker.Quantity = taker.Quantity.Sub(overlap)
+		maker.Quantity = maker.Quantity.Sub(overlap)
+		ob.subVolume(maker.Side, level, overlap)
+		ob.recordL3(L3Modify, maker, openQuantity(maker), "")
+
+		if maker.FilledQty.Equal(maker.Quantity) {
+			me.cancelMaker(ob, maker, report)
+		} else {
+			maker.UpdateTime = report.Time
+			report.Makers = append(report.Makers, maker)
+		}
+		if taker.FilledQty.Equal(taker.Quantity) {
+			cancelForSelfTrade(taker, report)
+			return false
+		}
+		return true
+	default:
+		cancelForSelfTrade(taker, report)
+		return false
+	}
+}
 
-	// Calculate fees (0.1% for simplicity)
-	feeRate := decimal.NewFromFloat(0.001)
-	buyerFee := tradeAmount.Mul(feeRate)
-	sellerFee := tradeQty.Mul(feeRate)
+// cancelMaker removes a resting order cancelled by self-trade prevention
+func (me *MatchingEngine) cancelMaker(ob *OrderBook, maker *models.Order, report *ExecutionReport) {
+	ob.removeOrder(maker.ID)
+	cancelForSelfTrade(maker, report)
+	report.Makers = append(report.Makers, maker)
+}
 
-	// Update orders
-	buyOrder.FilledQty = buyOrder.FilledQty.Add(tradeQty)
-	buyOrder.FilledAmount = buyOrder.FilledAmount.Add(tradeAmount)
-	buyOrder.Fee = buyOrder.Fee.Add(buyerFee)
-	buyOrder.UpdateTime = time.Now()
+// cancelForSelfTrade marks an order cancelled by self-trade prevention
+func cancelForSelfTrade(order *models.Order, report *ExecutionReport) {
+	order.Status = models.OrderStatusCancelled
+	order.CancelReason = models.CancelReasonSelfTrade
+	order.UpdateTime = report.Time
+}
 
-	if buyOrder.FilledQty.GreaterThan(decimal.Zero) {
-		buyOrder.AvgPrice = buyOrder.FilledAmount.Div(buyOrder.FilledQty)
+// stpMode returns the order's self-trade prevention mode, defaulting to
+// cancelling the incoming order
+func stpMode(order *models.Order) models.STPMode {
+	if order.STPMode == "" {
+		return models.STPCancelNewest
 	}
+	return order.STPMode
+}
 
-	sellOrder.FilledQty = sellOrder.FilledQty.Add(tradeQty)
-	sellOrder.FilledAmount = sellOrder.FilledAmount.Add(tradeAmount)
-	sellOrder.Fee = sellOrder.Fee.Add(sellerFee)
-	sellOrder.UpdateTime = time.Now()
-
-	if sellOrder.FilledQty.GreaterThan(decimal.Zero) {
-		sellOrder.AvgPrice = sellOrder.FilledAmount.Div(sellOrder.FilledQty)
+// executeTrade executes a trade of tradeQty between two orders, which must
+// both have that much open. Trade IDs and times are derived from the command
+// so that a journal replay reproduces them exactly.
+func (me *MatchingEngine) executeTrade(buyOrder, sellOrder *models.Order, price, tradeQty decimal.Decimal, buyerIsMaker bool, report *ExecutionReport) *models.Trade {
+	if tradeQty.LessThanOrEqual(decimal.Zero) {
+		return nil
 	}
 
 	// Create trade record
 	trade := &models.Trade{
-		ID:          uuid.New().String(),
-		Symbol:      buyOrder.Symbol,
-		BuyOrderID:  buyOrder.ID,
-		SellOrderID: sellOrder.ID,
-		BuyerID:     buyOrder.UserID,
-		SellerID:    sellOrder.UserID,
-		Price:       price,
-		Quantity:    tradeQty,
-		Amount:      tradeAmount,
-		BuyerFee:    buyerFee,
-		SellerFee:   sellerFee,
-		TradeTime:   time.Now(),
+		ID:           uuid.NewSHA1(tradeIDNamespace, []byte(fmt.Sprintf("%d:%d", report.Seq, len(report.Trades)))).String(),
+		Symbol:       buyOrder.Symbol,
+		BuyOrderID:   buyOrder.ID,
+		SellOrderID:  sellOrder.ID,
+		BuyerID:      buyOrder.UserID,
+		SellerID:     sellOrder.UserID,
+		Price:        price,
+		Quantity:     tradeQty,
+		Amount:       tradeQty.Mul(price),
+		BuyerIsMaker: buyerIsMaker,
+		TradeTime:    report.Time,
 	}
 
+	// Price the fees of both sides at the rates stamped on their orders
+	buyerFee := ReceivedCurrencyFee(trade, models.OrderSideBuy, me.feeRate(buyOrder, buyerIsMaker))
+	sellerFee := ReceivedCurrencyFee(trade, models.OrderSideSell, me.feeRate(sellOrder, !buyerIsMaker))
+	trade.BuyerFee, trade.BuyerFeeCurrency = buyerFee.Amount, buyerFee.Currency
+	trade.SellerFee, trade.SellerFeeCurrency = sellerFee.Amount, sellerFee.Currency
+
+	// Update orders
+	fillOrder(buyOrder, trade, buyerFee, report)
+	fillOrder(sellOrder, trade, sellerFee, report)
+
 	return trade
 }
 
-// CancelOrder cancels an order
-func (me *MatchingEngine) CancelOrder(symbol, orderID string) error {
-	ob := me.GetOrCreateOrderBook(symbol)
+// fillOrder applies one side of a trade to an order
+func fillOrder(order *models.Order, trade *models.Trade, fee FeeCharge, report *ExecutionReport) {
+	order.FilledQty = order.FilledQty.Add(trade.Quantity)
+	order.FilledAmount = order.FilledAmount.Add(trade.Amount)
+	order.Fee = order.Fee.Add(fee.Amount)
+	order.FeeCurrency = fee.Currency
+	order.UpdateTime = report.Time
 
-	order, exists := ob.GetOrder(orderID)
-	if !exists {
-		return errors.New("order not found")
+	if order.FilledQty.GreaterThan(decimal.Zero) {
+		order.AvgPrice = order.FilledAmount.Div(order.FilledQty)
 	}
+}
 
-	if order.Status == models.OrderStatusFilled || order.Status == models.OrderStatusCancelled {
-		return errors.New("order cannot be cancelled")
+// publishTrades sends trades to the trade channel
+func (me *MatchingEngine) publishTrades(trades []*models.Trade) {
+	for _, trade := range trades {
+		me.tradeChan <- trade
 	}
-
-	ob.RemoveOrder(orderID)
-	order.Status = models.OrderStatusCancelled
-	order.UpdateTime = time.Now()
-
-	return nil
 }
 
 // validateOrder validates an order
@@ -362,18 +1036,64 @@ func (me *MatchingEngine) validateOrder(order *models.Order) error {
 		return errors.New("symbol is required")
 	}
 
-	if order.Quantity.LessThanOrEqual(decimal.Zero) {
+	if order.QuoteOrderQty != nil {
+		if err := validateQuoteOrder(order); err != nil {
+			return err
+		}
+	} else if order.Quantity.LessThanOrEqual(decimal.Zero) {
 		return errors.New("quantity must be positive")
 	}
 
-	if order.Type == models.OrderTypeLimit && order.Price.LessThanOrEqual(decimal.Zero) {
+	if order.PegType != "" {
+		if err := validatePeg(order); err != nil {
+			return err
+		}
+	} else if order.Type == models.OrderTypeLimit && order.Price.LessThanOrEqual(decimal.Zero) {
 		return errors.New("price must be positive for limit order")
 	}
 
+	if IsConditional(order) {
+		if err := validateConditional(order); err != nil {
+			return err
+		}
+	}
+
 	if order.Side != models.OrderSideBuy && order.Side != models.OrderSideSell {
 		return errors.New("invalid order side")
 	}
 
+	if order.TimeInForce == models.TimeInForceGTD {
+		if order.ExpireTime == nil {
+			return errors.New("expire time is required for GTD order")
+		}
+	} else if order.ExpireTime != nil {
+		return errors.New("expire time is only allowed for GTD order")
+	}
+
+	if f, exists := me.GetSymbolFilter(order.Symbol); exists {
+		if err := f.Validate(order); err != nil {
+			return err
+		}
+	}
+
+	switch order.STPMode {
+	case "", models.STPCancelNewest, models.STPCancelOldest, models.STPCancelBoth, models.STPDecrementCancel:
+	default:
+		return errors.New("invalid self-trade prevention mode")
+	}
+
+	if order.PostOnly != "" {
+		if order.PostOnly != models.PostOnlyReject && order.PostOnly != models.PostOnlyReprice {
+			return errors.New("invalid post-only mode")
+		}
+		if order.Type != models.OrderTypeLimit {
+			return errors.New("post-only is only allowed for limit order")
+		}
+		if order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK {
+			return errors.New("post-only order cannot be IOC or FOK")
+		}
+	}
+
 	return nil
 }
 
@@ -390,3 +1110,9 @@ func (me *MatchingEngine) GetOrderBook(symbol string) (*OrderBook, bool) {
 func (me *MatchingEngine) GetTradeChan() <-chan *models.Trade {
 	return me.tradeChan
 }
+
+// GetReportChan returns the channel of reports for engine-initiated
+// commands such as GTD expiries
+func (me *MatchingEngine) GetReportChan() <-chan *ExecutionReport {
+	return me.reportChan
+}
@@ -0,0 +1,1015 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestEngine creates an engine whose trade channel is drained in the background
+func newTestEngine(t *testing.T) *MatchingEngine {
+	t.Helper()
+
+	me := NewMatchingEngine()
+	go func() {
+		for range me.GetTradeChan() {
+		}
+	}()
+	t.Cleanup(me.Stop)
+
+	return me
+}
+
+func limitOrder(id string, userID uint, side models.OrderSide, price, qty string) *models.Order {
+	return &models.Order{
+		ID:          id,
+		UserID:      userID,
+		Symbol:      "BTC_USDT",
+		Side:        side,
+		Type:        models.OrderTypeLimit,
+		Price:       decimal.RequireFromString(price),
+		Quantity:    decimal.RequireFromString(qty),
+		TimeInForce: models.TimeInForceGTC,
+	}
+}
+
+// TestConcurrentProcessOrder hammers one symbol from many goroutines and
+// checks that every fill is accounted for exactly once on both sides
+func TestConcurrentProcessOrder(t *testing.T) {
+	me := newTestEngine(t)
+
+	const goroutines = 16
+	const ordersEach = 200
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		traded   = decimal.Zero
+		filled   = map[string]decimal.Decimal{}
+		finished []*models.Order
+	)
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < ordersEach; i++ {
+				side := models.OrderSideBuy
+				if (g+i)%2 == 0 {
+					side = models.OrderSideSell
+				}
+				// Distinct users so self-trade prevention never kicks in
+				order := limitOrder(fmt.Sprintf("%d-%d", g, i), uint(g*ordersEach+i+1), side, "100", "1")
+
+				report, err := me.Execute(order)
+				require.NoError(t, err)
+
+				mu.Lock()
+				for _, trade := range report.Trades {
+					traded = traded.Add(trade.Quantity)
+					filled[trade.BuyOrderID] = filled[trade.BuyOrderID].Add(trade.Quantity)
+					filled[trade.SellOrderID] = filled[trade.SellOrderID].Add(trade.Quantity)
+				}
+				finished = append(finished, order)
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	ob, ok := me.GetOrderBook("BTC_USDT")
+	require.True(t, ok)
+
+	// Buys and sells are balanced, so everything must have crossed
+	bids, asks := ob.GetDepth(10)
+	assert.Empty(t, bids)
+	assert.Empty(t, asks)
+	assert.True(t, traded.Equal(decimal.NewFromInt(goroutines*ordersEach/2)), "traded %s", traded)
+
+	for _, order := range finished {
+		resting, isResting := ob.GetOrder(order.ID)
+		if isResting {
+			order = resting
+		}
+		assert.True(t, filled[order.ID].LessThanOrEqual(order.Quantity), "order %s overfilled", order.ID)
+	}
+}
+
+// TestCancelOrder checks cancels go through the symbol worker
+func TestCancelOrder(t *testing.T) {
+	me := newTestEngine(t)
+
+	order := limitOrder("resting", 1, models.OrderSideBuy, "100", "1")
+	_, err := me.ProcessOrder(order)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderStatusPending, order.Status)
+
+	require.NoError(t, me.CancelOrder("BTC_USDT", "resting"))
+	assert.Error(t, me.CancelOrder("BTC_USDT", "resting"))
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	_, exists := ob.GetOrder("resting")
+	assert.False(t, exists)
+}
+
+// TestPostOnly checks post-only orders never take liquidity
+func TestPostOnly(t *testing.T) {
+	me := newTestEngine(t)
+
+	_, err := me.ProcessOrder(limitOrder("ask", 1, models.OrderSideSell, "100.5", "1"))
+	require.NoError(t, err)
+
+	reject := limitOrder("reject", 2, models.OrderSideBuy, "101", "1")
+	reject.PostOnly = models.PostOnlyReject
+	_, err = me.ProcessOrder(reject)
+	assert.ErrorIs(t, err, ErrPostOnlyWouldTake)
+
+	reprice := limitOrder("reprice", 2, models.OrderSideBuy, "101", "1")
+	reprice.PostOnly = models.PostOnlyReprice
+	trades, err := me.ProcessOrder(reprice)
+	require.NoError(t, err)
+	assert.Empty(t, trades)
+	assert.True(t, reprice.Price.Equal(decimal.RequireFromString("100.4")), "repriced to %s", reprice.Price)
+	assert.Equal(t, models.OrderStatusPending, reprice.Status)
+}
+
+// TestGTDOrderExpires checks the scheduler cancels GTD orders and reports it
+func TestGTDOrderExpires(t *testing.T) {
+	me := newTestEngine(t)
+
+	expireTime := time.Now().Add(50 * time.Millisecond)
+	order := limitOrder("gtd", 1, models.OrderSideBuy, "100", "1")
+	order.TimeInForce = models.TimeInForceGTD
+	order.ExpireTime = &expireTime
+	_, err := me.ProcessOrder(order)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderStatusPending, order.Status)
+
+	select {
+	case report := <-me.GetReportChan():
+		require.NotNil(t, report.Order)
+		assert.Equal(t, "gtd", report.Order.ID)
+		assert.Equal(t, models.OrderStatusCancelled, report.Order.Status)
+		assert.Equal(t, models.CancelReasonExpired, report.Order.CancelReason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("GTD order did not expire")
+	}
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	_, exists := ob.GetOrder("gtd")
+	assert.False(t, exists)
+}
+
+// TestFOKLiquidityCheck checks FOK orders either fill in full or leave the
+// book untouched
+func TestFOKLiquidityCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		side     models.OrderSide
+		price    string
+		qty      string
+		wantFill bool
+	}{
+		{"fills at best level", models.OrderSideBuy, "100", "1", true},
+		{"fills across levels", models.OrderSideBuy, "101", "2.5", true},
+		{"exactly all liquidity within limit", models.OrderSideBuy, "102", "3.5", true},
+		{"short by a fraction", models.OrderSideBuy, "101", "2.6", false},
+		{"liquidity beyond limit", models.OrderSideBuy, "100", "1.5", false},
+		{"no crossing liquidity", models.OrderSideBuy, "99", "0.1", false},
+		{"more than whole book", models.OrderSideBuy, "1000", "10", false},
+		{"sell against empty side", models.OrderSideSell, "90", "1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			me := newTestEngine(t)
+
+			// Asks: 100 x (0.5 + 0.5), 101 x 1.5, 102 x 1
+			makers := []*models.Order{
+				limitOrder("ask-1", 1, models.OrderSideSell, "100", "0.5"),
+				limitOrder("ask-2", 2, models.OrderSideSell, "100", "0.5"),
+				limitOrder("ask-3", 3, models.OrderSideSell, "101", "1.5"),
+				limitOrder("ask-4", 4, models.OrderSideSell, "102", "1"),
+			}
+			for _, maker := range makers {
+				_, err := me.ProcessOrder(maker)
+				require.NoError(t, err)
+			}
+			ob, _ := me.GetOrderBook("BTC_USDT")
+			bidsBefore, asksBefore := ob.GetDepth(10)
+
+			taker := limitOrder("fok", 9, tt.side, tt.price, tt.qty)
+			taker.TimeInForce = models.TimeInForceFOK
+			trades, err := me.ProcessOrder(taker)
+			require.NoError(t, err)
+
+			if tt.wantFill {
+				assert.Equal(t, models.OrderStatusFilled, taker.Status)
+				filled := decimal.Zero
+				for _, trade := range trades {
+					filled = filled.Add(trade.Quantity)
+				}
+				assert.True(t, filled.Equal(taker.Quantity), "filled %s of %s", filled, taker.Quantity)
+				return
+			}
+
+			assert.Equal(t, models.OrderStatusCancelled, taker.Status)
+			assert.Empty(t, trades)
+			assert.True(t, taker.FilledQty.IsZero())
+
+			bidsAfter, asksAfter := ob.GetDepth(10)
+			assertSameDepth(t, bidsBefore, bidsAfter)
+			assertSameDepth(t, asksBefore, asksAfter)
+			for _, maker := range makers {
+				resting, exists := ob.GetOrder(maker.ID)
+				require.True(t, exists, "maker %s removed", maker.ID)
+				assert.True(t, resting.FilledQty.IsZero(), "maker %s mutated", maker.ID)
+			}
+		})
+	}
+}
+
+// TestMarketOrderSlippage checks market orders stop at the slippage bound
+func TestMarketOrderSlippage(t *testing.T) {
+	me := newTestEngine(t)
+	me.SetMarketSlippage(decimal.RequireFromString("0.05"))
+
+	for i, price := range []string{"100", "104", "106"} {
+		_, err := me.ProcessOrder(limitOrder(fmt.Sprintf("ask-%d", i), 1, models.OrderSideSell, price, "1"))
+		require.NoError(t, err)
+	}
+
+	market := &models.Order{
+		UserID:      2,
+		Symbol:      "BTC_USDT",
+		Side:        models.OrderSideBuy,
+		Type:        models.OrderTypeMarket,
+		Quantity:    decimal.RequireFromString("3"),
+		TimeInForce: models.TimeInForceIOC,
+	}
+	trades, err := me.ProcessOrder(market)
+	require.NoError(t, err)
+
+	require.Len(t, trades, 2)
+	assert.True(t, market.FilledQty.Equal(decimal.RequireFromString("2")))
+	assert.Equal(t, models.OrderStatusCancelled, market.Status)
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	bestAsk, _ := ob.GetBestAsk()
+	assert.True(t, bestAsk.Equal(decimal.RequireFromString("106")))
+
+	// A FOK market order uses the same bound for its liquidity check
+	fok := *market
+	fok.ID = ""
+	fok.FilledQty = decimal.Zero
+	fok.FilledAmount = decimal.Zero
+	fok.Quantity = decimal.RequireFromString("1")
+	fok.TimeInForce = models.TimeInForceFOK
+	_, err = me.ProcessOrder(limitOrder("ask-far", 1, models.OrderSideSell, "107", "1"))
+	require.NoError(t, err)
+	trades, err = me.ProcessOrder(&fok)
+	require.NoError(t, err)
+	assert.Len(t, trades, 1)
+	assert.Equal(t, models.OrderStatusFilled, fok.Status)
+}
+
+// TestSelfTradePrevention checks each STP mode against a resting order of
+// the same user
+func TestSelfTradePrevention(t *testing.T) {
+	tests := []struct {
+		mode        models.STPMode
+		takerQty    string
+		wantTaker   models.OrderStatus
+		takerQtyEnd string
+		makerExists bool
+		makerQtyEnd string
+	}{
+		{models.STPCancelNewest, "1", models.OrderStatusCancelled, "1", true, "2"},
+		{models.STPCancelOldest, "1", models.OrderStatusFilled, "1", false, "2"},
+		{models.STPCancelBoth, "1", models.OrderStatusCancelled, "1", false, "2"},
+		{models.STPDecrementCancel, "1", models.OrderStatusCancelled, "0", true, "1"},
+		{models.STPDecrementCancel, "3", models.OrderStatusFilled, "1", false, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+"/"+tt.takerQty, func(t *testing.T) {
+			me := newTestEngine(t)
+
+			// Own order in front of another user's order at the same price
+			_, err := me.ProcessOrder(limitOrder("own", 1, models.OrderSideSell, "100", "2"))
+			require.NoError(t, err)
+			_, err = me.ProcessOrder(limitOrder("other", 2, models.OrderSideSell, "100", "1"))
+			require.NoError(t, err)
+
+			taker := limitOrder("taker", 1, models.OrderSideBuy, "100", tt.takerQty)
+			taker.STPMode = tt.mode
+			report, err := me.Execute(taker)
+			require.NoError(t, err)
+
+			for _, trade := range report.Trades {
+				assert.NotEqual(t, trade.BuyerID, trade.SellerID)
+			}
+			assert.Equal(t, tt.wantTaker, taker.Status)
+			assert.True(t, taker.Quantity.Equal(decimal.RequireFromString(tt.takerQtyEnd)), "taker quantity %s", taker.Quantity)
+			if taker.Status == models.OrderStatusCancelled {
+				assert.Equal(t, models.CancelReasonSelfTrade, taker.CancelReason)
+			}
+
+			ob, _ := me.GetOrderBook("BTC_USDT")
+			own, exists := ob.GetOrder("own")
+			assert.Equal(t, tt.makerExists, exists)
+			if exists {
+				assert.True(t, own.Quantity.Equal(decimal.RequireFromString(tt.makerQtyEnd)), "maker quantity %s", own.Quantity)
+			}
+		})
+	}
+}
+
+// makerTakerFees charges makers and takers different rates
+type makerTakerFees struct {
+	maker, taker decimal.Decimal
+}
+
+func (f *makerTakerFees) GetFeeRates(userID uint, symbol string) (decimal.Decimal, decimal.Decimal) {
+	return f.maker, f.taker
+}
+
+// TestTradeFees checks fees follow the maker/taker role and the currency
+// each side receives
+func TestTradeFees(t *testing.T) {
+	me := newTestEngine(t)
+	me.SetFeeCalculator(&makerTakerFees{
+		maker: decimal.RequireFromString("0.001"),
+		taker: decimal.RequireFromString("0.002"),
+	})
+
+	_, err := me.ProcessOrder(limitOrder("maker", 1, models.OrderSideBuy, "100", "2"))
+	require.NoError(t, err)
+	taker := limitOrder("taker", 2, models.OrderSideSell, "100", "2")
+	trades, err := me.ProcessOrder(taker)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+
+	trade := trades[0]
+	assert.True(t, trade.BuyerIsMaker)
+	assert.Equal(t, "BTC", trade.BuyerFeeCurrency)
+	assert.True(t, trade.BuyerFee.Equal(decimal.RequireFromString("0.002")), "buyer fee %s", trade.BuyerFee)
+	assert.Equal(t, "USDT", trade.SellerFeeCurrency)
+	assert.True(t, trade.SellerFee.Equal(decimal.RequireFromString("0.4")), "seller fee %s", trade.SellerFee)
+	assert.Equal(t, "USDT", taker.FeeCurrency)
+	assert.True(t, taker.Fee.Equal(trade.SellerFee))
+}
+
+// queueOrder returns the order IDs resting at a price level, front first
+func queueOrder(t *testing.T, ob *OrderBook, side models.OrderSide, price string) []string {
+	t.Helper()
+
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	levels, _ := ob.sideOf(side)
+	level, exists := levels[decimal.RequireFromString(price).String()]
+	require.True(t, exists, "no level at %s", price)
+
+	var ids []string
+	for e := level.Orders.Front(); e != nil; e = e.Next() {
+		ids = append(ids, e.Value.(*models.Order).ID)
+	}
+	return ids
+}
+
+// TestAmendOrder checks amendments keep or lose queue priority
+func TestAmendOrder(t *testing.T) {
+	me := newTestEngine(t)
+
+	for i, id := range []string{"a", "b", "c"} {
+		_, err := me.ProcessOrder(limitOrder(id, uint(i+1), models.OrderSideBuy, "100", "2"))
+		require.NoError(t, err)
+	}
+	_, err := me.ProcessOrder(limitOrder("ask", 9, models.OrderSideSell, "101", "1"))
+	require.NoError(t, err)
+	ob, _ := me.GetOrderBook("BTC_USDT")
+
+	// Quantity down keeps the position
+	_, err = me.AmendOrder("BTC_USDT", "b", 2, decimal.Zero, decimal.RequireFromString("1"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, queueOrder(t, ob, models.OrderSideBuy, "100"))
+
+	// Quantity up goes to the back
+	_, err = me.AmendOrder("BTC_USDT", "a", 1, decimal.Zero, decimal.RequireFromString("3"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "a"}, queueOrder(t, ob, models.OrderSideBuy, "100"))
+
+	bids, _ := ob.GetDepth(1)
+	require.Len(t, bids, 1)
+	assert.True(t, bids[0].Volume.Equal(decimal.RequireFromString("6")), "volume %s", bids[0].Volume)
+
+	// Only the owner may amend
+	_, err = me.AmendOrder("BTC_USDT", "c", 1, decimal.Zero, decimal.RequireFromString("1"))
+	assert.Error(t, err)
+
+	// A crossing price change matches like a new order and rests the rest
+	report, err := me.AmendOrder("BTC_USDT", "c", 3, decimal.RequireFromString("101"), decimal.Zero)
+	require.NoError(t, err)
+	require.Len(t, report.Trades, 1)
+	assert.Equal(t, models.OrderStatusPartial, report.Order.Status)
+	assert.Equal(t, []string{"c"}, queueOrder(t, ob, models.OrderSideBuy, "101"))
+	assert.Equal(t, []string{"b", "a"}, queueOrder(t, ob, models.OrderSideBuy, "100"))
+
+	// Quantity cannot drop to what is already filled
+	_, err = me.AmendOrder("BTC_USDT", "c", 3, decimal.Zero, decimal.RequireFromString("1"))
+	assert.Error(t, err)
+}
+
+// TestMassCancel checks a mass cancel removes only the user's orders
+func TestMassCancel(t *testing.T) {
+	me := newTestEngine(t)
+
+	orders := []*models.Order{
+		limitOrder("bid1", 1, models.OrderSideBuy, "99", "1"),
+		limitOrder("bid2", 1, models.OrderSideBuy, "98", "1"),
+		limitOrder("ask1", 1, models.OrderSideSell, "101", "1"),
+		limitOrder("other", 2, models.OrderSideBuy, "99", "1"),
+	}
+	for _, order := range orders {
+		_, err := me.ProcessOrder(order)
+		require.NoError(t, err)
+	}
+	ob, _ := me.GetOrderBook("BTC_USDT")
+
+	report, err := me.MassCancel("BTC_USDT", 1, models.OrderSideBuy, "")
+	require.NoError(t, err)
+	require.Len(t, report.Cancelled, 2)
+	for _, order := range report.Cancelled {
+		assert.Equal(t, models.OrderStatusCancelled, order.Status)
+		assert.Equal(t, models.CancelReasonUser, order.CancelReason)
+	}
+	assert.Equal(t, []string{"other"}, queueOrder(t, ob, models.OrderSideBuy, "99"))
+
+	report, err = me.MassCancel("BTC_USDT", 1, "", models.CancelReasonDisconnect)
+	require.NoError(t, err)
+	require.Len(t, report.Cancelled, 1)
+	assert.Equal(t, "ask1", report.Cancelled[0].ID)
+	assert.Equal(t, models.CancelReasonDisconnect, report.Cancelled[0].CancelReason)
+
+	_, exists := ob.GetOrder("other")
+	assert.True(t, exists)
+}
+
+// TestCallAuction checks orders collect without matching and uncross at the
+// volume-maximizing price
+func TestCallAuction(t *testing.T) {
+	me := newTestEngine(t)
+	require.NoError(t, me.StartAuction("BTC_USDT", time.Now().Add(200*time.Millisecond)))
+
+	_, err := me.ProcessOrder(&models.Order{
+		ID: "market", UserID: 9, Symbol: "BTC_USDT", Side: models.OrderSideBuy,
+		Type: models.OrderTypeMarket, Quantity: decimal.RequireFromString("1"),
+	})
+	assert.ErrorIs(t, err, ErrAuctionOrderType)
+
+	for _, order := range []*models.Order{
+		limitOrder("b1", 1, models.OrderSideBuy, "101", "2"),
+		limitOrder("b2", 2, models.OrderSideBuy, "100", "1"),
+		limitOrder("a1", 3, models.OrderSideSell, "99", "1"),
+		limitOrder("a2", 4, models.OrderSideSell, "100", "2"),
+	} {
+		trades, err := me.ProcessOrder(order)
+		require.NoError(t, err)
+		assert.Empty(t, trades)
+	}
+
+	info, ok := me.GetIndicativePrice("BTC_USDT")
+	require.True(t, ok)
+	assert.True(t, info.Price.Equal(decimal.RequireFromString("100")), "price %s", info.Price)
+	assert.True(t, info.Volume.Equal(decimal.RequireFromString("3")), "volume %s", info.Volume)
+
+	var report *ExecutionReport
+	select {
+	case report = <-me.GetReportChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("auction did not uncross")
+	}
+	require.NotNil(t, report.Auction)
+	assert.Equal(t, PhaseContinuous, report.Auction.Phase)
+
+	volume := decimal.Zero
+	for _, trade := range report.Trades {
+		assert.True(t, trade.Price.Equal(decimal.RequireFromString("100")), "trade price %s", trade.Price)
+		volume = volume.Add(trade.Quantity)
+	}
+	assert.True(t, volume.Equal(decimal.RequireFromString("3")), "volume %s", volume)
+	assert.Len(t, report.Makers, 4)
+
+	_, inAuction := me.AuctionEnd("BTC_USDT")
+	assert.False(t, inAuction)
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	assert.Empty(t, ob.OrderMap)
+
+	// Trading is continuous again
+	_, err = me.ProcessOrder(limitOrder("b3", 1, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+	trades, err := me.ProcessOrder(limitOrder("a3", 2, models.OrderSideSell, "100", "1"))
+	require.NoError(t, err)
+	assert.Len(t, trades, 1)
+}
+
+// TestTradingStatus checks which commands each status accepts
+func TestTradingStatus(t *testing.T) {
+	me := newTestEngine(t)
+
+	_, err := me.ProcessOrder(limitOrder("b1", 1, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+
+	require.NoError(t, me.SetTradingStatus("BTC_USDT", StatusCancelOnly))
+	_, err = me.ProcessOrder(limitOrder("b2", 1, models.OrderSideBuy, "100", "1"))
+	assert.ErrorIs(t, err, ErrCancelOnly)
+	require.NoError(t, me.CancelOrder("BTC_USDT", "b1"))
+
+	require.NoError(t, me.SetTradingStatus("BTC_USDT", StatusPostOnly))
+	_, err = me.ProcessOrder(&models.Order{
+		ID: "market", UserID: 2, Symbol: "BTC_USDT", Side: models.OrderSideSell,
+		Type: models.OrderTypeMarket, Quantity: decimal.RequireFromString("1"),
+	})
+	assert.ErrorIs(t, err, ErrPostOnlyStatus)
+	_, err = me.ProcessOrder(limitOrder("b3", 1, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+	_, err = me.ProcessOrder(limitOrder("a1", 2, models.OrderSideSell, "99", "1"))
+	assert.ErrorIs(t, err, ErrPostOnlyWouldTake)
+
+	_, err = me.ProcessOrder(limitOrder("b4", 1, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+
+	// A halt stops trading but users can still pull their orders
+	require.NoError(t, me.SetTradingStatus("BTC_USDT", StatusHalted))
+	assert.Equal(t, StatusHalted, me.GetTradingStatus("BTC_USDT"))
+	_, err = me.ProcessOrder(limitOrder("b5", 1, models.OrderSideBuy, "100", "1"))
+	assert.ErrorIs(t, err, ErrSymbolHalted)
+	require.NoError(t, me.CancelOrder("BTC_USDT", "b3"))
+	report, err := me.MassCancel("BTC_USDT", 1, "", models.CancelReasonDisconnect)
+	require.NoError(t, err)
+	require.NoError(t, report.Err)
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	_, exists := ob.GetOrder("b4")
+	assert.False(t, exists)
+
+	require.NoError(t, me.SetTradingStatus("BTC_USDT", StatusTrading))
+	_, err = me.ProcessOrder(limitOrder("b6", 1, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+	trades, err := me.ProcessOrder(limitOrder("a2", 2, models.OrderSideSell, "99", "1"))
+	require.NoError(t, err)
+	assert.Len(t, trades, 1)
+
+	assert.Error(t, me.SetTradingStatus("BTC_USDT", StatusAuction))
+}
+
+// TestCircuitBreaker checks a fast price move halts the symbol and that it
+// reopens through an auction
+func TestCircuitBreaker(t *testing.T) {
+	me := newTestEngine(t)
+	me.SetCircuitBreaker(&CircuitBreaker{
+		Threshold:       decimal.RequireFromString("0.1"),
+		Window:          time.Minute,
+		HaltDuration:    100 * time.Millisecond,
+		AuctionDuration: 100 * time.Millisecond,
+	})
+
+	for _, order := range []*models.Order{
+		limitOrder("a1", 2, models.OrderSideSell, "100", "1"),
+		limitOrder("a2", 2, models.OrderSideSell, "105", "1"),
+		limitOrder("a3", 2, models.OrderSideSell, "120", "1"),
+		limitOrder("b1", 1, models.OrderSideBuy, "100", "1"),
+	} {
+		_, err := me.ProcessOrder(order)
+		require.NoError(t, err)
+	}
+
+	// 105 is within 10% of 100, 120 is not
+	report, err := me.Execute(limitOrder("b2", 1, models.OrderSideBuy, "130", "2"))
+	require.NoError(t, err)
+	assert.True(t, report.Halted)
+	require.Len(t, report.Trades, 1)
+	assert.True(t, report.Trades[0].Price.Equal(decimal.RequireFromString("105")))
+	assert.Equal(t, models.OrderStatusPartial, report.Order.Status)
+	assert.Equal(t, StatusHalted, me.GetTradingStatus("BTC_USDT"))
+
+	_, err = me.ProcessOrder(limitOrder("b3", 1, models.OrderSideBuy, "100", "1"))
+	assert.ErrorIs(t, err, ErrSymbolHalted)
+
+	// The crossed book uncrosses in the reopening auction
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case report = <-me.GetReportChan():
+		case <-deadline:
+			t.Fatal("symbol did not reopen")
+		}
+		if report.Auction != nil {
+			break
+		}
+	}
+	require.Len(t, report.Trades, 1)
+	assert.True(t, report.Trades[0].Price.Equal(decimal.RequireFromString("120")), "price %s", report.Trades[0].Price)
+	assert.Equal(t, StatusTrading, me.GetTradingStatus("BTC_USDT"))
+}
+
+// TestTriggerOrders checks conditional orders fire on the trade and mark
+// prices in sequence with the trades that reach them
+func TestTriggerOrders(t *testing.T) {
+	me := newTestEngine(t)
+	price := func(s string) *decimal.Decimal {
+		d := decimal.RequireFromString(s)
+		return &d
+	}
+
+	for _, order := range []*models.Order{
+		limitOrder("a1", 2, models.OrderSideSell, "100", "1"),
+		limitOrder("a2", 2, models.OrderSideSell, "101", "1"),
+		limitOrder("b1", 3, models.OrderSideBuy, "99", "1"),
+		{ID: "sell-stop", UserID: 4, Symbol: "BTC_USDT", Side: models.OrderSideSell, Type: models.OrderTypeStopLoss,
+			Quantity: decimal.RequireFromString("1"), StopPrice: price("99.5"), TimeInForce: models.TimeInForceGTC},
+		{ID: "buy-stop", UserID: 5, Symbol: "BTC_USDT", Side: models.OrderSideBuy, Type: models.OrderTypeStopLoss,
+			Quantity: decimal.RequireFromString("1"), StopPrice: price("100"), TimeInForce: models.TimeInForceGTC},
+	} {
+		report, err := me.Execute(order)
+		require.NoError(t, err)
+		assert.Empty(t, report.Trades)
+	}
+
+	// The trade at 100 fires the buy stop, which lifts the next ask
+	report, err := me.Execute(limitOrder("b2", 1, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+	require.Len(t, report.Trades, 2)
+	assert.True(t, report.Trades[1].Price.Equal(decimal.RequireFromString("101")))
+	require.Len(t, report.Triggered, 1)
+	assert.Equal(t, "buy-stop", report.Triggered[0].ID)
+	assert.Equal(t, models.OrderTypeMarket, report.Triggered[0].Type)
+	assert.True(t, report.Triggered[0].IsTriggered)
+	assert.Equal(t, models.OrderStatusFilled, report.Triggered[0].Status)
+
+	// Trailing stops start trailing from the last price
+	report, err = me.Execute(&models.Order{ID: "trail", UserID: 6, Symbol: "BTC_USDT", Side: models.OrderSideSell,
+		Type: models.OrderTypeTrailingStop, Quantity: decimal.RequireFromString("1"), TrailingDelta: price("2"),
+		TimeInForce: models.TimeInForceGTC})
+	require.NoError(t, err)
+	require.NotNil(t, report.Order.StopPrice)
+	assert.True(t, report.Order.StopPrice.Equal(decimal.RequireFromString("99")), "stop %s", report.Order.StopPrice)
+	require.NoError(t, me.CancelOrder("BTC_USDT", "trail"))
+
+	report, err = me.Execute(&models.Order{ID: "trail-percent", UserID: 6, Symbol: "BTC_USDT", Side: models.OrderSideBuy,
+		Type: models.OrderTypeTrailingStop, Quantity: decimal.RequireFromString("1"), TrailingPercent: price("10"),
+		TimeInForce: models.TimeInForceGTC})
+	require.NoError(t, err)
+	assert.True(t, report.Order.StopPrice.Equal(decimal.RequireFromString("111.1")), "stop %s", report.Order.StopPrice)
+	require.NoError(t, me.CancelOrder("BTC_USDT", "trail-percent"))
+
+	// A mark price fires the take-profit, whose trade at 99 fires the sell stop
+	_, err = me.Execute(&models.Order{ID: "take-profit", UserID: 7, Symbol: "BTC_USDT", Side: models.OrderSideSell,
+		Type: models.OrderTypeTakeProfit, Quantity: decimal.RequireFromString("1"), TakeProfitPrice: price("110"),
+		TriggerPriceType: models.TriggerPriceMark, TimeInForce: models.TimeInForceGTC})
+	require.NoError(t, err)
+	require.NoError(t, me.UpdateTriggerPrice("BTC_USDT", models.TriggerPriceMark, decimal.RequireFromString("111")))
+
+	select {
+	case report = <-me.GetReportChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("mark price was not applied")
+	}
+	require.Len(t, report.Trades, 1)
+	assert.True(t, report.Trades[0].Price.Equal(decimal.RequireFromString("99")))
+	require.Len(t, report.Triggered, 2)
+	assert.Equal(t, "take-profit", report.Triggered[0].ID)
+	assert.Equal(t, models.OrderStatusFilled, report.Triggered[0].Status)
+	assert.Equal(t, "sell-stop", report.Triggered[1].ID)
+	assert.Equal(t, models.OrderStatusCancelled, report.Triggered[1].Status) // no bids left
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	assert.Empty(t, ob.OrderMap)
+	assert.Empty(t, me.OpenOrders())
+}
+
+// TestPeggedOrders checks pegged orders follow the top of book without
+// crossing it and keep their relative priority when they move together
+func TestPeggedOrders(t *testing.T) {
+	me := newTestEngine(t)
+	price := func(s string) *decimal.Decimal {
+		d := decimal.RequireFromString(s)
+		return &d
+	}
+	peg := func(id string, userID uint, side models.OrderSide, pegType models.PegType) *models.Order {
+		order := limitOrder(id, userID, side, "0", "1")
+		order.PegType = pegType
+		return order
+	}
+
+	// Without an ask there is nothing to peg a sell to
+	_, err := me.Execute(peg("early", 1, models.OrderSideSell, models.PegPrimary))
+	assert.ErrorIs(t, err, ErrNoPegReference)
+
+	_, err = me.Execute(limitOrder("a1", 2, models.OrderSideSell, "101.0", "1"))
+	require.NoError(t, err)
+	_, err = me.Execute(limitOrder("b1", 2, models.OrderSideBuy, "99.0", "1"))
+	require.NoError(t, err)
+
+	mid := peg("mid", 3, models.OrderSideBuy, models.PegMid)
+	mid.PegLimit = price("200")
+	market := peg("market", 6, models.OrderSideBuy, models.PegMarket)
+	for _, order := range []*models.Order{
+		mid,
+		peg("p1", 4, models.OrderSideBuy, models.PegPrimary),
+		peg("p2", 5, models.OrderSideBuy, models.PegPrimary),
+		market,
+	} {
+		report, err := me.Execute(order)
+		require.NoError(t, err)
+		assert.Empty(t, report.Trades)
+	}
+	assert.True(t, mid.Price.Equal(decimal.RequireFromString("100")), "mid %s", mid.Price)
+	// A market peg would take the ask, so it rests one tick behind it
+	assert.True(t, market.Price.Equal(decimal.RequireFromString("100.9")), "market %s", market.Price)
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	assert.Equal(t, []string{"b1", "p1", "p2"}, queueOrder(t, ob, models.OrderSideBuy, "99"))
+
+	// A better bid moves the mid and primary pegs; the market peg stays
+	report, err := me.Execute(limitOrder("b2", 7, models.OrderSideBuy, "99.5", "1"))
+	require.NoError(t, err)
+	require.Len(t, report.Makers, 3)
+	assert.Equal(t, "mid", report.Makers[0].ID)
+	assert.True(t, report.Makers[0].Price.Equal(decimal.RequireFromString("100.2")), "mid %s", report.Makers[0].Price)
+	assert.Equal(t, []string{"b2", "p1", "p2"}, queueOrder(t, ob, models.OrderSideBuy, "99.5"))
+
+	_, err = me.AmendOrder("BTC_USDT", "p1", 4, decimal.RequireFromString("98"), decimal.Zero)
+	assert.ErrorIs(t, err, ErrPeggedPrice)
+
+	// Cancelling the better bid sends the primary pegs back, in the same order
+	report, err = me.Cancel("BTC_USDT", "b2")
+	require.NoError(t, err)
+	require.Len(t, report.Makers, 3)
+	assert.Equal(t, []string{"b1", "p1", "p2"}, queueOrder(t, ob, models.OrderSideBuy, "99"))
+
+	// Pegs trade as makers and leave the book when filled
+	report, err = me.Execute(limitOrder("s1", 8, models.OrderSideSell, "100.9", "1"))
+	require.NoError(t, err)
+	require.Len(t, report.Trades, 1)
+	assert.Equal(t, "market", report.Trades[0].BuyOrderID)
+	assert.NotContains(t, ob.pegged, "market")
+}
+
+// TestQuoteOrderQty checks market buys never spend more than their quote budget
+func TestQuoteOrderQty(t *testing.T) {
+	me := newTestEngine(t)
+	me.SetSymbolFilters([]*SymbolFilter{NewSymbolFilter(&models.TradingPair{
+		Symbol:            "BTC_USDT",
+		PricePrecision:    2,
+		QuantityPrecision: 3,
+		IsActive:          true,
+	})})
+	budget := func(s string) *decimal.Decimal {
+		d := decimal.RequireFromString(s)
+		return &d
+	}
+	quoteBuy := func(id, quoteQty, qty string) *models.Order {
+		return &models.Order{ID: id, UserID: 1, Symbol: "BTC_USDT", Side: models.OrderSideBuy, Type: models.OrderTypeMarket,
+			Quantity: decimal.RequireFromString(qty), QuoteOrderQty: budget(quoteQty), TimeInForce: models.TimeInForceIOC}
+	}
+
+	for _, order := range []*models.Order{
+		limitOrder("a1", 2, models.OrderSideSell, "100", "1"),
+		limitOrder("a2", 2, models.OrderSideSell, "101", "1"),
+		limitOrder("a3", 2, models.OrderSideSell, "102", "5"),
+	} {
+		_, err := me.Execute(order)
+		require.NoError(t, err)
+	}
+
+	// 150 buys all of the first level and what is left affords of the second
+	report, err := me.Execute(quoteBuy("q1", "150", "0"))
+	require.NoError(t, err)
+	require.Len(t, report.Trades, 2)
+	assert.True(t, report.Trades[1].Quantity.Equal(decimal.RequireFromString("0.495")), "qty %s", report.Trades[1].Quantity)
+	assert.Equal(t, models.OrderStatusFilled, report.Order.Status)
+	assert.True(t, report.Order.FilledAmount.LessThanOrEqual(decimal.RequireFromString("150")))
+	assert.True(t, report.Order.FilledQty.Equal(decimal.RequireFromString("1.495")), "filled %s", report.Order.FilledQty)
+
+	// A base quantity caps the buy before its budget does
+	report, err = me.Execute(quoteBuy("q2", "1000", "1"))
+	require.NoError(t, err)
+	assert.True(t, report.Order.FilledQty.Equal(decimal.RequireFromString("1")))
+	assert.Equal(t, models.OrderStatusFilled, report.Order.Status)
+
+	// A budget larger than the book leaves the order cancelled with the rest unspent
+	report, err = me.Execute(quoteBuy("q3", "10000", "0"))
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderStatusCancelled, report.Order.Status)
+	assert.True(t, report.Order.FilledQty.Equal(decimal.RequireFromString("4.505")), "filled %s", report.Order.FilledQty)
+
+	_, err = me.Execute(&models.Order{ID: "q4", UserID: 1, Symbol: "BTC_USDT", Side: models.OrderSideSell,
+		Type: models.OrderTypeMarket, QuoteOrderQty: budget("100"), TimeInForce: models.TimeInForceIOC})
+	assert.Error(t, err)
+}
+
+// TestDepthUpdates checks a client can rebuild the book from a snapshot and
+// the update stream, and verify it with the checksum
+func TestDepthUpdates(t *testing.T) {
+	me := newTestEngine(t)
+	next := func() *DepthUpdate {
+		select {
+		case update := <-me.GetDepthChan():
+			return update
+		case <-time.After(2 * time.Second):
+			t.Fatal("no depth update")
+			return nil
+		}
+	}
+
+	_, err := me.Execute(limitOrder("a1", 1, models.OrderSideSell, "101", "1"))
+	require.NoError(t, err)
+	update := next()
+	assert.Equal(t, uint64(1), update.FirstUpdateID)
+	assert.Equal(t, uint64(1), update.FinalUpdateID)
+	require.Len(t, update.Asks, 1)
+	assert.True(t, update.Asks[0].Volume.Equal(decimal.NewFromInt(1)))
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	snapshot := ob.GetDepthSnapshot(100)
+	assert.Equal(t, uint64(1), snapshot.LastUpdateID)
+
+	// A client rebuilds the book from the snapshot and the later updates
+	book := map[string]map[string]decimal.Decimal{"bid": {}, "ask": {}}
+	for _, level := range snapshot.Asks {
+		book["ask"][level.Price.String()] = level.Volume
+	}
+	lastID := snapshot.LastUpdateID
+	apply := func(update *DepthUpdate) {
+		require.Equal(t, lastID+1, update.FirstUpdateID, "gap in depth updates")
+		for side, levels := range map[string][]PriceLevelInfo{"bid": update.Bids, "ask": update.Asks} {
+			for _, level := range levels {
+				if level.Volume.IsZero() {
+					delete(book[side], level.Price.String())
+				} else {
+					book[side][level.Price.String()] = level.Volume
+				}
+			}
+		}
+		lastID = update.FinalUpdateID
+	}
+
+	for _, order := range []*models.Order{
+		limitOrder("a2", 1, models.OrderSideSell, "102", "2"),
+		limitOrder("b1", 2, models.OrderSideBuy, "99", "1"),
+		limitOrder("b2", 2, models.OrderSideBuy, "101.5", "2"), // takes a1, rests the rest
+	} {
+		_, err := me.Execute(order)
+		require.NoError(t, err)
+		update = next()
+		apply(update)
+	}
+	require.NoError(t, me.CancelOrder("BTC_USDT", "b1"))
+	update = next()
+	apply(update)
+
+	// The rebuilt book matches the engine and its checksum
+	bids, asks := ob.GetDepth(100)
+	assert.Len(t, book["bid"], len(bids))
+	assert.Len(t, book["ask"], len(asks))
+	for _, level := range bids {
+		assert.True(t, book["bid"][level.Price.String()].Equal(level.Volume))
+	}
+	update.SetChecksum()
+	assert.Equal(t, DepthChecksum(bids, asks), update.Checksum)
+	require.NotNil(t, update.BestBid)
+	assert.True(t, update.BestBid.Price.Equal(bids[0].Price))
+	assert.Equal(t, ob.GetDepthSnapshot(100).LastUpdateID, lastID)
+}
+
+// TestL3Updates checks a client can rebuild every queue from an L3 snapshot
+// and the order events that follow it
+func TestL3Updates(t *testing.T) {
+	me := newTestEngine(t)
+	next := func() *L3Update {
+		select {
+		case update := <-me.GetL3Chan():
+			return update
+		case <-time.After(2 * time.Second):
+			t.Fatal("no L3 update")
+			return nil
+		}
+	}
+
+	for _, order := range []*models.Order{
+		limitOrder("a1", 1, models.OrderSideSell, "101", "1"),
+		limitOrder("a2", 2, models.OrderSideSell, "101", "2"),
+	} {
+		report, err := me.Execute(order)
+		require.NoError(t, err)
+		update := next()
+		assert.Equal(t, report.Seq, update.Seq)
+		require.Len(t, update.Events, 1)
+		assert.Equal(t, L3Add, update.Events[0].Type)
+		assert.NotEqual(t, order.ID, update.Events[0].OrderID)
+	}
+
+	ob, _ := me.GetOrderBook("BTC_USDT")
+	snapshot := ob.GetL3Snapshot()
+	require.Len(t, snapshot.Asks, 2)
+	assert.Equal(t, publicOrderID(ob.l3Key, "a1"), snapshot.Asks[0].OrderID)
+
+	// A client replays the events onto the snapshot's queues
+	queue := snapshot.Asks
+	lastSeq := snapshot.Seq
+	apply := func(update *L3Update) {
+		require.Equal(t, lastSeq, update.PrevSeq, "gap in L3 updates")
+		for _, event := range update.Events {
+			require.Equal(t, models.OrderSideSell, event.Side)
+			for i := range queue {
+				if queue[i].OrderID != event.OrderID {
+					continue
+				}
+				switch event.Type {
+				case L3Execute:
+					queue[i].Quantity = queue[i].Quantity.Sub(event.Quantity)
+				case L3Modify:
+					queue[i].Quantity = event.Quantity
+				case L3Delete:
+					queue = append(queue[:i], queue[i+1:]...)
+				}
+				break
+			}
+			if event.Type == L3Add {
+				queue = append(queue, L3Order{OrderID: event.OrderID, Price: event.Price, Quantity: event.Quantity})
+			}
+		}
+		lastSeq = update.Seq
+	}
+
+	// b1 fills a1 and part of a2; executes carry the trade IDs
+	report, err := me.Execute(limitOrder("b1", 3, models.OrderSideBuy, "101", "2"))
+	require.NoError(t, err)
+	require.Len(t, report.Trades, 2)
+	update := next()
+	var types []L3EventType
+	for _, event := range update.Events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []L3EventType{L3Execute, L3Delete, L3Execute}, types)
+	assert.Equal(t, report.Trades[1].ID, update.Events[2].TradeID)
+	apply(update)
+
+	// Shrinking keeps a2's place, adding a3 queues behind it
+	_, err = me.AmendOrder("BTC_USDT", "a2", 2, decimal.Zero, decimal.RequireFromString("1.5"))
+	require.NoError(t, err)
+	apply(next())
+	_, err = me.Execute(limitOrder("a3", 4, models.OrderSideSell, "101", "1"))
+	require.NoError(t, err)
+	apply(next())
+
+	assert.Equal(t, ob.GetL3Snapshot().Asks, queue)
+	require.NoError(t, me.CancelOrder("BTC_USDT", "a2"))
+	apply(next())
+	assert.Equal(t, ob.GetL3Snapshot().Asks, queue)
+}
+
+// TestL3OrderIDKeyed checks the public order IDs of the L3 feed depend on the
+// engine's key, so they cannot be derived from the public order IDs alone
+func TestL3OrderIDKeyed(t *testing.T) {
+	publicID := func(key []byte) string {
+		me := newTestEngine(t)
+		if key != nil {
+			me.SetL3Key(key)
+		}
+		_, err := me.Execute(limitOrder("a1", 1, models.OrderSideSell, "101", "1"))
+		require.NoError(t, err)
+		ob, _ := me.GetOrderBook("BTC_USDT")
+		asks := ob.GetL3Snapshot().Asks
+		require.Len(t, asks, 1)
+		return asks[0].OrderID
+	}
+
+	key := []byte("server secret")
+	id := publicID(key)
+	assert.Equal(t, id, publicID(key), "a configured key keeps IDs stable across restarts")
+	assert.NotEqual(t, id, publicID([]byte("other secret")))
+	assert.NotEqual(t, publicID(nil), publicID(nil), "unconfigured engines use random keys")
+
+	// Unkeyed derivations of the order ID do not match
+	for _, guess := range []string{
+		"a1",
+		uuid.NewSHA1(uuid.NameSpaceOID, []byte("a1")).String(),
+		uuid.NewSHA1(uuid.NewSHA1(uuid.NameSpaceOID, []byte("easitradecoins.l3")), []byte("a1")).String(),
+		publicOrderID(nil, "a1"),
+	} {
+		assert.NotEqual(t, guess, id)
+	}
+}
@@ -0,0 +1,117 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// expiryItem is a pending GTD expiry or auction uncross
+type expiryItem struct {
+	at      time.Time
+	kind    CommandType
+	symbol  string
+	orderID string
+}
+
+// expiryQueue is a min-heap of expiries ordered by time
+type expiryQueue []*expiryItem
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(*expiryItem)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// expiryScheduler wakes up when the earliest GTD order or auction end is
+// due. Due items are fed back to the symbol workers as journaled commands, so
+// a replay cancels exactly the same orders at the same point in the input
+// stream.
+type expiryScheduler struct {
+	queue expiryQueue
+	wake  chan struct{}
+	mu    sync.Mutex
+}
+
+func newExpiryScheduler() *expiryScheduler {
+	return &expiryScheduler{wake: make(chan struct{}, 1)}
+}
+
+// schedule registers an order expiry; it never blocks the caller
+func (s *expiryScheduler) schedule(at time.Time, symbol, orderID string) {
+	s.scheduleCommand(at, CommandExpireOrder, symbol, orderID)
+}
+
+// scheduleCommand registers a command to submit at a given time
+func (s *expiryScheduler) scheduleCommand(at time.Time, kind CommandType, symbol, orderID string) {
+	s.mu.Lock()
+	heap.Push(&s.queue, &expiryItem{at: at, kind: kind, symbol: symbol, orderID: orderID})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due pops every expiry at or before now and returns the wait until the next one
+func (s *expiryScheduler) due(now time.Time) ([]*expiryItem, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []*expiryItem
+	for s.queue.Len() > 0 && !s.queue[0].at.After(now) {
+		items = append(items, heap.Pop(&s.queue).(*expiryItem))
+	}
+
+	wait := time.Hour
+	if s.queue.Len() > 0 {
+		wait = s.queue[0].at.Sub(now)
+	}
+	return items, wait
+}
+
+// runExpiry submits the commands of due items until the engine stops
+func (me *MatchingEngine) runExpiry() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		items, wait := me.expiry.due(time.Now())
+		if len(items) > 0 {
+			// Hold off while a replay is rebuilding the books
+			me.replayGate.RLock()
+			for _, item := range items {
+				me.submitAsync(item.symbol, &command{
+					kind:    item.kind,
+					orderID: item.orderID,
+				})
+			}
+			me.replayGate.RUnlock()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-me.expiry.wake:
+		case <-me.stopChan:
+			return
+		}
+	}
+}
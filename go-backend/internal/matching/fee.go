@@ -0,0 +1,70 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"strings"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// DefaultFeeRate is charged to both sides when no fee calculator is set
+var DefaultFeeRate = decimal.NewFromFloat(0.001)
+
+// FeeCharge is the fee one side of a trade pays
+type FeeCharge struct {
+	Amount   decimal.Decimal
+	Currency string
+}
+
+// FeeCalculator resolves the maker and taker rates a user pays on a symbol.
+// The engine stamps them on each new order before it is journaled, so fees
+// do not change when the journal is replayed with different rates.
+type FeeCalculator interface {
+	GetFeeRates(userID uint, symbol string) (makerRate, takerRate decimal.Decimal)
+}
+
+// FlatFeeCalculator charges the same rate to makers and takers
+type FlatFeeCalculator struct {
+	Rate decimal.Decimal
+}
+
+// GetFeeRates implements FeeCalculator
+func (c *FlatFeeCalculator) GetFeeRates(userID uint, symbol string) (decimal.Decimal, decimal.Decimal) {
+	return c.Rate, c.Rate
+}
+
+// feeRate returns the rate an order pays in a trade. Orders journaled before
+// rates were stamped fall back to the current rates.
+func (me *MatchingEngine) feeRate(order *models.Order, isMaker bool) decimal.Decimal {
+	if isMaker && order.MakerFeeRate != nil {
+		return *order.MakerFeeRate
+	}
+	if !isMaker && order.TakerFeeRate != nil {
+		return *order.TakerFeeRate
+	}
+	makerRate, takerRate := me.fees.GetFeeRates(order.UserID, order.Symbol)
+	if isMaker {
+		return makerRate
+	}
+	return takerRate
+}
+
+// ReceivedCurrencyFee charges rate on what a side receives: the base
+// quantity for the buyer and the quote amount for the seller
+func ReceivedCurrencyFee(trade *models.Trade, side models.OrderSide, rate decimal.Decimal) FeeCharge {
+	base, quote := SplitSymbol(trade.Symbol)
+	if side == models.OrderSideBuy {
+		return FeeCharge{Amount: trade.Quantity.Mul(rate), Currency: base}
+	}
+	return FeeCharge{Amount: trade.Amount.Mul(rate), Currency: quote}
+}
+
+// SplitSymbol splits a symbol such as BTC_USDT into base and quote currency
+func SplitSymbol(symbol string) (string, string) {
+	base, quote, _ := strings.Cut(symbol, "_")
+	return base, quote
+}
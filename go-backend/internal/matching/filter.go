@@ -0,0 +1,109 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"fmt"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// Filter error codes returned to API clients
+const (
+	FilterCodeSymbolNotTrading = "SYMBOL_NOT_TRADING"
+	FilterCodeTickSize         = "INVALID_TICK_SIZE"
+	FilterCodeStepSize         = "INVALID_STEP_SIZE"
+	FilterCodeMinQuantity      = "QUANTITY_TOO_LOW"
+	FilterCodeMaxQuantity      = "QUANTITY_TOO_HIGH"
+	FilterCodeMinNotional      = "NOTIONAL_TOO_LOW"
+)
+
+// FilterError is returned for orders rejected by a symbol filter
+type FilterError struct {
+	Code    string
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// SymbolFilter holds the trading rules of a symbol
+type SymbolFilter struct {
+	Symbol      string          `json:"symbol"`
+	Active      bool            `json:"active"`
+	TickSize    decimal.Decimal `json:"tick_size"`
+	StepSize    decimal.Decimal `json:"step_size"`
+	MinQuantity decimal.Decimal `json:"min_quantity"`
+	MaxQuantity decimal.Decimal `json:"max_quantity"`
+	MinNotional decimal.Decimal `json:"min_notional"`
+
+	Algorithm MatchingAlgorithm `json:"matching_algorithm"` // how levels are shared, empty for FIFO
+}
+
+// NewSymbolFilter derives a symbol filter from a trading pair
+func NewSymbolFilter(pair *models.TradingPair) *SymbolFilter {
+	return &SymbolFilter{
+		Symbol:      pair.Symbol,
+		Active:      pair.IsActive,
+		TickSize:    decimal.New(1, -int32(pair.PricePrecision)),
+		StepSize:    decimal.New(1, -int32(pair.QuantityPrecision)),
+		MinQuantity: pair.MinQuantity,
+		MaxQuantity: pair.MaxQuantity,
+		MinNotional: pair.MinAmount,
+		Algorithm:   MatchingAlgorithm(pair.MatchingAlgorithm),
+	}
+}
+
+// Validate checks an order against the filter. Market orders have no price,
+// so the tick size and notional checks apply to orders with a limit price
+// and to the trigger prices of conditional orders only. Pegged orders are
+// priced by the engine, so only their peg limit is checked. Orders sized in
+// quote currency check their budget against the minimum notional instead of
+// the quantity rules.
+func (f *SymbolFilter) Validate(order *models.Order) error {
+	if !f.Active {
+		return &FilterError{Code: FilterCodeSymbolNotTrading, Message: fmt.Sprintf("%s is not trading", f.Symbol)}
+	}
+
+	if HasLimitPrice(order) && order.PegType == "" && !order.Price.Mod(f.TickSize).IsZero() {
+		return &FilterError{Code: FilterCodeTickSize, Message: fmt.Sprintf("price must be a multiple of %s", f.TickSize)}
+	}
+
+	if trigger := triggerPrice(order); IsConditional(order) && trigger != nil && !trigger.Mod(f.TickSize).IsZero() {
+		return &FilterError{Code: FilterCodeTickSize, Message: fmt.Sprintf("trigger price must be a multiple of %s", f.TickSize)}
+	}
+
+	if order.PegLimit != nil && !order.PegLimit.Mod(f.TickSize).IsZero() {
+		return &FilterError{Code: FilterCodeTickSize, Message: fmt.Sprintf("peg limit must be a multiple of %s", f.TickSize)}
+	}
+
+	if order.QuoteOrderQty != nil && order.Quantity.IsZero() {
+		if f.MinNotional.IsPositive() && order.QuoteOrderQty.LessThan(f.MinNotional) {
+			return &FilterError{Code: FilterCodeMinNotional, Message: fmt.Sprintf("order value must be at least %s", f.MinNotional)}
+		}
+		return nil
+	}
+
+	if !order.Quantity.Mod(f.StepSize).IsZero() {
+		return &FilterError{Code: FilterCodeStepSize, Message: fmt.Sprintf("quantity must be a multiple of %s", f.StepSize)}
+	}
+
+	if f.MinQuantity.IsPositive() && order.Quantity.LessThan(f.MinQuantity) {
+		return &FilterError{Code: FilterCodeMinQuantity, Message: fmt.Sprintf("quantity must be at least %s", f.MinQuantity)}
+	}
+
+	if f.MaxQuantity.IsPositive() && order.Quantity.GreaterThan(f.MaxQuantity) {
+		return &FilterError{Code: FilterCodeMaxQuantity, Message: fmt.Sprintf("quantity must be at most %s", f.MaxQuantity)}
+	}
+
+	if HasLimitPrice(order) && order.PegType == "" && f.MinNotional.IsPositive() &&
+		order.Price.Mul(order.Quantity).LessThan(f.MinNotional) {
+		return &FilterError{Code: FilterCodeMinNotional, Message: fmt.Sprintf("order value must be at least %s", f.MinNotional)}
+	}
+
+	return nil
+}
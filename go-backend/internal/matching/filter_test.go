@@ -0,0 +1,74 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSymbolFilter checks orders are rejected with the filter's error code
+func TestSymbolFilter(t *testing.T) {
+	me := newTestEngine(t)
+	me.SetSymbolFilters([]*SymbolFilter{NewSymbolFilter(&models.TradingPair{
+		Symbol:            "BTC_USDT",
+		PricePrecision:    2,
+		QuantityPrecision: 3,
+		MinQuantity:       decimal.RequireFromString("0.001"),
+		MaxQuantity:       decimal.RequireFromString("100"),
+		MinAmount:         decimal.RequireFromString("10"),
+		IsActive:          true,
+	})})
+
+	tests := []struct {
+		name     string
+		price    string
+		qty      string
+		wantCode string
+	}{
+		{"valid", "100.25", "0.5", ""},
+		{"off tick", "100.255", "0.5", FilterCodeTickSize},
+		{"off step", "100", "0.0005", FilterCodeStepSize},
+		{"below min notional", "100", "0.05", FilterCodeMinNotional},
+		{"above max quantity", "100", "150", FilterCodeMaxQuantity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := me.ProcessOrder(limitOrder("", 1, models.OrderSideBuy, tt.price, tt.qty))
+			if tt.wantCode == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var filterErr *FilterError
+			require.True(t, errors.As(err, &filterErr), "unexpected error %v", err)
+			assert.Equal(t, tt.wantCode, filterErr.Code)
+		})
+	}
+
+	// Trigger prices of conditional orders are on the tick too
+	stop := limitOrder("", 1, models.OrderSideBuy, "100.25", "0.5")
+	stop.Type = models.OrderTypeStopLimit
+	offTick := decimal.RequireFromString("101.255")
+	stop.StopPrice = &offTick
+	_, err := me.ProcessOrder(stop)
+	var stopErr *FilterError
+	require.True(t, errors.As(err, &stopErr), "unexpected error %v", err)
+	assert.Equal(t, FilterCodeTickSize, stopErr.Code)
+
+	// Halting the pair rejects everything
+	halted := NewSymbolFilter(&models.TradingPair{Symbol: "BTC_USDT", PricePrecision: 2, QuantityPrecision: 3})
+	me.SetSymbolFilters([]*SymbolFilter{halted})
+	_, err = me.ProcessOrder(limitOrder("", 1, models.OrderSideBuy, "100", "1"))
+	var filterErr *FilterError
+	require.True(t, errors.As(err, &filterErr))
+	assert.Equal(t, FilterCodeSymbolNotTrading, filterErr.Code)
+}
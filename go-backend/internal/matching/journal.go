@@ -0,0 +1,166 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+)
+
+// JournalEntry is one sequenced engine input
+type JournalEntry struct {
+	Seq     uint64        `json:"seq"`
+	Type    CommandType   `json:"type"`
+	Symbol  string        `json:"symbol"`
+	Time    time.Time     `json:"time"`
+	Order   *models.Order `json:"order,omitempty"`
+	OrderID string        `json:"order_id,omitempty"`
+	Until   *time.Time    `json:"until,omitempty"`
+	Status  TradingStatus `json:"status,omitempty"`
+}
+
+// Journal is an append-only log of engine inputs. Entries are appended in
+// strictly increasing sequence order before the engine applies them.
+type Journal interface {
+	Append(entry *JournalEntry) error
+	Replay(afterSeq uint64, fn func(entry *JournalEntry) error) error
+	LastSeq() uint64
+	Close() error
+}
+
+// FileJournal stores journal entries as JSON lines in a local file
+type FileJournal struct {
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	lastSeq uint64
+	mu      sync.Mutex
+}
+
+// NewFileJournal opens (or creates) a file-backed journal
+func NewFileJournal(path string) (*FileJournal, error) {
+	j := &FileJournal{path: path}
+
+	// Recover the last sequence number from existing entries
+	validSize, err := j.scan(func(entry *JournalEntry) error {
+		j.lastSeq = entry.Seq
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	// Drop a torn final line left by a crash mid-write
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate journal: %w", err)
+	}
+
+	j.file = file
+	j.writer = bufio.NewWriter(file)
+	return j, nil
+}
+
+// Append writes an entry and syncs it to disk before returning
+func (j *FileJournal) Append(entry *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if entry.Seq <= j.lastSeq {
+		return fmt.Errorf("journal sequence %d is not after %d", entry.Seq, j.lastSeq)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.lastSeq = entry.Seq
+	return nil
+}
+
+// Replay calls fn for every entry with a sequence number above afterSeq
+func (j *FileJournal) Replay(afterSeq uint64, fn func(entry *JournalEntry) error) error {
+	_, err := j.scan(func(entry *JournalEntry) error {
+		if entry.Seq <= afterSeq {
+			return nil
+		}
+		return fn(entry)
+	})
+	return err
+}
+
+// scan reads every complete entry and returns the size of the valid prefix
+func (j *FileJournal) scan(fn func(entry *JournalEntry) error) (int64, error) {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A torn final line is an input that was never acknowledged
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return offset, fmt.Errorf("corrupt journal entry at offset %d: %w", offset, err)
+		}
+
+		if err := fn(&entry); err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+	}
+}
+
+// LastSeq returns the sequence number of the last appended entry
+func (j *FileJournal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastSeq
+}
+
+// Close flushes and closes the journal file
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	return j.file.Close()
+}
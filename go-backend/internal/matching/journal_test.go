@@ -0,0 +1,205 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestJournalReplayDeterminism drives a journaled engine with a random mix of
+// inputs on two symbols and checks that replaying the journal into a fresh
+// engine reproduces the same trades and the same resting books
+func TestJournalReplayDeterminism(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	journal, err := NewFileJournal(path)
+	require.NoError(t, err)
+
+	live := newTestEngine(t)
+	live.SetJournal(journal)
+
+	rng := rand.New(rand.NewSource(42))
+	symbols := []string{"BTC_USDT", "ETH_USDT"}
+	var liveTrades []*models.Trade
+	var resting []*models.Order
+
+	for i := 0; i < 2000; i++ {
+		if len(resting) > 0 && rng.Intn(5) == 0 {
+			// Cancel a random earlier order (it may already be filled)
+			target := resting[rng.Intn(len(resting))]
+			live.CancelOrder(target.Symbol, target.ID)
+			continue
+		}
+
+		order := limitOrder(fmt.Sprintf("o-%d", i), uint(rng.Intn(10)+1),
+			models.OrderSideBuy, fmt.Sprintf("%d", 95+rng.Intn(11)), fmt.Sprintf("%d.%d", rng.Intn(5)+1, rng.Intn(10)))
+		order.Symbol = symbols[rng.Intn(len(symbols))]
+		if rng.Intn(2) == 0 {
+			order.Side = models.OrderSideSell
+		}
+		switch rng.Intn(10) {
+		case 0:
+			order.Type = models.OrderTypeMarket
+			order.Price = decimal.Zero
+		case 1:
+			order.TimeInForce = models.TimeInForceIOC
+		}
+
+		report, err := live.Execute(order)
+		require.NoError(t, err)
+		liveTrades = append(liveTrades, report.Trades...)
+		resting = append(resting, order)
+	}
+	require.NotEmpty(t, liveTrades)
+	require.NoError(t, journal.Close())
+
+	// Rebuild from the journal alone
+	reopened, err := NewFileJournal(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, live.LastSeq(), reopened.LastSeq())
+
+	replayed := newTestEngine(t)
+	replayTrades, err := replayed.Replay(reopened, 0)
+	require.NoError(t, err)
+	assert.Equal(t, live.LastSeq(), replayed.LastSeq())
+
+	require.Len(t, replayTrades, len(liveTrades))
+	for i, want := range liveTrades {
+		got := replayTrades[i]
+		assert.Equal(t, want.ID, got.ID, "trade %d", i)
+		assert.Equal(t, want.BuyOrderID, got.BuyOrderID, "trade %d", i)
+		assert.Equal(t, want.SellOrderID, got.SellOrderID, "trade %d", i)
+		assert.True(t, want.Price.Equal(got.Price), "trade %d price", i)
+		assert.True(t, want.Quantity.Equal(got.Quantity), "trade %d quantity", i)
+		assert.True(t, want.BuyerFee.Equal(got.BuyerFee), "trade %d buyer fee", i)
+		assert.True(t, want.SellerFee.Equal(got.SellerFee), "trade %d seller fee", i)
+		assert.True(t, want.TradeTime.Equal(got.TradeTime), "trade %d time", i)
+	}
+
+	for _, symbol := range symbols {
+		liveBook, _ := live.GetOrderBook(symbol)
+		replayedBook, _ := replayed.GetOrderBook(symbol)
+		liveBids, liveAsks := liveBook.GetDepth(100)
+		replayedBids, replayedAsks := replayedBook.GetDepth(100)
+		assertSameDepth(t, liveBids, replayedBids)
+		assertSameDepth(t, liveAsks, replayedAsks)
+	}
+}
+
+func assertSameDepth(t *testing.T, want, got []PriceLevelInfo) {
+	t.Helper()
+
+	require.Len(t, got, len(want))
+	for i := range want {
+		assert.True(t, want[i].Price.Equal(got[i].Price), "level %d price", i)
+		assert.True(t, want[i].Volume.Equal(got[i].Volume), "level %d volume", i)
+		assert.Equal(t, want[i].Count, got[i].Count, "level %d count", i)
+	}
+}
+
+// TestJournalReplayFeeRates checks a replay charges the rates in force when
+// each order was placed, not the rates the replaying engine is configured with
+func TestJournalReplayFeeRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	journal, err := NewFileJournal(path)
+	require.NoError(t, err)
+
+	fees := &makerTakerFees{maker: decimal.RequireFromString("0.001"), taker: decimal.RequireFromString("0.002")}
+	live := newTestEngine(t)
+	live.SetFeeCalculator(fees)
+	live.SetJournal(journal)
+
+	_, err = live.Execute(limitOrder("maker", 1, models.OrderSideSell, "100", "3"))
+	require.NoError(t, err)
+	report, err := live.Execute(limitOrder("taker-1", 2, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+	liveTrades := report.Trades
+
+	// An override lands between the two fills; only the new taker sees it
+	fees.maker, fees.taker = decimal.RequireFromString("0.01"), decimal.RequireFromString("0.02")
+	report, err = live.Execute(limitOrder("taker-2", 3, models.OrderSideBuy, "100", "1"))
+	require.NoError(t, err)
+	liveTrades = append(liveTrades, report.Trades...)
+	require.Len(t, liveTrades, 2)
+	assert.True(t, liveTrades[1].SellerFee.Equal(decimal.RequireFromString("0.1")), "maker fee %s", liveTrades[1].SellerFee)
+	assert.True(t, liveTrades[1].BuyerFee.Equal(decimal.RequireFromString("0.02")), "taker fee %s", liveTrades[1].BuyerFee)
+	require.NoError(t, journal.Close())
+
+	reopened, err := NewFileJournal(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	replayed := newTestEngine(t)
+	replayed.SetFeeCalculator(&makerTakerFees{maker: decimal.RequireFromString("0.05"), taker: decimal.RequireFromString("0.05")})
+	replayTrades, err := replayed.Replay(reopened, 0)
+	require.NoError(t, err)
+
+	require.Len(t, replayTrades, len(liveTrades))
+	for i, want := range liveTrades {
+		assert.True(t, want.BuyerFee.Equal(replayTrades[i].BuyerFee), "trade %d buyer fee %s", i, replayTrades[i].BuyerFee)
+		assert.True(t, want.SellerFee.Equal(replayTrades[i].SellerFee), "trade %d seller fee %s", i, replayTrades[i].SellerFee)
+	}
+
+	liveBook, _ := live.GetOrderBook("BTC_USDT")
+	replayedBook, _ := replayed.GetOrderBook("BTC_USDT")
+	liveMaker, _ := liveBook.GetOrder("maker")
+	replayedMaker, exists := replayedBook.GetOrder("maker")
+	require.True(t, exists)
+	assert.True(t, liveMaker.Fee.Equal(replayedMaker.Fee), "resting maker fee %s, want %s", replayedMaker.Fee, liveMaker.Fee)
+}
+
+// TestSnapshotRecovery restores a mid-stream snapshot, replays the newer
+// journal entries, and checks queue priority survives the restart
+func TestSnapshotRecovery(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := NewFileJournal(filepath.Join(dir, "engine.journal"))
+	require.NoError(t, err)
+	defer journal.Close()
+
+	live := newTestEngine(t)
+	live.SetJournal(journal)
+
+	for i, user := range []uint{1, 2, 3} {
+		_, err := live.ProcessOrder(limitOrder(fmt.Sprintf("ask-%d", i), user, models.OrderSideSell, "100", "1"))
+		require.NoError(t, err)
+	}
+	_, err = live.ProcessOrder(limitOrder("bid-0", 4, models.OrderSideBuy, "100", "0.5"))
+	require.NoError(t, err)
+
+	_, err = live.WriteSnapshot(filepath.Join(dir, "snapshots"))
+	require.NoError(t, err)
+
+	// Inputs after the snapshot only live in the journal
+	_, err = live.ProcessOrder(limitOrder("ask-3", 5, models.OrderSideSell, "100", "1"))
+	require.NoError(t, err)
+	require.NoError(t, live.CancelOrder("BTC_USDT", "ask-1"))
+
+	recovered := newTestEngine(t)
+	result, err := recovered.Recover(filepath.Join(dir, "snapshots"), journal)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), result.SnapshotSeq)
+	assert.Equal(t, 3, result.RestoredOrders)
+	assert.Equal(t, live.LastSeq(), result.LastSeq)
+
+	// The partially filled first ask must still be first in the queue
+	sweep := limitOrder("bid-1", 6, models.OrderSideBuy, "100", "10")
+	report, err := recovered.Execute(sweep)
+	require.NoError(t, err)
+	require.Len(t, report.Trades, 3)
+	assert.Equal(t, "ask-0", report.Trades[0].SellOrderID)
+	assert.True(t, report.Trades[0].Quantity.Equal(decimal.RequireFromString("0.5")))
+	assert.Equal(t, "ask-2", report.Trades[1].SellOrderID)
+	assert.Equal(t, "ask-3", report.Trades[2].SellOrderID)
+}
@@ -0,0 +1,178 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// L3EventType is the kind of change an order-by-order event describes
+type L3EventType string
+
+const (
+	L3Add     L3EventType = "add"     // order joined the back of its level
+	L3Modify  L3EventType = "modify"  // open quantity reduced in place, keeping priority
+	L3Delete  L3EventType = "delete"  // order left the book
+	L3Execute L3EventType = "execute" // resting order traded
+)
+
+// L3Event is one change to a resting order. Quantity is the order's open
+// quantity after an add or modify and the traded quantity of an execute; a
+// fully filled order is executed and then deleted.
+type L3Event struct {
+	Type     L3EventType      `json:"type"`
+	OrderID  string           `json:"order_id"` // anonymized, stable for the order's life
+	Side     models.OrderSide `json:"side"`
+	Price    decimal.Decimal  `json:"price"`
+	Quantity decimal.Decimal  `json:"quantity"`
+	TradeID  string           `json:"trade_id,omitempty"` // set on executes
+}
+
+// L3Update holds the events of one command in the order they happened. Seq
+// is the command's journal sequence number, the same one its trade IDs are
+// derived from; sequence numbers are shared by all symbols, so PrevSeq links
+// each update to the symbol's previous one. A client loads an L3 snapshot,
+// drops updates with Seq at or below its Seq and resyncs when an update's
+// PrevSeq is not the Seq it applied last.
+type L3Update struct {
+	Symbol  string    `json:"symbol"`
+	Seq     uint64    `json:"seq"`
+	PrevSeq uint64    `json:"prev_seq"`
+	Events  []L3Event `json:"events"`
+	Time    time.Time `json:"time"`
+}
+
+// L3Order is a resting order in an L3 snapshot
+type L3Order struct {
+	OrderID  string          `json:"order_id"`
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"quantity"` // open quantity
+}
+
+// L3Snapshot lists every resting order as of Seq, best level first and in
+// queue order within a level
+type L3Snapshot struct {
+	Symbol string    `json:"symbol"`
+	Seq    uint64    `json:"seq"`
+	Bids   []L3Order `json:"bids"`
+	Asks   []L3Order `json:"asks"`
+}
+
+// publicOrderID anonymizes an order ID for the L3 feed. Order IDs are public
+// on trades, so the ID is keyed: without the key it cannot be linked back.
+func publicOrderID(key []byte, orderID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(orderID))
+	id, _ := uuid.FromBytes(mac.Sum(nil)[:16])
+	return id.String()
+}
+
+// newL3Key returns a random key for books whose engine has no configured key
+func newL3Key() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate L3 key: %v", err))
+	}
+	return key
+}
+
+// SetL3Key sets the secret the public order IDs of the L3 feed are derived
+// from. Without it every engine start uses a random key, so IDs change
+// across restarts. It must be called before any order is processed.
+func (me *MatchingEngine) SetL3Key(key []byte) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.l3Key = key
+	for _, ob := range me.orderBooks {
+		ob.mu.Lock()
+		ob.l3Key = key
+		ob.mu.Unlock()
+	}
+}
+
+// recordL3 appends an event for a resting order; the caller must hold the
+// write lock
+func (ob *OrderBook) recordL3(kind L3EventType, order *models.Order, quantity decimal.Decimal, tradeID string) {
+	ob.events = append(ob.events, L3Event{
+		Type:     kind,
+		OrderID:  publicOrderID(ob.l3Key, order.ID),
+		Side:     order.Side,
+		Price:    order.Price,
+		Quantity: quantity,
+		TradeID:  tradeID,
+	})
+}
+
+// takeL3Update returns the events recorded since the last call as the
+// update of command seq, or nil when there were none; the caller must hold
+// the write lock
+func (ob *OrderBook) takeL3Update(seq uint64, now time.Time) *L3Update {
+	if len(ob.events) == 0 {
+		return nil
+	}
+
+	update := &L3Update{Symbol: ob.Symbol, Seq: seq, PrevSeq: ob.l3Seq, Events: ob.events, Time: now}
+	ob.l3Seq = seq
+	ob.events = nil
+	return update
+}
+
+// GetL3Snapshot returns every resting order of a book with the sequence
+// number of the last update it includes
+func (ob *OrderBook) GetL3Snapshot() *L3Snapshot {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return &L3Snapshot{
+		Symbol: ob.Symbol,
+		Seq:    ob.l3Seq,
+		Bids:   l3Orders(ob.bids, ob.l3Key),
+		Asks:   l3Orders(ob.asks, ob.l3Key),
+	}
+}
+
+// l3Orders lists the orders of a side, best level first, in FIFO order
+func l3Orders(index *priceIndex, key []byte) []L3Order {
+	orders := []L3Order{}
+	index.walk(func(level *PriceLevel) bool {
+		for e := level.Orders.Front(); e != nil; e = e.Next() {
+			order := e.Value.(*models.Order)
+			orders = append(orders, L3Order{
+				OrderID:  publicOrderID(key, order.ID),
+				Price:    order.Price,
+				Quantity: openQuantity(order),
+			})
+		}
+		return true
+	})
+	return orders
+}
+
+// GetL3Chan returns the channel of order-by-order updates. Updates are
+// dropped when it is full; clients see the broken PrevSeq link and resync.
+func (me *MatchingEngine) GetL3Chan() <-chan *L3Update {
+	return me.l3Chan
+}
+
+// publishL3 sends an L3 update without blocking the worker
+func (me *MatchingEngine) publishL3(update *L3Update) {
+	if update == nil {
+		return
+	}
+
+	select {
+	case me.l3Chan <- update:
+	default:
+	}
+}
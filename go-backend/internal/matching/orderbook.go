@@ -5,8 +5,8 @@
 package matching
 
 import (
-	"sort"
 	"sync"
+	"time"
 
 	"github.com/easitradecoins/backend/internal/models"
 	"github.com/shopspring/decimal"
@@ -15,9 +15,23 @@ import (
 // OrderBook represents the order book for a trading pair
 type OrderBook struct {
 	Symbol     string
-	BuyLevels  map[string]*PriceLevel // price -> PriceLevel
-	SellLevels map[string]*PriceLevel // price -> PriceLevel
+	BuyLevels  map[string]*PriceLevel   // price -> PriceLevel
+	SellLevels map[string]*PriceLevel   // price -> PriceLevel
 	OrderMap   map[string]*models.Order // orderID -> Order
+	bids       *priceIndex              // buy levels, highest price first
+	asks       *priceIndex              // sell levels, lowest price first
+	lastSeq    uint64                   // sequence of the last applied command
+	auctionEnd *time.Time               // scheduled uncross while in a call auction
+	status     TradingStatus            // empty for trading
+	reopenAt   *time.Time               // end of a circuit breaker halt
+	prices     priceWindow              // recent trade prices for the circuit breaker
+	triggers   *triggerBook             // conditional orders waiting for their trigger price
+	pegged     map[string]*models.Order // resting pegged orders
+	updateID   uint64                   // last depth update ID handed out
+	changed    map[levelKey]bool        // levels changed by the current command
+	events     []L3Event                // order events of the current command
+	l3Seq      uint64                   // sequence of the last L3 update
+	l3Key      []byte                   // keys the public order IDs of the L3 feed
 	mu         sync.RWMutex
 }
 
@@ -28,52 +42,74 @@ func NewOrderBook(symbol string) *OrderBook {
 		BuyLevels:  make(map[string]*PriceLevel),
 		SellLevels: make(map[string]*PriceLevel),
 		OrderMap:   make(map[string]*models.Order),
+		bids:       newPriceIndex(true),
+		asks:       newPriceIndex(false),
+		triggers:   newTriggerBook(),
+		pegged:     make(map[string]*models.Order),
+		changed:    make(map[levelKey]bool),
+		l3Key:      newL3Key(),
 	}
 }
 
+// sideOf returns the level map and price index for an order side
+func (ob *OrderBook) sideOf(side models.OrderSide) (map[string]*PriceLevel, *priceIndex) {
+	if side == models.OrderSideBuy {
+		return ob.BuyLevels, ob.bids
+	}
+	return ob.SellLevels, ob.asks
+}
+
 // AddOrder adds an order to the order book
 func (ob *OrderBook) AddOrder(order *models.Order) {
 	ob.mu.Lock()
 	defer ob.mu.Unlock()
+	ob.addOrder(order)
+}
 
+// addOrder adds an order; the caller must hold the write lock
+func (ob *OrderBook) addOrder(order *models.Order) {
 	priceKey := order.Price.String()
-
-	var levels map[string]*PriceLevel
-	if order.Side == models.OrderSideBuy {
-		levels = ob.BuyLevels
-	} else {
-		levels = ob.SellLevels
-	}
+	levels, index := ob.sideOf(order.Side)
 
 	// Get or create price level
 	level, exists := levels[priceKey]
 	if !exists {
 		level = NewPriceLevel(order.Price)
 		levels[priceKey] = level
+		index.insert(level)
 	}
 
 	level.AddOrder(order)
 	ob.OrderMap[order.ID] = order
+	ob.touchLevel(order.Side, order.Price)
+	ob.recordL3(L3Add, order, openQuantity(order), "")
+	if order.PegType != "" {
+		ob.pegged[order.ID] = order
+	}
+}
+
+// levelOf returns the level a resting order sits in; the caller must hold the lock
+func (ob *OrderBook) levelOf(order *models.Order) *PriceLevel {
+	levels, _ := ob.sideOf(order.Side)
+	return levels[order.Price.String()]
 }
 
 // RemoveOrder removes an order from the order book
 func (ob *OrderBook) RemoveOrder(orderID string) bool {
 	ob.mu.Lock()
 	defer ob.mu.Unlock()
+	return ob.removeOrder(orderID)
+}
 
+// removeOrder removes an order; the caller must hold the write lock
+func (ob *OrderBook) removeOrder(orderID string) bool {
 	order, exists := ob.OrderMap[orderID]
 	if !exists {
 		return false
 	}
 
 	priceKey := order.Price.String()
-
-	var levels map[string]*PriceLevel
-	if order.Side == models.OrderSideBuy {
-		levels = ob.BuyLevels
-	} else {
-		levels = ob.SellLevels
-	}
+	levels, index := ob.sideOf(order.Side)
 
 	level, exists := levels[priceKey]
 	if !exists {
@@ -82,10 +118,14 @@ func (ob *OrderBook) RemoveOrder(orderID string) bool {
 
 	if level.RemoveOrder(orderID) {
 		delete(ob.OrderMap, orderID)
+		delete(ob.pegged, orderID)
+		ob.touchLevel(order.Side, order.Price)
+		ob.recordL3(L3Delete, order, decimal.Zero, "")
 
 		// Remove empty price level
 		if level.IsEmpty() {
 			delete(levels, priceKey)
+			index.remove(level.Price)
 		}
 
 		return true
@@ -94,13 +134,35 @@ func (ob *OrderBook) RemoveOrder(orderID string) bool {
 	return false
 }
 
-// GetOrder returns an order by ID
+// openOrder returns a resting or conditional order; the caller must hold the lock
+func (ob *OrderBook) openOrder(orderID string) (*models.Order, bool) {
+	if order, exists := ob.OrderMap[orderID]; exists {
+		return order, true
+	}
+	order, exists := ob.triggers.orders[orderID]
+	return order, exists
+}
+
+// removeOpenOrder removes a resting or conditional order; the caller must
+// hold the write lock
+func (ob *OrderBook) removeOpenOrder(orderID string) bool {
+	if ob.removeOrder(orderID) {
+		return true
+	}
+	_, removed := ob.triggers.remove(orderID)
+	return removed
+}
+
+// GetOrder returns a snapshot of a resting order by ID
 func (ob *OrderBook) GetOrder(orderID string) (*models.Order, bool) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
 	order, exists := ob.OrderMap[orderID]
-	return order, exists
+	if !exists {
+		return nil, false
+	}
+	return snapshotOrder(order), true
 }
 
 // GetBestBid returns the highest buy price
@@ -108,22 +170,11 @@ func (ob *OrderBook) GetBestBid() (decimal.Decimal, bool) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
-	if len(ob.BuyLevels) == 0 {
+	level := ob.bids.first()
+	if level == nil {
 		return decimal.Zero, false
 	}
-
-	var bestPrice decimal.Decimal
-	first := true
-
-	for priceStr := range ob.BuyLevels {
-		price, _ := decimal.NewFromString(priceStr)
-		if first || price.GreaterThan(bestPrice) {
-			bestPrice = price
-			first = false
-		}
-	}
-
-	return bestPrice, true
+	return level.Price, true
 }
 
 // GetBestAsk returns the lowest sell price
@@ -131,22 +182,65 @@ func (ob *OrderBook) GetBestAsk() (decimal.Decimal, bool) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
-	if len(ob.SellLevels) == 0 {
+	level := ob.asks.first()
+	if level == nil {
 		return decimal.Zero, false
 	}
+	return level.Price, true
+}
+
+// BestBidLevel returns the highest buy price level
+func (ob *OrderBook) BestBidLevel() *PriceLevel {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.bids.first()
+}
+
+// BestAskLevel returns the lowest sell price level
+func (ob *OrderBook) BestAskLevel() *PriceLevel {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.asks.first()
+}
 
-	var bestPrice decimal.Decimal
-	first := true
+// bestLevel returns the best level of a side; the caller must hold the lock
+func (ob *OrderBook) bestLevel(side models.OrderSide) *PriceLevel {
+	_, index := ob.sideOf(side)
+	return index.first()
+}
 
-	for priceStr := range ob.SellLevels {
-		price, _ := decimal.NewFromString(priceStr)
-		if first || price.LessThan(bestPrice) {
-			bestPrice = price
-			first = false
+// fillableQuantity returns how much of the taker's remaining quantity the
+// opposite side can fill at prices no worse than limit, or at any price when
+// limit is nil. Resting orders of the taker's own user are skipped when self-
+// trade prevention removes them, and end the walk otherwise. It only reads
+// the book; the caller must hold the lock.
+func (ob *OrderBook) fillableQuantity(taker *models.Order, limit *decimal.Decimal) decimal.Decimal {
+	_, index := ob.sideOf(oppositeSide(taker.Side))
+	want := taker.Quantity.Sub(taker.FilledQty)
+	mode := stpMode(taker)
+
+	available := decimal.Zero
+	index.walk(func(level *PriceLevel) bool {
+		if limit != nil && !crosses(taker.Side, *limit, level.Price) {
+			return false
 		}
-	}
+		for e := level.Orders.Front(); e != nil; e = e.Next() {
+			maker := e.Value.(*models.Order)
+			if maker.UserID == taker.UserID {
+				if mode == models.STPCancelOldest {
+					continue
+				}
+				return false
+			}
+			available = available.Add(maker.Quantity.Sub(maker.FilledQty))
+			if !available.LessThan(want) {
+				return false
+			}
+		}
+		return true
+	})
 
-	return bestPrice, true
+	return available
 }
 
 // GetDepth returns order book depth
@@ -154,47 +248,32 @@ func (ob *OrderBook) GetDepth(depth int) ([]PriceLevelInfo, []PriceLevelInfo) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
-	// Get buy levels (sorted descending)
-	buyPrices := make([]decimal.Decimal, 0, len(ob.BuyLevels))
-	for priceStr := range ob.BuyLevels {
-		price, _ := decimal.NewFromString(priceStr)
-		buyPrices = append(buyPrices, price)
-	}
-	sort.Slice(buyPrices, func(i, j int) bool {
-		return buyPrices[i].GreaterThan(buyPrices[j])
-	})
+	return collectDepth(ob.bids, depth), collectDepth(ob.asks, depth)
+}
 
-	bids := make([]PriceLevelInfo, 0, depth)
-	for i := 0; i < len(buyPrices) && i < depth; i++ {
-		level := ob.BuyLevels[buyPrices[i].String()]
-		bids = append(bids, PriceLevelInfo{
-			Price:  buyPrices[i],
-			Volume: level.GetVolume(),
-			Count:  level.GetOrderCount(),
-		})
+// collectDepth walks at most depth levels of one side from the best price
+func collectDepth(index *priceIndex, depth int) []PriceLevelInfo {
+	if depth < 0 {
+		depth = 0
 	}
-
-	// Get sell levels (sorted ascending)
-	sellPrices := make([]decimal.Decimal, 0, len(ob.SellLevels))
-	for priceStr := range ob.SellLevels {
-		price, _ := decimal.NewFromString(priceStr)
-		sellPrices = append(sellPrices, price)
+	if depth > index.len() {
+		depth = index.len()
 	}
-	sort.Slice(sellPrices, func(i, j int) bool {
-		return sellPrices[i].LessThan(sellPrices[j])
-	})
 
-	asks := make([]PriceLevelInfo, 0, depth)
-	for i := 0; i < len(sellPrices) && i < depth; i++ {
-		level := ob.SellLevels[sellPrices[i].String()]
-		asks = append(asks, PriceLevelInfo{
-			Price:  sellPrices[i],
+	infos := make([]PriceLevelInfo, 0, depth)
+	index.walk(func(level *PriceLevel) bool {
+		if len(infos) >= depth {
+			return false
+		}
+		infos = append(infos, PriceLevelInfo{
+			Price:  level.Price,
 			Volume: level.GetVolume(),
 			Count:  level.GetOrderCount(),
 		})
-	}
+		return true
+	})
 
-	return bids, asks
+	return infos
 }
 
 // PriceLevelInfo represents aggregated price level information
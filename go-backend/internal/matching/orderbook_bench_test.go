@@ -0,0 +1,101 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	benchRestingOrders  = 100000
+	benchOrdersPerLevel = 10
+)
+
+// newDeepBookEngine builds an engine with benchRestingOrders resting orders
+// split evenly between bids below 10000 and asks above it
+func newDeepBookEngine(b *testing.B) *MatchingEngine {
+	b.Helper()
+
+	me := NewMatchingEngine()
+	go func() {
+		for range me.GetTradeChan() {
+		}
+	}()
+
+	levels := benchRestingOrders / benchOrdersPerLevel / 2
+	n := 0
+	for i := 0; i < levels; i++ {
+		for j := 0; j < benchOrdersPerLevel; j++ {
+			n++
+			bid := benchOrder(fmt.Sprintf("bid-%d", n), models.OrderSideBuy, 9999-int64(i))
+			ask := benchOrder(fmt.Sprintf("ask-%d", n), models.OrderSideSell, 10001+int64(i))
+			if _, err := me.ProcessOrder(bid); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := me.ProcessOrder(ask); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	return me
+}
+
+func benchOrder(id string, side models.OrderSide, price int64) *models.Order {
+	return &models.Order{
+		ID:          id,
+		UserID:      1,
+		Symbol:      "BTC_USDT",
+		Side:        side,
+		Type:        models.OrderTypeLimit,
+		Price:       decimal.NewFromInt(price),
+		Quantity:    decimal.NewFromInt(1),
+		TimeInForce: models.TimeInForceGTC,
+	}
+}
+
+// BenchmarkProcessOrderDeepBook takes one resting ask per iteration and
+// replenishes it at the far end of the book so the depth stays constant
+func BenchmarkProcessOrderDeepBook(b *testing.B) {
+	me := newDeepBookEngine(b)
+	farAsk := int64(10001 + benchRestingOrders/benchOrdersPerLevel/2)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob, _ := me.GetOrderBook("BTC_USDT")
+		bestAsk, _ := ob.GetBestAsk()
+
+		taker := benchOrder(fmt.Sprintf("taker-%d", i), models.OrderSideBuy, bestAsk.IntPart())
+		taker.UserID = 0
+		if _, err := me.ProcessOrder(taker); err != nil {
+			b.Fatal(err)
+		}
+
+		refill := benchOrder(fmt.Sprintf("refill-%d", i), models.OrderSideSell, farAsk)
+		if _, err := me.ProcessOrder(refill); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetDepthDeepBook reads the top 20 levels of each side
+func BenchmarkGetDepthDeepBook(b *testing.B) {
+	me := newDeepBookEngine(b)
+	ob, _ := me.GetOrderBook("BTC_USDT")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bids, asks := ob.GetDepth(20)
+		if len(bids) != 20 || len(asks) != 20 {
+			b.Fatalf("unexpected depth %d/%d", len(bids), len(asks))
+		}
+	}
+}
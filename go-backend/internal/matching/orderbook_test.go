@@ -0,0 +1,61 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOrderBookPriceIndex checks that both sides stay sorted by priority as
+// levels are created and emptied
+func TestOrderBookPriceIndex(t *testing.T) {
+	ob := NewOrderBook("BTC_USDT")
+
+	prices := []int64{105, 101, 110, 99, 103, 108, 100}
+	for i, p := range prices {
+		ob.AddOrder(&models.Order{
+			ID: fmt.Sprintf("b%d", i), Side: models.OrderSideBuy,
+			Price: decimal.NewFromInt(p), Quantity: decimal.NewFromInt(1),
+		})
+		ob.AddOrder(&models.Order{
+			ID: fmt.Sprintf("s%d", i), Side: models.OrderSideSell,
+			Price: decimal.NewFromInt(p + 100), Quantity: decimal.NewFromInt(2),
+		})
+	}
+
+	bestBid, ok := ob.GetBestBid()
+	require.True(t, ok)
+	assert.True(t, bestBid.Equal(decimal.NewFromInt(110)))
+
+	bestAsk, ok := ob.GetBestAsk()
+	require.True(t, ok)
+	assert.True(t, bestAsk.Equal(decimal.NewFromInt(199)))
+
+	// Emptying the best levels promotes the next ones
+	require.True(t, ob.RemoveOrder("b2"))
+	require.True(t, ob.RemoveOrder("s3"))
+
+	bids, asks := ob.GetDepth(3)
+	require.Len(t, bids, 3)
+	require.Len(t, asks, 3)
+	for i, want := range []int64{108, 105, 103} {
+		assert.True(t, bids[i].Price.Equal(decimal.NewFromInt(want)), "bid %d", i)
+	}
+	for i, want := range []int64{200, 201, 203} {
+		assert.True(t, asks[i].Price.Equal(decimal.NewFromInt(want)), "ask %d", i)
+		assert.True(t, asks[i].Volume.Equal(decimal.NewFromInt(2)))
+	}
+
+	// Depth larger than the book is bounded by the number of levels
+	bids, asks = ob.GetDepth(100)
+	assert.Len(t, bids, 6)
+	assert.Len(t, asks, 6)
+}
@@ -0,0 +1,164 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrNoPegReference is returned for pegged orders whose reference price does not exist
+	ErrNoPegReference = errors.New("no reference price for pegged order")
+	// ErrPeggedPrice is returned when the price of a pegged order is amended
+	ErrPeggedPrice = errors.New("pegged orders are priced by the engine")
+)
+
+// validatePeg checks the peg fields of an order
+func validatePeg(order *models.Order) error {
+	switch order.PegType {
+	case models.PegPrimary, models.PegMarket, models.PegMid:
+	default:
+		return errors.New("invalid peg type")
+	}
+
+	if order.Type != models.OrderTypeLimit {
+		return errors.New("only limit orders can be pegged")
+	}
+	if order.TimeInForce == models.TimeInForceIOC || order.TimeInForce == models.TimeInForceFOK {
+		return errors.New("pegged order cannot be IOC or FOK")
+	}
+	if order.PegLimit != nil && !order.PegLimit.IsPositive() {
+		return errors.New("peg limit must be positive")
+	}
+	return nil
+}
+
+// pegReference returns the best price of a side among orders that are not
+// pegged, so pegs never follow each other
+func (ob *OrderBook) pegReference(side models.OrderSide) *decimal.Decimal {
+	_, index := ob.sideOf(side)
+
+	var reference *decimal.Decimal
+	index.walk(func(level *PriceLevel) bool {
+		for e := level.Orders.Front(); e != nil; e = e.Next() {
+			if e.Value.(*models.Order).PegType == "" {
+				price := level.Price
+				reference = &price
+				return false
+			}
+		}
+		return true
+	})
+	return reference
+}
+
+// pegPrice returns where a pegged order rests: its reference plus the
+// offset, rounded away from the opposite side to the tick and bounded by the
+// peg limit. Pegs only provide liquidity, so a price that would cross the
+// opposite best is moved one tick behind it. It returns nil when the
+// reference does not exist.
+func (me *MatchingEngine) pegPrice(ob *OrderBook, order *models.Order) *decimal.Decimal {
+	var reference, quote *decimal.Decimal // quote is a book price the tick is derived from
+	switch order.PegType {
+	case models.PegPrimary:
+		reference = ob.pegReference(order.Side)
+		quote = reference
+	case models.PegMarket:
+		reference = ob.pegReference(oppositeSide(order.Side))
+		quote = reference
+	case models.PegMid:
+		bid, ask := ob.pegReference(models.OrderSideBuy), ob.pegReference(models.OrderSideSell)
+		if bid != nil && ask != nil {
+			mid := bid.Add(*ask).Div(decimal.NewFromInt(2))
+			reference, quote = &mid, bid
+		}
+	}
+	if reference == nil {
+		return nil
+	}
+
+	price := *reference
+	if order.PegOffset != nil {
+		price = price.Add(*order.PegOffset)
+	}
+
+	tick := me.tickSize(ob, *quote)
+	if order.Side == models.OrderSideBuy {
+		price = price.Div(tick).Floor().Mul(tick)
+		if order.PegLimit != nil && price.GreaterThan(*order.PegLimit) {
+			price = *order.PegLimit
+		}
+	} else {
+		price = price.Div(tick).Ceil().Mul(tick)
+		if order.PegLimit != nil && price.LessThan(*order.PegLimit) {
+			price = *order.PegLimit
+		}
+	}
+
+	if opposite := ob.bestLevel(oppositeSide(order.Side)); opposite != nil && crosses(order.Side, price, opposite.Price) {
+		price = opposite.Price.Add(tick)
+		if order.Side == models.OrderSideBuy {
+			price = opposite.Price.Sub(tick)
+		}
+	}
+
+	if !price.IsPositive() {
+		return nil
+	}
+	return &price
+}
+
+// applyPeg prices a new pegged order and rests it
+func (me *MatchingEngine) applyPeg(ob *OrderBook, order *models.Order, report *ExecutionReport) error {
+	price := me.pegPrice(ob, order)
+	if price == nil {
+		order.Status = models.OrderStatusCancelled
+		return ErrNoPegReference
+	}
+
+	order.Price = *price
+	order.Status = models.OrderStatusPending
+	me.restOrder(ob, order, report)
+	return nil
+}
+
+// repricePegs moves pegged orders to follow the top of book after every
+// command. An order whose price does not change keeps its queue position;
+// the others go to the back of their new level, oldest peg first, so pegs
+// that move together keep their relative priority. Pegs do not move during
+// auctions and halts.
+func (me *MatchingEngine) repricePegs(ob *OrderBook, report *ExecutionReport) {
+	if len(ob.pegged) == 0 || ob.auctionEnd != nil || ob.tradingStatus() == StatusHalted {
+		return
+	}
+
+	orders := make([]*models.Order, 0, len(ob.pegged))
+	for _, order := range ob.pegged {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreateTime.Equal(orders[j].CreateTime) {
+			return orders[i].CreateTime.Before(orders[j].CreateTime)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+
+	for _, order := range orders {
+		price := me.pegPrice(ob, order)
+		if price == nil || price.Equal(order.Price) {
+			continue
+		}
+
+		ob.removeOrder(order.ID)
+		order.Price = *price
+		order.UpdateTime = report.Time
+		ob.addOrder(order)
+		report.Makers = append(report.Makers, order)
+	}
+}
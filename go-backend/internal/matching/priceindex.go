@@ -0,0 +1,142 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+const priceIndexMaxLevel = 24
+
+// priceIndex is a skip list of price levels kept in matching priority order.
+// Bids are indexed highest price first and asks lowest price first, so the
+// best level is always the first node and depth is a bounded forward walk.
+type priceIndex struct {
+	head       *priceNode
+	level      int
+	length     int
+	descending bool
+	seed       uint64
+}
+
+type priceNode struct {
+	level *PriceLevel
+	next  []*priceNode
+}
+
+// newPriceIndex creates an empty index; descending is true for the bid side
+func newPriceIndex(descending bool) *priceIndex {
+	return &priceIndex{
+		head:       &priceNode{next: make([]*priceNode, priceIndexMaxLevel)},
+		level:      1,
+		descending: descending,
+		seed:       0x9E3779B97F4A7C15,
+	}
+}
+
+// before reports whether price a has priority over price b
+func (pi *priceIndex) before(a, b decimal.Decimal) bool {
+	if pi.descending {
+		return a.GreaterThan(b)
+	}
+	return a.LessThan(b)
+}
+
+// randomLevel picks a tower height using a fixed-seed xorshift generator so
+// the index shape is reproducible across runs
+func (pi *priceIndex) randomLevel() int {
+	level := 1
+	for level < priceIndexMaxLevel {
+		pi.seed ^= pi.seed << 13
+		pi.seed ^= pi.seed >> 7
+		pi.seed ^= pi.seed << 17
+		if pi.seed&3 != 0 {
+			break
+		}
+		level++
+	}
+	return level
+}
+
+// insert adds a price level; the caller guarantees the price is not indexed
+func (pi *priceIndex) insert(pl *PriceLevel) {
+	var update [priceIndexMaxLevel]*priceNode
+
+	node := pi.head
+	for i := pi.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && pi.before(node.next[i].level.Price, pl.Price) {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	level := pi.randomLevel()
+	if level > pi.level {
+		for i := pi.level; i < level; i++ {
+			update[i] = pi.head
+		}
+		pi.level = level
+	}
+
+	newNode := &priceNode{level: pl, next: make([]*priceNode, level)}
+	for i := 0; i < level; i++ {
+		newNode.next[i] = update[i].next[i]
+		update[i].next[i] = newNode
+	}
+	pi.length++
+}
+
+// remove deletes the level with the given price
+func (pi *priceIndex) remove(price decimal.Decimal) bool {
+	var update [priceIndexMaxLevel]*priceNode
+
+	node := pi.head
+	for i := pi.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && pi.before(node.next[i].level.Price, price) {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	target := node.next[0]
+	if target == nil || !target.level.Price.Equal(price) {
+		return false
+	}
+
+	for i := 0; i < pi.level; i++ {
+		if update[i].next[i] != target {
+			break
+		}
+		update[i].next[i] = target.next[i]
+	}
+
+	for pi.level > 1 && pi.head.next[pi.level-1] == nil {
+		pi.level--
+	}
+	pi.length--
+	return true
+}
+
+// first returns the best price level or nil when the side is empty
+func (pi *priceIndex) first() *PriceLevel {
+	if node := pi.head.next[0]; node != nil {
+		return node.level
+	}
+	return nil
+}
+
+// walk visits levels from best to worst until fn returns false
+func (pi *priceIndex) walk(fn func(pl *PriceLevel) bool) {
+	for node := pi.head.next[0]; node != nil; node = node.next[0] {
+		if !fn(node.level) {
+			return
+		}
+	}
+}
+
+// len returns the number of indexed price levels
+func (pi *priceIndex) len() int {
+	return pi.length
+}
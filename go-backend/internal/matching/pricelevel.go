@@ -17,7 +17,8 @@ type PriceLevel struct {
 	Price      decimal.Decimal
 	Volume     decimal.Decimal
 	OrderCount int
-	Orders     *list.List // list of *models.Order
+	Orders     *list.List               // list of *models.Order
+	elements   map[string]*list.Element // orderID -> queue element
 	mu         sync.RWMutex
 }
 
@@ -28,6 +29,7 @@ func NewPriceLevel(price decimal.Decimal) *PriceLevel {
 		Volume:     decimal.Zero,
 		OrderCount: 0,
 		Orders:     list.New(),
+		elements:   make(map[string]*list.Element),
 	}
 }
 
@@ -36,7 +38,7 @@ func (pl *PriceLevel) AddOrder(order *models.Order) {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
 
-	pl.Orders.PushBack(order)
+	pl.elements[order.ID] = pl.Orders.PushBack(order)
 	pl.Volume = pl.Volume.Add(order.Quantity.Sub(order.FilledQty))
 	pl.OrderCount++
 }
@@ -46,17 +48,18 @@ func (pl *PriceLevel) RemoveOrder(orderID string) bool {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
 
-	for e := pl.Orders.Front(); e != nil; e = e.Next() {
-		order := e.Value.(*models.Order)
-		if order.ID == orderID {
-			pl.Orders.Remove(e)
-			remainingQty := order.Quantity.Sub(order.FilledQty)
-			pl.Volume = pl.Volume.Sub(remainingQty)
-			pl.OrderCount--
-			return true
-		}
+	e, exists := pl.elements[orderID]
+	if !exists {
+		return false
 	}
-	return false
+
+	order := e.Value.(*models.Order)
+	pl.Orders.Remove(e)
+	delete(pl.elements, orderID)
+	remainingQty := order.Quantity.Sub(order.FilledQty)
+	pl.Volume = pl.Volume.Sub(remainingQty)
+	pl.OrderCount--
+	return true
 }
 
 // GetFirstOrder returns the first order in the queue
@@ -76,6 +79,13 @@ func (pl *PriceLevel) GetFirstOrder() *models.Order {
 	return front.Value.(*models.Order)
 }
 
+// SubVolume reduces the level volume by a filled quantity
+func (pl *PriceLevel) SubVolume(qty decimal.Decimal) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.Volume = pl.Volume.Sub(qty)
+}
+
 // UpdateVolume recalculates total volume
 func (pl *PriceLevel) UpdateVolume() {
 	pl.mu.Lock()
@@ -0,0 +1,87 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"errors"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// validateQuoteOrder checks the quote budget of a market buy, or of a
+// conditional buy that places one when triggered. The budget may be the only
+// size of the order, or cap the spending of one sized in base currency.
+func validateQuoteOrder(order *models.Order) error {
+	if !SpendsQuoteBudget(order) {
+		return errors.New("quote order quantity is only allowed for market buy order")
+	}
+	if !order.QuoteOrderQty.IsPositive() {
+		return errors.New("quote order quantity must be positive")
+	}
+	if order.Quantity.IsNegative() {
+		return errors.New("quantity must be positive")
+	}
+	if order.Quantity.IsZero() && order.TimeInForce == models.TimeInForceFOK {
+		return errors.New("order sized in quote currency cannot be FOK")
+	}
+	return nil
+}
+
+// SpendsQuoteBudget reports whether an order is a buy that executes as a
+// market order, now or once triggered, and so is bounded by a quote budget
+func SpendsQuoteBudget(order *models.Order) bool {
+	if order.Side != models.OrderSideBuy {
+		return false
+	}
+	return order.Type == models.OrderTypeMarket || (IsConditional(order) && !HasLimitPrice(order))
+}
+
+// affordable returns how much base currency budget buys at price, rounded
+// down to the step size so the trade never costs more than the budget
+func (me *MatchingEngine) affordable(ob *OrderBook, budget, price decimal.Decimal) decimal.Decimal {
+	if !budget.IsPositive() {
+		return decimal.Zero
+	}
+
+	quantity, _ := budget.QuoRem(price, int32(decimal.DivisionPrecision))
+	if f, exists := me.GetSymbolFilter(ob.Symbol); exists && f.StepSize.IsPositive() {
+		quantity = quantity.Div(f.StepSize).Floor().Mul(f.StepSize)
+	}
+	return quantity
+}
+
+// capToBudget lowers the quantity of a market buy to what is left of its
+// quote budget buys at price. Prices only rise along the walk, so the cap
+// never grows back. It returns false when the budget buys nothing more.
+func (me *MatchingEngine) capToBudget(ob *OrderBook, order *models.Order, price decimal.Decimal) bool {
+	budget := order.QuoteOrderQty.Sub(order.FilledAmount)
+	limit := order.FilledQty.Add(me.affordable(ob, budget, price))
+	if limit.LessThan(order.Quantity) {
+		order.Quantity = limit
+	}
+	return order.FilledQty.LessThan(order.Quantity)
+}
+
+// MarketBuyCost returns what buying quantity with a market order costs
+// against the current asks, or what the asks cost when they cannot fill it
+func (me *MatchingEngine) MarketBuyCost(symbol string, quantity decimal.Decimal) decimal.Decimal {
+	ob, exists := me.GetOrderBook(symbol)
+	if !exists {
+		return decimal.Zero
+	}
+
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	cost, want := decimal.Zero, quantity
+	ob.asks.walk(func(level *PriceLevel) bool {
+		take := decimal.Min(want, level.Volume)
+		cost = cost.Add(take.Mul(level.Price))
+		want = want.Sub(take)
+		return want.IsPositive()
+	})
+	return cost
+}
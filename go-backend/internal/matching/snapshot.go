@@ -0,0 +1,373 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	snapshotFilePrefix = "snapshot-"
+	snapshotFileSuffix = ".json"
+	snapshotsToKeep    = 3
+)
+
+// EngineSnapshot is a point-in-time image of every order book
+type EngineSnapshot struct {
+	Seq   uint64               `json:"seq"` // engine sequence when the snapshot started
+	Time  time.Time            `json:"time"`
+	Books []*OrderBookSnapshot `json:"books"`
+}
+
+// OrderBookSnapshot captures one book with its queue order and the sequence
+// number of the last command applied to it
+type OrderBookSnapshot struct {
+	Symbol  string          `json:"symbol"`
+	LastSeq uint64          `json:"last_seq"`
+	Bids    []LevelSnapshot `json:"bids"` // best price first
+	Asks    []LevelSnapshot `json:"asks"` // best price first
+	// AuctionEnd is set while the book is in a call auction
+	AuctionEnd *time.Time    `json:"auction_end,omitempty"`
+	Status     TradingStatus `json:"status,omitempty"`
+	ReopenAt   *time.Time    `json:"reopen_at,omitempty"` // end of a circuit breaker halt
+	// Triggers holds the conditional orders, oldest first, and TriggerPrices
+	// the last price of each source they watch
+	Triggers      []*models.Order                             `json:"triggers,omitempty"`
+	TriggerPrices map[models.TriggerPriceType]decimal.Decimal `json:"trigger_prices,omitempty"`
+	// UpdateID is the last depth update ID, so the feed continues after a restart
+	UpdateID uint64 `json:"update_id,omitempty"`
+	// L3Seq is the sequence of the last L3 update, linking the next one to it
+	L3Seq uint64 `json:"l3_seq,omitempty"`
+}
+
+// LevelSnapshot holds the resting orders of one price level in FIFO order
+type LevelSnapshot struct {
+	Price  decimal.Decimal `json:"price"`
+	Orders []*models.Order `json:"orders"`
+}
+
+// RecoveryResult describes how the engine state was rebuilt on startup
+type RecoveryResult struct {
+	SnapshotSeq    uint64 // zero when no snapshot was found
+	ReplayedTrades int
+	RestoredOrders int
+	LastSeq        uint64
+}
+
+// Snapshot captures every order book. Each book is copied under its read
+// lock, so it reflects a whole number of applied commands.
+func (me *MatchingEngine) Snapshot() *EngineSnapshot {
+	snapshot := &EngineSnapshot{
+		Seq:  me.LastSeq(),
+		Time: time.Now(),
+	}
+
+	me.mu.RLock()
+	books := make([]*OrderBook, 0, len(me.orderBooks))
+	for _, ob := range me.orderBooks {
+		books = append(books, ob)
+	}
+	me.mu.RUnlock()
+
+	sort.Slice(books, func(i, j int) bool { return books[i].Symbol < books[j].Symbol })
+	for _, ob := range books {
+		snapshot.Books = append(snapshot.Books, ob.snapshot())
+	}
+
+	return snapshot
+}
+
+// snapshot copies the book's levels and orders
+func (ob *OrderBook) snapshot() *OrderBookSnapshot {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return &OrderBookSnapshot{
+		Symbol:     ob.Symbol,
+		LastSeq:    ob.lastSeq,
+		Bids:       snapshotLevels(ob.bids),
+		Asks:       snapshotLevels(ob.asks),
+		AuctionEnd: ob.auctionEnd,
+		Status:     ob.status,
+		ReopenAt:   ob.reopenAt,
+
+		Triggers:      snapshotTriggers(ob.triggers),
+		TriggerPrices: ob.triggers.prices(),
+		UpdateID:      ob.updateID,
+		L3Seq:         ob.l3Seq,
+	}
+}
+
+// snapshotTriggers copies the conditional orders oldest first
+func snapshotTriggers(tb *triggerBook) []*models.Order {
+	orders := make([]*models.Order, 0, len(tb.orders))
+	for _, order := range tb.orders {
+		orders = append(orders, snapshotOrder(order))
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreateTime.Equal(orders[j].CreateTime) {
+			return orders[i].CreateTime.Before(orders[j].CreateTime)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	return orders
+}
+
+func snapshotLevels(index *priceIndex) []LevelSnapshot {
+	levels := make([]LevelSnapshot, 0, index.len())
+	index.walk(func(level *PriceLevel) bool {
+		ls := LevelSnapshot{Price: level.Price, Orders: make([]*models.Order, 0, level.Orders.Len())}
+		for e := level.Orders.Front(); e != nil; e = e.Next() {
+			ls.Orders = append(ls.Orders, snapshotOrder(e.Value.(*models.Order)))
+		}
+		levels = append(levels, ls)
+		return true
+	})
+	return levels
+}
+
+// Restore loads a snapshot into an engine that has not processed any input
+func (me *MatchingEngine) Restore(snapshot *EngineSnapshot) (int, error) {
+	if me.LastSeq() != 0 {
+		return 0, errors.New("snapshot can only be restored into an empty engine")
+	}
+
+	restored := 0
+	for _, bs := range snapshot.Books {
+		ob := me.GetOrCreateOrderBook(bs.Symbol)
+
+		ob.mu.Lock()
+		for _, levels := range [][]LevelSnapshot{bs.Bids, bs.Asks} {
+			for _, level := range levels {
+				for _, order := range level.Orders {
+					ob.addOrder(order)
+					if order.ExpireTime != nil {
+						me.expiry.schedule(*order.ExpireTime, ob.Symbol, order.ID)
+					}
+					restored++
+				}
+			}
+		}
+		for source, price := range bs.TriggerPrices {
+			price := price
+			ob.triggers.feed(source).price = &price
+		}
+		for _, order := range bs.Triggers {
+			ob.triggers.add(order)
+			if order.ExpireTime != nil {
+				me.expiry.schedule(*order.ExpireTime, ob.Symbol, order.ID)
+			}
+			restored++
+		}
+		if bs.AuctionEnd != nil {
+			ob.auctionEnd = bs.AuctionEnd
+			me.expiry.scheduleCommand(*bs.AuctionEnd, CommandUncross, ob.Symbol, "")
+		}
+		ob.status = bs.Status
+		if bs.ReopenAt != nil {
+			ob.reopenAt = bs.ReopenAt
+			me.expiry.scheduleCommand(*bs.ReopenAt, CommandReopen, ob.Symbol, "")
+		}
+		ob.lastSeq = bs.LastSeq
+		ob.updateID = bs.UpdateID
+		ob.changed = make(map[levelKey]bool)
+		ob.l3Seq = bs.L3Seq
+		ob.events = nil
+		ob.mu.Unlock()
+	}
+
+	me.seqMu.Lock()
+	if snapshot.Seq > me.seq {
+		me.seq = snapshot.Seq
+	}
+	me.seqMu.Unlock()
+
+	return restored, nil
+}
+
+// WriteSnapshot writes a snapshot file into dir and prunes old ones
+func (me *MatchingEngine) WriteSnapshot(dir string) (string, error) {
+	snapshot := me.Snapshot()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s%020d%s", snapshotFilePrefix, snapshot.Seq, snapshotFileSuffix))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+
+	files, err := listSnapshots(dir)
+	if err != nil {
+		return path, err
+	}
+	for i := 0; i < len(files)-snapshotsToKeep; i++ {
+		os.Remove(files[i])
+	}
+
+	return path, nil
+}
+
+// LoadLatestSnapshot reads the newest snapshot in dir; it returns nil when
+// the directory holds no snapshot
+func LoadLatestSnapshot(dir string) (*EngineSnapshot, error) {
+	files, err := listSnapshots(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(files[len(files)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot EngineSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot %s: %w", files[len(files)-1], err)
+	}
+
+	return &snapshot, nil
+}
+
+// listSnapshots returns snapshot files in dir, oldest first
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, snapshotFilePrefix) && strings.HasSuffix(name, snapshotFileSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+
+	// Zero-padded sequence numbers sort lexically
+	sort.Strings(files)
+	return files, nil
+}
+
+// Recover restores the latest snapshot in dir, if any, replays the journal
+// entries that were applied after it, and then journals new inputs to it.
+// Engine-initiated commands such as GTD expiries wait until it returns.
+func (me *MatchingEngine) Recover(dir string, journal Journal) (*RecoveryResult, error) {
+	me.replayGate.Lock()
+	defer me.replayGate.Unlock()
+
+	result := &RecoveryResult{}
+
+	snapshot, err := LoadLatestSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var afterSeq uint64
+	if snapshot != nil {
+		restored, err := me.Restore(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		result.SnapshotSeq = snapshot.Seq
+		result.RestoredOrders = restored
+
+		// Books apply inputs independently, so resume from the least
+		// advanced one; entries a book already holds are skipped
+		afterSeq = snapshot.Seq
+		for _, bs := range snapshot.Books {
+			if bs.LastSeq < afterSeq {
+				afterSeq = bs.LastSeq
+			}
+		}
+	}
+
+	if journal != nil {
+		trades, err := me.replay(journal, afterSeq)
+		if err != nil {
+			return nil, err
+		}
+		result.ReplayedTrades = len(trades)
+		me.SetJournal(journal)
+	}
+
+	result.LastSeq = me.LastSeq()
+	return result, nil
+}
+
+// StartSnapshots writes a snapshot into dir every interval until the engine stops
+func (me *MatchingEngine) StartSnapshots(dir string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var lastSeq uint64
+		for {
+			select {
+			case <-ticker.C:
+				seq := me.LastSeq()
+				if seq == lastSeq {
+					continue
+				}
+				lastSeq = seq
+				if _, err := me.WriteSnapshot(dir); err != nil {
+					log.Printf("Failed to write order book snapshot: %v", err)
+				}
+			case <-me.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// OpenOrders returns snapshots of every resting and conditional order across all books
+func (me *MatchingEngine) OpenOrders() []*models.Order {
+	me.mu.RLock()
+	books := make([]*OrderBook, 0, len(me.orderBooks))
+	for _, ob := range me.orderBooks {
+		books = append(books, ob)
+	}
+	me.mu.RUnlock()
+
+	var orders []*models.Order
+	for _, ob := range books {
+		ob.mu.RLock()
+		for _, open := range []map[string]*models.Order{ob.OrderMap, ob.triggers.orders} {
+			for _, order := range open {
+				orders = append(orders, snapshotOrder(order))
+			}
+		}
+		ob.mu.RUnlock()
+	}
+
+	return orders
+}
@@ -0,0 +1,250 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// TradingStatus controls which commands a symbol accepts
+type TradingStatus string
+
+const (
+	StatusTrading    TradingStatus = "trading"     // continuous trading
+	StatusHalted     TradingStatus = "halted"      // nothing but cancels, expiries and trigger prices
+	StatusCancelOnly TradingStatus = "cancel_only" // cancels only
+	StatusPostOnly   TradingStatus = "post_only"   // limit orders that do not cross, and cancels
+	StatusAuction    TradingStatus = "auction"     // call auction, see StartAuction
+)
+
+var (
+	// ErrSymbolHalted is returned for commands on a halted symbol
+	ErrSymbolHalted = errors.New("trading is halted")
+	// ErrCancelOnly is returned for new orders and amendments in cancel-only mode
+	ErrCancelOnly = errors.New("symbol is in cancel-only mode")
+	// ErrPostOnlyStatus is returned for orders other than limit orders in post-only mode
+	ErrPostOnlyStatus = errors.New("only limit orders are accepted in post-only mode")
+)
+
+// CircuitBreaker halts a symbol when its price moves more than Threshold
+// (a fraction) within Window, and reopens it through a call auction of
+// AuctionDuration after HaltDuration
+type CircuitBreaker struct {
+	Threshold       decimal.Decimal
+	Window          time.Duration
+	HaltDuration    time.Duration
+	AuctionDuration time.Duration
+}
+
+// pricePoint is a trade price at an engine time
+type pricePoint struct {
+	at    time.Time
+	price decimal.Decimal
+}
+
+// priceWindow tracks the lowest and highest trade price of a sliding time
+// window with monotonic queues, so each trade costs amortized O(1)
+type priceWindow struct {
+	mins []pricePoint // increasing prices
+	maxs []pricePoint // decreasing prices
+}
+
+// add records a trade price
+func (w *priceWindow) add(p pricePoint) {
+	for len(w.mins) > 0 && w.mins[len(w.mins)-1].price.GreaterThanOrEqual(p.price) {
+		w.mins = w.mins[:len(w.mins)-1]
+	}
+	w.mins = append(w.mins, p)
+
+	for len(w.maxs) > 0 && w.maxs[len(w.maxs)-1].price.LessThanOrEqual(p.price) {
+		w.maxs = w.maxs[:len(w.maxs)-1]
+	}
+	w.maxs = append(w.maxs, p)
+}
+
+// prune drops prices recorded before since
+func (w *priceWindow) prune(since time.Time) {
+	for len(w.mins) > 0 && w.mins[0].at.Before(since) {
+		w.mins = w.mins[1:]
+	}
+	for len(w.maxs) > 0 && w.maxs[0].at.Before(since) {
+		w.maxs = w.maxs[1:]
+	}
+}
+
+// reset forgets every price
+func (w *priceWindow) reset() {
+	w.mins, w.maxs = nil, nil
+}
+
+// SetCircuitBreaker enables volatility halts; nil disables them. It must be
+// called before any order is processed so replays stay consistent.
+func (me *MatchingEngine) SetCircuitBreaker(breaker *CircuitBreaker) {
+	me.breaker = breaker
+}
+
+// SetTradingStatus changes a symbol's status. Auctions are started with
+// StartAuction instead, and a symbol in auction keeps its status until it
+// uncrosses.
+func (me *MatchingEngine) SetTradingStatus(symbol string, status TradingStatus) error {
+	switch status {
+	case StatusTrading, StatusHalted, StatusCancelOnly, StatusPostOnly:
+	default:
+		return fmt.Errorf("invalid trading status %q", status)
+	}
+
+	report, err := me.submit(symbol, &command{
+		kind:   CommandSetStatus,
+		status: status,
+	})
+	if err != nil {
+		return err
+	}
+	return report.Err
+}
+
+// GetTradingStatus returns a symbol's current status
+func (me *MatchingEngine) GetTradingStatus(symbol string) TradingStatus {
+	ob, exists := me.GetOrderBook(symbol)
+	if !exists {
+		return StatusTrading
+	}
+
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.tradingStatus()
+}
+
+// tradingStatus returns the book's status; the caller must hold the lock
+func (ob *OrderBook) tradingStatus() TradingStatus {
+	if ob.auctionEnd != nil {
+		return StatusAuction
+	}
+	if ob.status == "" {
+		return StatusTrading
+	}
+	return ob.status
+}
+
+// checkStatus rejects commands the book's status does not allow. Cancels
+// are always allowed so users can pull their orders during a halt.
+func checkStatus(ob *OrderBook, kind CommandType) error {
+	switch ob.tradingStatus() {
+	case StatusHalted:
+		switch kind {
+		case CommandCancelOrder, CommandMassCancel, CommandExpireOrder, CommandSetStatus, CommandStartAuction, CommandReopen, CommandTriggerPrice:
+			return nil
+		}
+		return ErrSymbolHalted
+	case StatusCancelOnly:
+		if kind == CommandNewOrder || kind == CommandAmendOrder {
+			return ErrCancelOnly
+		}
+	}
+	return nil
+}
+
+// applySetStatus changes the book's status
+func (me *MatchingEngine) applySetStatus(ob *OrderBook, status TradingStatus, report *ExecutionReport) {
+	if ob.auctionEnd != nil {
+		report.Err = errors.New("symbol is in auction")
+		return
+	}
+
+	ob.status = status
+	ob.reopenAt = nil
+	ob.prices.reset()
+}
+
+// applyPostOnlyStatus makes a new order post-only while the book is in
+// post-only mode
+func applyPostOnlyStatus(ob *OrderBook, order *models.Order) error {
+	if ob.tradingStatus() != StatusPostOnly {
+		return nil
+	}
+	if order.Type != models.OrderTypeLimit {
+		return ErrPostOnlyStatus
+	}
+	if order.PostOnly == "" {
+		order.PostOnly = models.PostOnlyReject
+	}
+	return nil
+}
+
+// breakerTrips reports whether trading at price would move the book more
+// than the circuit breaker allows within its window
+func (me *MatchingEngine) breakerTrips(ob *OrderBook, price decimal.Decimal, now time.Time) bool {
+	if me.breaker == nil || ob.tradingStatus() != StatusTrading {
+		return false
+	}
+
+	ob.prices.prune(now.Add(-me.breaker.Window))
+	if len(ob.prices.mins) == 0 {
+		return false
+	}
+
+	low, high := ob.prices.mins[0].price, ob.prices.maxs[0].price
+	one := decimal.NewFromInt(1)
+	return price.GreaterThan(low.Mul(one.Add(me.breaker.Threshold))) ||
+		price.LessThan(high.Mul(one.Sub(me.breaker.Threshold)))
+}
+
+// breakerLimit returns the worst price an order on side can trade at before
+// the circuit breaker trips, or nil when no bound applies
+func (me *MatchingEngine) breakerLimit(ob *OrderBook, side models.OrderSide, now time.Time) *decimal.Decimal {
+	if me.breaker == nil || ob.tradingStatus() != StatusTrading {
+		return nil
+	}
+
+	ob.prices.prune(now.Add(-me.breaker.Window))
+	if len(ob.prices.mins) == 0 {
+		return nil
+	}
+
+	// A buy sweep only raises prices, so the window low fixes its bound
+	one := decimal.NewFromInt(1)
+	bound := ob.prices.maxs[0].price.Mul(one.Sub(me.breaker.Threshold))
+	if side == models.OrderSideBuy {
+		bound = ob.prices.mins[0].price.Mul(one.Add(me.breaker.Threshold))
+	}
+	return &bound
+}
+
+// recordPrice feeds a trade price to the circuit breaker
+func (me *MatchingEngine) recordPrice(ob *OrderBook, price decimal.Decimal, now time.Time) {
+	if me.breaker == nil {
+		return
+	}
+	ob.prices.add(pricePoint{at: now, price: price})
+}
+
+// haltForBreaker halts the book and schedules its reopening auction
+func (me *MatchingEngine) haltForBreaker(ob *OrderBook, report *ExecutionReport) {
+	reopenAt := report.Time.Add(me.breaker.HaltDuration)
+	ob.status = StatusHalted
+	ob.reopenAt = &reopenAt
+	ob.prices.reset()
+	report.Halted = true
+	me.expiry.scheduleCommand(reopenAt, CommandReopen, ob.Symbol, "")
+}
+
+// applyReopen ends a circuit breaker halt with a call auction
+func (me *MatchingEngine) applyReopen(ob *OrderBook, report *ExecutionReport) {
+	if ob.reopenAt == nil || ob.reopenAt.After(report.Time) {
+		report.Err = errors.New("symbol is not halted by the circuit breaker")
+		return
+	}
+
+	var auction time.Duration
+	if me.breaker != nil {
+		auction = me.breaker.AuctionDuration
+	}
+	me.applyStartAuction(ob, report.Time.Add(auction), report)
+}
@@ -0,0 +1,457 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// IsConditional reports whether an order waits in the trigger book until
+// its trigger price is reached. Triggered orders become limit or market orders.
+func IsConditional(order *models.Order) bool {
+	switch order.Type {
+	case models.OrderTypeStopLoss, models.OrderTypeTakeProfit, models.OrderTypeStopLimit, models.OrderTypeTrailingStop:
+		return true
+	}
+	return false
+}
+
+// UpdateTriggerPrice feeds a mark or index price to a symbol's trigger book.
+// Orders it fires execute within the same journaled command, and the report
+// is published on the report channel.
+func (me *MatchingEngine) UpdateTriggerPrice(symbol string, source models.TriggerPriceType, price decimal.Decimal) error {
+	if source != models.TriggerPriceMark && source != models.TriggerPriceIndex {
+		return fmt.Errorf("invalid trigger price source %q", source)
+	}
+	if !price.IsPositive() {
+		return errors.New("trigger price must be positive")
+	}
+
+	// Hold off while a replay is rebuilding the books
+	me.replayGate.RLock()
+	defer me.replayGate.RUnlock()
+
+	me.submitAsync(symbol, &command{
+		kind: CommandTriggerPrice,
+		order: &models.Order{
+			Symbol:           symbol,
+			Price:            price,
+			TriggerPriceType: source,
+		},
+	})
+	return nil
+}
+
+// validateConditional checks the trigger fields of a conditional order
+func validateConditional(order *models.Order) error {
+	switch order.TriggerPriceType {
+	case "", models.TriggerPriceLast, models.TriggerPriceMark, models.TriggerPriceIndex:
+	default:
+		return errors.New("invalid trigger price type")
+	}
+
+	switch order.TriggerCondition {
+	case "", ">=", "<=":
+	default:
+		return errors.New("invalid trigger condition")
+	}
+
+	switch order.Type {
+	case models.OrderTypeTakeProfit:
+		if order.TakeProfitPrice == nil || !order.TakeProfitPrice.IsPositive() {
+			return errors.New("take-profit price must be positive")
+		}
+	case models.OrderTypeTrailingStop:
+		// Without a stop price the order starts trailing from the next price
+		if (order.TrailingDelta == nil) == (order.TrailingPercent == nil) {
+			return errors.New("trailing stop needs either a trailing delta or a trailing percentage")
+		}
+		if order.TrailingDelta != nil && !order.TrailingDelta.IsPositive() {
+			return errors.New("trailing delta must be positive")
+		}
+		if order.TrailingPercent != nil &&
+			(!order.TrailingPercent.IsPositive() || order.TrailingPercent.GreaterThanOrEqual(decimal.NewFromInt(100))) {
+			return errors.New("trailing percentage must be between 0 and 100")
+		}
+		if order.StopPrice != nil && !order.StopPrice.IsPositive() {
+			return errors.New("stop price must be positive")
+		}
+	default:
+		if order.StopPrice == nil || !order.StopPrice.IsPositive() {
+			return errors.New("stop price must be positive")
+		}
+	}
+
+	if order.Type == models.OrderTypeStopLimit && !order.Price.IsPositive() {
+		return errors.New("price must be positive for stop-limit order")
+	}
+	return nil
+}
+
+// HasLimitPrice reports whether an order trades at its own limit price,
+// now or once it is triggered
+func HasLimitPrice(order *models.Order) bool {
+	return order.Type == models.OrderTypeLimit || order.Type == models.OrderTypeStopLimit
+}
+
+// triggerPrice returns the price an order triggers at, or nil while a
+// trailing stop has not seen a price yet
+func triggerPrice(order *models.Order) *decimal.Decimal {
+	if order.Type == models.OrderTypeTakeProfit {
+		return order.TakeProfitPrice
+	}
+	return order.StopPrice
+}
+
+// triggerSource returns the price source an order watches
+func triggerSource(order *models.Order) models.TriggerPriceType {
+	if order.TriggerPriceType == "" {
+		return models.TriggerPriceLast
+	}
+	return order.TriggerPriceType
+}
+
+// firesOnRise reports whether an order triggers when the price rises to its
+// trigger price rather than when it falls to it. Stops trigger against the
+// order's side and take-profits with it, unless the condition says otherwise.
+func firesOnRise(order *models.Order) bool {
+	switch order.TriggerCondition {
+	case ">=":
+		return true
+	case "<=":
+		return false
+	}
+
+	rise := order.Side == models.OrderSideBuy
+	if order.Type == models.OrderTypeTakeProfit {
+		rise = !rise
+	}
+	return rise
+}
+
+// triggerCondition returns the condition an order triggers on
+func triggerCondition(order *models.Order) string {
+	if firesOnRise(order) {
+		return ">="
+	}
+	return "<="
+}
+
+// reached reports whether price satisfies an order's trigger condition
+func reached(order *models.Order, price decimal.Decimal) bool {
+	trigger := triggerPrice(order)
+	if trigger == nil {
+		return false
+	}
+	if firesOnRise(order) {
+		return price.GreaterThanOrEqual(*trigger)
+	}
+	return price.LessThanOrEqual(*trigger)
+}
+
+// trailDistance returns how far a trailing stop stays from price
+func trailDistance(order *models.Order, price decimal.Decimal) decimal.Decimal {
+	if order.TrailingPercent != nil {
+		return price.Mul(*order.TrailingPercent).Div(decimal.NewFromInt(100))
+	}
+	return *order.TrailingDelta
+}
+
+// trail moves a trailing stop's trigger price to follow the market; it
+// never moves away from the market
+func trail(order *models.Order, price decimal.Decimal) {
+	var stop decimal.Decimal
+	if firesOnRise(order) {
+		stop = price.Add(trailDistance(order, price))
+		if order.StopPrice != nil && !stop.LessThan(*order.StopPrice) {
+			return
+		}
+	} else {
+		stop = price.Sub(trailDistance(order, price))
+		if order.StopPrice != nil && !stop.GreaterThan(*order.StopPrice) {
+			return
+		}
+	}
+
+	// Reports share the pointer, so it is replaced rather than written through
+	order.StopPrice = &stop
+}
+
+// triggerOrder turns a fired conditional order into the order it places:
+// stop-limit orders become limit orders and the others market orders
+func triggerOrder(order *models.Order, now time.Time) {
+	if order.Type == models.OrderTypeStopLimit {
+		order.Type = models.OrderTypeLimit
+	} else {
+		order.Type = models.OrderTypeMarket
+	}
+	order.IsTriggered = true
+	order.TriggerTime = &now
+	order.UpdateTime = now
+}
+
+// triggerSide indexes the fixed trigger prices of one source that fire on a
+// rising or on a falling price, nearest to firing first
+type triggerSide struct {
+	rising bool
+	levels map[string]*PriceLevel
+	index  *priceIndex
+}
+
+func newTriggerSide(rising bool) *triggerSide {
+	return &triggerSide{
+		rising: rising,
+		levels: make(map[string]*PriceLevel),
+		index:  newPriceIndex(!rising),
+	}
+}
+
+// add indexes an order at its trigger price
+func (s *triggerSide) add(order *models.Order) {
+	price := *triggerPrice(order)
+	level, exists := s.levels[price.String()]
+	if !exists {
+		level = NewPriceLevel(price)
+		s.levels[price.String()] = level
+		s.index.insert(level)
+	}
+	level.AddOrder(order)
+}
+
+// remove drops an order from its trigger price
+func (s *triggerSide) remove(order *models.Order) {
+	price := *triggerPrice(order)
+	level, exists := s.levels[price.String()]
+	if !exists || !level.RemoveOrder(order.ID) {
+		return
+	}
+	if level.IsEmpty() {
+		delete(s.levels, price.String())
+		s.index.remove(price)
+	}
+}
+
+// popReached removes and returns the orders whose trigger price is reached at price
+func (s *triggerSide) popReached(price decimal.Decimal) []*models.Order {
+	var orders []*models.Order
+	for {
+		level := s.index.first()
+		if level == nil || (s.rising && level.Price.GreaterThan(price)) || (!s.rising && level.Price.LessThan(price)) {
+			return orders
+		}
+		for e := level.Orders.Front(); e != nil; e = e.Next() {
+			orders = append(orders, e.Value.(*models.Order))
+		}
+		delete(s.levels, level.Price.String())
+		s.index.remove(level.Price)
+	}
+}
+
+// triggerFeed holds the conditional orders watching one price source
+type triggerFeed struct {
+	price    *decimal.Decimal // last price of the source, nil before the first
+	rising   *triggerSide
+	falling  *triggerSide
+	trailing map[string]*models.Order // trailing stops move with every price
+}
+
+// triggerBook holds a symbol's conditional orders until their trigger price
+// is reached. It is owned by the book's worker like the rest of the book.
+type triggerBook struct {
+	orders map[string]*models.Order
+	feeds  map[models.TriggerPriceType]*triggerFeed
+	fired  []*models.Order // removed from the book, waiting to be executed
+}
+
+func newTriggerBook() *triggerBook {
+	return &triggerBook{
+		orders: make(map[string]*models.Order),
+		feeds:  make(map[models.TriggerPriceType]*triggerFeed),
+	}
+}
+
+// feed returns the orders and last price of a source
+func (tb *triggerBook) feed(source models.TriggerPriceType) *triggerFeed {
+	feed, exists := tb.feeds[source]
+	if !exists {
+		feed = &triggerFeed{
+			rising:   newTriggerSide(true),
+			falling:  newTriggerSide(false),
+			trailing: make(map[string]*models.Order),
+		}
+		tb.feeds[source] = feed
+	}
+	return feed
+}
+
+// ready reports whether an arriving order's trigger price has already been
+// reached by the last price of its source. Trailing stops start trailing
+// from that price.
+func (tb *triggerBook) ready(order *models.Order) bool {
+	feed := tb.feed(triggerSource(order))
+	if feed.price == nil {
+		return false
+	}
+	if order.Type == models.OrderTypeTrailingStop {
+		trail(order, *feed.price)
+	}
+	return reached(order, *feed.price)
+}
+
+// add parks an order until its trigger price is reached
+func (tb *triggerBook) add(order *models.Order) {
+	tb.orders[order.ID] = order
+	feed := tb.feed(triggerSource(order))
+	switch {
+	case order.Type == models.OrderTypeTrailingStop:
+		feed.trailing[order.ID] = order
+	case firesOnRise(order):
+		feed.rising.add(order)
+	default:
+		feed.falling.add(order)
+	}
+}
+
+// remove takes an order out of the trigger book
+func (tb *triggerBook) remove(orderID string) (*models.Order, bool) {
+	order, exists := tb.orders[orderID]
+	if !exists {
+		return nil, false
+	}
+	delete(tb.orders, orderID)
+
+	feed := tb.feed(triggerSource(order))
+	switch {
+	case order.Type == models.OrderTypeTrailingStop:
+		delete(feed.trailing, orderID)
+	case firesOnRise(order):
+		feed.rising.remove(order)
+	default:
+		feed.falling.remove(order)
+	}
+	return order, true
+}
+
+// onPrice records a price of a source, moves trailing stops and fires the
+// orders whose trigger price it reaches
+func (tb *triggerBook) onPrice(source models.TriggerPriceType, price decimal.Decimal) {
+	feed := tb.feed(source)
+	feed.price = &price
+
+	for id, order := range feed.trailing {
+		trail(order, price)
+		if reached(order, price) {
+			delete(feed.trailing, id)
+			tb.fire(order)
+		}
+	}
+	for _, order := range feed.rising.popReached(price) {
+		tb.fire(order)
+	}
+	for _, order := range feed.falling.popReached(price) {
+		tb.fire(order)
+	}
+}
+
+// fire moves an order whose trigger price was reached to the fired list
+func (tb *triggerBook) fire(order *models.Order) {
+	delete(tb.orders, order.ID)
+	tb.fired = append(tb.fired, order)
+}
+
+// takeFired returns the fired orders oldest first, so a replay executes
+// them in the same order
+func (tb *triggerBook) takeFired() []*models.Order {
+	fired := tb.fired
+	tb.fired = nil
+	sort.Slice(fired, func(i, j int) bool {
+		if !fired[i].CreateTime.Equal(fired[j].CreateTime) {
+			return fired[i].CreateTime.Before(fired[j].CreateTime)
+		}
+		return fired[i].ID < fired[j].ID
+	})
+	return fired
+}
+
+// prices returns the last price of every source
+func (tb *triggerBook) prices() map[models.TriggerPriceType]decimal.Decimal {
+	prices := make(map[models.TriggerPriceType]decimal.Decimal)
+	for source, feed := range tb.feeds {
+		if feed.price != nil {
+			prices[source] = *feed.price
+		}
+	}
+	return prices
+}
+
+// acceptsTriggered reports whether fired orders can be executed; otherwise
+// they wait for the next price
+func acceptsTriggered(ob *OrderBook) bool {
+	status := ob.tradingStatus()
+	return status == StatusTrading || status == StatusPostOnly
+}
+
+// applyConditional parks a conditional order in the trigger book, or places
+// it at once when its trigger price has already been reached
+func (me *MatchingEngine) applyConditional(ob *OrderBook, order *models.Order, report *ExecutionReport) error {
+	order.Status = models.OrderStatusPending
+	order.TriggerCondition = triggerCondition(order)
+
+	if ob.triggers.ready(order) && acceptsTriggered(ob) {
+		triggerOrder(order, report.Time)
+		return me.executeOrder(ob, order, report)
+	}
+
+	if order.TimeInForce == models.TimeInForceGTD {
+		if !order.ExpireTime.After(report.Time) {
+			order.Status = models.OrderStatusCancelled
+			order.CancelReason = models.CancelReasonExpired
+			return nil
+		}
+		me.expiry.schedule(*order.ExpireTime, ob.Symbol, order.ID)
+	}
+	ob.triggers.add(order)
+	return nil
+}
+
+// applyTriggerPrice records a mark or index price; the orders it fires are
+// executed with the command's other triggers
+func (me *MatchingEngine) applyTriggerPrice(ob *OrderBook, update *models.Order) {
+	ob.triggers.onPrice(update.TriggerPriceType, update.Price)
+}
+
+// runTriggers feeds every trade price of the command to the trigger book in
+// order and places the orders that fire, whose own trades are fed in turn.
+// Orders fired while the book does not accept orders wait for the next price.
+func (me *MatchingEngine) runTriggers(ob *OrderBook, report *ExecutionReport) {
+	evaluated := 0
+	for {
+		for ; evaluated < len(report.Trades); evaluated++ {
+			ob.triggers.onPrice(models.TriggerPriceLast, report.Trades[evaluated].Price)
+		}
+
+		fired := ob.triggers.takeFired()
+		if len(fired) == 0 {
+			return
+		}
+		for _, order := range fired {
+			if !acceptsTriggered(ob) {
+				ob.triggers.add(order)
+				continue
+			}
+			triggerOrder(order, report.Time)
+			report.Triggered = append(report.Triggered, order)
+
+			// A rejected order is cancelled; the command itself succeeded
+			me.executeOrder(ob, order, report)
+		}
+	}
+}
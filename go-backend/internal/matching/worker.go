@@ -0,0 +1,154 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package matching
+
+import (
+	"time"
+
+	"github.com/easitradecoins/backend/internal/models"
+)
+
+// CommandType identifies the kind of input fed to a symbol worker
+type CommandType string
+
+const (
+	CommandNewOrder    CommandType = "new"
+	CommandCancelOrder CommandType = "cancel"
+	CommandExpireOrder CommandType = "expire"
+	CommandAmendOrder  CommandType = "amend"
+	CommandMassCancel  CommandType = "mass_cancel"
+
+	CommandStartAuction CommandType = "auction_start"
+	CommandUncross      CommandType = "auction_uncross"
+	CommandSetStatus    CommandType = "status"
+	CommandReopen       CommandType = "reopen"
+
+	CommandTriggerPrice CommandType = "trigger_price"
+)
+
+// command is a single engine input. Commands for one symbol are applied
+// strictly in queue order by that symbol's worker goroutine.
+type command struct {
+	kind    CommandType
+	order   *models.Order         // engine-owned copy for new orders, target of amends and mass cancels, trigger prices
+	orderID string                // target order for cancels
+	until   time.Time             // scheduled end of an auction
+	status  TradingStatus         // new status of a status change
+	seq     uint64                // assigned when the command is journaled
+	time    time.Time             // engine time used for every change the command makes
+	replay  bool                  // re-applied from the journal
+	reply   chan *ExecutionReport // nil for engine-initiated commands
+}
+
+// ExecutionReport is the outcome of one engine command. All orders in the
+// report are snapshots and are never mutated by the engine afterwards.
+type ExecutionReport struct {
+	Symbol string
+	Seq    uint64          // journal sequence number of the command
+	Time   time.Time       // engine time of the command
+	Order  *models.Order   // the new or cancelled order after the command
+	Trades []*models.Trade // trades generated by the command
+	Makers []*models.Order // resting orders whose state changed
+	// Cancelled holds the orders removed by a mass cancel
+	Cancelled []*models.Order
+	// Triggered holds conditional orders that fired and were placed
+	Triggered []*models.Order
+	// Auction holds the outcome of an auction uncross
+	Auction *AuctionInfo
+	// Halted is set when the command tripped the circuit breaker
+	Halted bool
+	Err    error
+}
+
+// seal replaces live order pointers with snapshots so the report can leave
+// the worker goroutine safely
+func (r *ExecutionReport) seal() {
+	if r.Order != nil {
+		r.Order = snapshotOrder(r.Order)
+	}
+	for i, m := range r.Makers {
+		r.Makers[i] = snapshotOrder(m)
+	}
+	for i, c := range r.Cancelled {
+		r.Cancelled[i] = snapshotOrder(c)
+	}
+	for i, t := range r.Triggered {
+		r.Triggered[i] = snapshotOrder(t)
+	}
+}
+
+// snapshotOrder returns a shallow copy of an order
+func snapshotOrder(order *models.Order) *models.Order {
+	copied := *order
+	return &copied
+}
+
+// symbolWorker owns one order book and is the only goroutine that mutates it
+type symbolWorker struct {
+	book     *OrderBook
+	commands chan *command
+	done     chan struct{}
+}
+
+// newSymbolWorker creates a worker for an order book
+func newSymbolWorker(book *OrderBook) *symbolWorker {
+	return &symbolWorker{
+		book:     book,
+		commands: make(chan *command, 1024),
+		done:     make(chan struct{}),
+	}
+}
+
+// run applies queued commands until the queue is closed. The book write lock
+// is held for the whole command so readers never observe a half-applied match.
+func (w *symbolWorker) run(me *MatchingEngine) {
+	defer close(w.done)
+
+	for cmd := range w.commands {
+		report := &ExecutionReport{Symbol: w.book.Symbol}
+		if err := me.sequence(w.book.Symbol, cmd); err != nil {
+			report.Err = err
+			me.deliver(cmd, report)
+			continue
+		}
+		report.Seq = cmd.seq
+		report.Time = cmd.time
+
+		w.book.mu.Lock()
+		if cmd.replay && cmd.seq <= w.book.lastSeq {
+			// Already contained in the restored snapshot
+			w.book.mu.Unlock()
+			me.deliver(cmd, report)
+			continue
+		}
+		me.apply(w.book, cmd, report)
+		w.book.lastSeq = cmd.seq
+		report.seal()
+		auction := me.auctionInfo(w.book, cmd, report)
+		depth := w.book.takeDepthUpdate(cmd.time)
+		l3 := w.book.takeL3Update(cmd.seq, cmd.time)
+		w.book.mu.Unlock()
+
+		if !cmd.replay {
+			me.publishTrades(report.Trades)
+			me.publishAuction(auction)
+			me.publishDepth(depth)
+			me.publishL3(l3)
+		}
+		me.deliver(cmd, report)
+	}
+}
+
+// deliver hands a report to the waiting caller. Reports of engine-initiated
+// commands go to the report channel so services can persist their effects.
+func (me *MatchingEngine) deliver(cmd *command, report *ExecutionReport) {
+	if cmd.reply != nil {
+		cmd.reply <- report
+		return
+	}
+	if report.Err == nil && !cmd.replay {
+		me.reportChan <- report
+	}
+}
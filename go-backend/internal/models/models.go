@@ -31,6 +31,24 @@ const (
 	OrderTypeTrailingStop OrderType = "trailing_stop" // 跟踪止损
 )
 
+// TriggerPriceType selects the price a conditional order is triggered by
+type TriggerPriceType string
+
+const (
+	TriggerPriceLast  TriggerPriceType = "last"  // last trade price
+	TriggerPriceMark  TriggerPriceType = "mark"  // mark price
+	TriggerPriceIndex TriggerPriceType = "index" // index price
+)
+
+// PegType selects the reference price a pegged order follows
+type PegType string
+
+const (
+	PegPrimary PegType = "primary" // best price on the order's own side
+	PegMarket  PegType = "market"  // best price on the opposite side
+	PegMid     PegType = "mid"     // midpoint of the best bid and ask
+)
+
 // OrderStatus represents order status
 type OrderStatus string
 
@@ -48,6 +66,34 @@ const (
 	TimeInForceGTC TimeInForce = "GTC" // Good Till Cancel
 	TimeInForceIOC TimeInForce = "IOC" // Immediate or Cancel
 	TimeInForceFOK TimeInForce = "FOK" // Fill or Kill
+	TimeInForceGTD TimeInForce = "GTD" // Good Till Date
+)
+
+// PostOnlyMode controls what happens to a post-only order that would take liquidity
+type PostOnlyMode string
+
+const (
+	PostOnlyReject  PostOnlyMode = "reject"  // reject the order
+	PostOnlyReprice PostOnlyMode = "reprice" // move the price one tick behind the opposite best
+)
+
+// STPMode selects how the engine resolves an order meeting a resting order
+// of the same user
+type STPMode string
+
+const (
+	STPCancelNewest    STPMode = "cancel_newest"    // cancel the incoming order
+	STPCancelOldest    STPMode = "cancel_oldest"    // cancel the resting order
+	STPCancelBoth      STPMode = "cancel_both"      // cancel both orders
+	STPDecrementCancel STPMode = "decrement_cancel" // reduce both by the overlap, cancel the smaller
+)
+
+// Cancel reasons recorded on orders cancelled by the engine
+const (
+	CancelReasonUser       = "user"
+	CancelReasonExpired    = "expired"
+	CancelReasonSelfTrade  = "self_trade_prevention"
+	CancelReasonDisconnect = "cancel_on_disconnect"
 )
 
 // Order represents a trading order
@@ -64,17 +110,35 @@ type Order struct {
 	AvgPrice      decimal.Decimal `json:"avg_price" gorm:"type:decimal(36,18)"`
 	Fee           decimal.Decimal `json:"fee" gorm:"type:decimal(36,18)"`
 	FeeCurrency   string          `json:"fee_currency"`
+	MakerFeeRate  *decimal.Decimal `json:"maker_fee_rate,omitempty" gorm:"type:decimal(36,18)"` // 下单时的Maker费率
+	TakerFeeRate  *decimal.Decimal `json:"taker_fee_rate,omitempty" gorm:"type:decimal(36,18)"` // 下单时的Taker费率
 	Status        OrderStatus     `json:"status" gorm:"index"`
 	TimeInForce   TimeInForce     `json:"time_in_force"`
+	PostOnly      PostOnlyMode    `json:"post_only,omitempty"`                // 只做Maker, empty when not post-only
+	ReduceOnly    bool            `json:"reduce_only" gorm:"default:false"`    // 只减仓
+	ExpireTime    *time.Time      `json:"expire_time,omitempty" gorm:"index"` // GTD到期时间
+	STPMode       STPMode         `json:"stp_mode,omitempty"` // 自成交保护, empty uses the account default
+	CancelReason  string          `json:"cancel_reason,omitempty"`
 
 	// Stop-loss and Take-profit fields
 	StopPrice     *decimal.Decimal `json:"stop_price,omitempty" gorm:"type:decimal(36,18)"` // 触发价格
 	TakeProfitPrice *decimal.Decimal `json:"take_profit_price,omitempty" gorm:"type:decimal(36,18)"` // 止盈价
 	TrailingDelta *decimal.Decimal `json:"trailing_delta,omitempty" gorm:"type:decimal(36,18)"` // 跟踪止损价差
+	TrailingPercent *decimal.Decimal `json:"trailing_percent,omitempty" gorm:"type:decimal(36,18)"` // 跟踪止损百分比, instead of a delta
 	TriggerCondition string        `json:"trigger_condition,omitempty"` // 触发条件: >=, <=
+	TriggerPriceType TriggerPriceType `json:"trigger_price_type,omitempty"` // 触发价格来源, empty for last
 	IsTriggered   bool             `json:"is_triggered" gorm:"default:false"` // 是否已触发
 	TriggerTime   *time.Time       `json:"trigger_time,omitempty"` // 触发时间
 
+	// Quote budget of a market buy: the order spends at most this much quote
+	// currency, and is sized by it alone when Quantity is zero
+	QuoteOrderQty *decimal.Decimal `json:"quote_order_qty,omitempty" gorm:"type:decimal(36,18)"` // 按计价币金额下单
+
+	// Pegged order fields; the engine keeps Price at the pegged price
+	PegType   PegType          `json:"peg_type,omitempty"`                                      // 挂钩类型, empty when not pegged
+	PegOffset *decimal.Decimal `json:"peg_offset,omitempty" gorm:"type:decimal(36,18)"`         // 相对参考价的偏移
+	PegLimit  *decimal.Decimal `json:"peg_limit,omitempty" gorm:"type:decimal(36,18)"`          // 价格上限(买)/下限(卖)
+
 	CreateTime    time.Time       `json:"create_time" gorm:"index"`
 	UpdateTime    time.Time       `json:"update_time"`
 }
@@ -92,6 +156,9 @@ type Trade struct {
 	Amount       decimal.Decimal `json:"amount" gorm:"type:decimal(36,18)"`
 	BuyerFee     decimal.Decimal `json:"buyer_fee" gorm:"type:decimal(36,18)"`
 	SellerFee    decimal.Decimal `json:"seller_fee" gorm:"type:decimal(36,18)"`
+	BuyerFeeCurrency  string     `json:"buyer_fee_currency"`
+	SellerFeeCurrency string     `json:"seller_fee_currency"`
+	BuyerIsMaker bool            `json:"buyer_is_maker"` // false when the buyer took liquidity
 	TradeTime    time.Time       `json:"trade_time" gorm:"index"`
 }
 
@@ -108,6 +175,7 @@ type User struct {
 	RegisterTime time.Time `json:"register_time"`
 	LastLoginIP  string    `json:"last_login_ip"`
 	LastLoginTime time.Time `json:"last_login_time"`
+	STPMode      STPMode   `json:"stp_mode" gorm:"default:cancel_newest"` // default self-trade prevention mode
 }
 
 // UserAsset represents user asset balance
@@ -155,6 +223,68 @@ type Withdrawal struct {
 	Remark        string          `json:"remark"`
 }
 
+// UserFeeOverride replaces the pair fee rates for one user; an empty
+// symbol applies to every pair
+type UserFeeOverride struct {
+	ID           uint            `json:"id" gorm:"primaryKey"`
+	UserID       uint            `json:"user_id" gorm:"uniqueIndex:idx_user_fee_symbol"`
+	Symbol       string          `json:"symbol" gorm:"uniqueIndex:idx_user_fee_symbol"`
+	MakerFeeRate decimal.Decimal `json:"maker_fee_rate" gorm:"type:decimal(10,8)"`
+	TakerFeeRate decimal.Decimal `json:"taker_fee_rate" gorm:"type:decimal(10,8)"`
+	CreateTime   time.Time       `json:"create_time"`
+	UpdateTime   time.Time       `json:"update_time"`
+}
+
+// FeeTier is one level of the VIP fee schedule. A user qualifies by 30-day
+// traded notional or, when MinTokenHolding is set, by EasiToken holdings.
+type FeeTier struct {
+	ID              uint            `json:"id" gorm:"primaryKey"`
+	Level           int             `json:"level" gorm:"uniqueIndex"`
+	Name            string          `json:"name"` // VIP0..VIP9
+	MinVolume30d    decimal.Decimal `json:"min_volume_30d" gorm:"type:decimal(36,18);default:0"`
+	MinTokenHolding decimal.Decimal `json:"min_token_holding" gorm:"type:decimal(36,18);default:0"`
+	MakerFeeRate    decimal.Decimal `json:"maker_fee_rate" gorm:"type:decimal(10,8)"`
+	TakerFeeRate    decimal.Decimal `json:"taker_fee_rate" gorm:"type:decimal(10,8)"`
+	CreateTime      time.Time       `json:"create_time"`
+	UpdateTime      time.Time       `json:"update_time"`
+}
+
+// UserFeeTier is a user's tier from the last nightly computation
+type UserFeeTier struct {
+	UserID       uint            `json:"user_id" gorm:"primaryKey"`
+	Level        int             `json:"level"`
+	Volume30d    decimal.Decimal `json:"volume_30d" gorm:"type:decimal(36,18)"`
+	TokenHolding decimal.Decimal `json:"token_holding" gorm:"type:decimal(36,18)"`
+	ComputeTime  time.Time       `json:"compute_time"`
+}
+
+// Kline is a closed OHLCV bar of one symbol and interval; Volume is in base
+// and QuoteVolume in quote currency
+type Kline struct {
+	Symbol      string          `json:"symbol" gorm:"primaryKey"`
+	Interval    string          `json:"interval" gorm:"primaryKey"` // 1m/5m/15m/1h/4h/1d/1w
+	OpenTime    time.Time       `json:"open_time" gorm:"primaryKey"`
+	CloseTime   time.Time       `json:"close_time"` // last instant of the bar
+	Open        decimal.Decimal `json:"open" gorm:"type:decimal(36,18)"`
+	High        decimal.Decimal `json:"high" gorm:"type:decimal(36,18)"`
+	Low         decimal.Decimal `json:"low" gorm:"type:decimal(36,18)"`
+	Close       decimal.Decimal `json:"close" gorm:"type:decimal(36,18)"`
+	Volume      decimal.Decimal `json:"volume" gorm:"type:decimal(36,18)"`
+	QuoteVolume decimal.Decimal `json:"quote_volume" gorm:"type:decimal(36,18)"`
+	TradeCount  int             `json:"trade_count"`
+}
+
+// MarkPrice is the latest index and mark price of a symbol. The mark price
+// is the index plus Basis, the smoothed difference between the book mid and
+// the index.
+type MarkPrice struct {
+	Symbol     string          `json:"symbol" gorm:"primaryKey"`
+	IndexPrice decimal.Decimal `json:"index_price" gorm:"type:decimal(36,18)"`
+	MarkPrice  decimal.Decimal `json:"mark_price" gorm:"type:decimal(36,18)"`
+	Basis      decimal.Decimal `json:"basis" gorm:"type:decimal(36,18)"`
+	UpdateTime time.Time       `json:"update_time"`
+}
+
 // TradingPair represents a trading pair configuration
 type TradingPair struct {
 	ID                uint            `json:"id" gorm:"primaryKey"`
@@ -169,6 +299,9 @@ type TradingPair struct {
 	TakerFeeRate      decimal.Decimal `json:"taker_fee_rate" gorm:"type:decimal(10,8);default:0.001"`
 	MakerFeeRate      decimal.Decimal `json:"maker_fee_rate" gorm:"type:decimal(10,8);default:0.001"`
 	IsActive          bool            `json:"is_active" gorm:"default:true"`
+	TradingStatus     string          `json:"trading_status" gorm:"default:trading"` // trading/halted/cancel_only/post_only
+	AuctionEndTime    *time.Time      `json:"auction_end_time,omitempty"`            // opening auction of a new listing
+	MatchingAlgorithm string          `json:"matching_algorithm" gorm:"default:fifo"` // fifo/pro_rata/top_order
 	CreateTime        time.Time       `json:"create_time"`
 }
 
@@ -200,6 +333,26 @@ func (TradingPair) TableName() string {
 	return "trading_pairs"
 }
 
+func (UserFeeOverride) TableName() string {
+	return "user_fee_overrides"
+}
+
+func (FeeTier) TableName() string {
+	return "fee_tiers"
+}
+
+func (UserFeeTier) TableName() string {
+	return "user_fee_tiers"
+}
+
+func (Kline) TableName() string {
+	return "klines"
+}
+
+func (MarkPrice) TableName() string {
+	return "mark_prices"
+}
+
 // RiskEvent represents a risk event for logging and monitoring
 type RiskEvent struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
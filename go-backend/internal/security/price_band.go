@@ -0,0 +1,101 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package security
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// PriceBands rejects limit orders priced too far from the last trade price.
+// Reference prices are kept in memory and fed from the engine's trades; a
+// symbol's last stored trade is read only the first time it is checked.
+type PriceBands struct {
+	maxDeviation decimal.Decimal // fraction of the reference price
+	prices       map[string]decimal.Decimal
+	loaded       map[string]bool // reference looked up in the database
+	mutex        sync.RWMutex
+}
+
+// NewPriceBands creates price bands allowing maxDeviation around the last price
+func NewPriceBands(maxDeviation decimal.Decimal) *PriceBands {
+	return &PriceBands{
+		maxDeviation: maxDeviation,
+		prices:       make(map[string]decimal.Decimal),
+		loaded:       make(map[string]bool),
+	}
+}
+
+// SetMaxDeviation changes the allowed deviation; zero disables the bands
+func (b *PriceBands) SetMaxDeviation(maxDeviation decimal.Decimal) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.maxDeviation = maxDeviation
+}
+
+// Update records a symbol's last trade price
+func (b *PriceBands) Update(symbol string, price decimal.Decimal) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.prices[symbol] = price
+	b.loaded[symbol] = true
+}
+
+// Check rejects an order whose limit price is outside the band around the
+// reference price. Orders without a limit price, pegged orders, which the
+// engine prices at the book, and symbols that never traded are not limited.
+func (b *PriceBands) Check(order *models.Order) error {
+	if order.Type != models.OrderTypeLimit && order.Type != models.OrderTypeStopLimit {
+		return nil
+	}
+	if order.PegType != "" {
+		return nil
+	}
+
+	reference, maxDeviation, ok := b.reference(order.Symbol)
+	if !ok || !maxDeviation.IsPositive() {
+		return nil
+	}
+
+	deviation := order.Price.Sub(reference).Abs().Div(reference)
+	if deviation.GreaterThan(maxDeviation) {
+		return fmt.Errorf("price deviates more than %s%% from market price", maxDeviation.Mul(decimal.NewFromInt(100)))
+	}
+	return nil
+}
+
+// reference returns the reference price and allowed deviation of a symbol
+func (b *PriceBands) reference(symbol string) (decimal.Decimal, decimal.Decimal, bool) {
+	b.mutex.RLock()
+	price, exists := b.prices[symbol]
+	loaded := b.loaded[symbol]
+	maxDeviation := b.maxDeviation
+	b.mutex.RUnlock()
+
+	if exists || loaded {
+		return price, maxDeviation, exists
+	}
+
+	var lastTrade models.Trade
+	err := database.DB.Where("symbol = ?", symbol).
+		Order("trade_time DESC").
+		First(&lastTrade).Error
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.loaded[symbol] = true
+	if err != nil {
+		// No previous trades, allow any price
+		return decimal.Zero, maxDeviation, false
+	}
+	if _, exists := b.prices[symbol]; !exists {
+		b.prices[symbol] = lastTrade.Price
+	}
+	return b.prices[symbol], maxDeviation, true
+}
@@ -21,6 +21,7 @@ type RiskManager struct {
 	dailyWithdrawalLimit  map[int]decimal.Decimal // KYC level -> limit
 	apiRateLimit          int
 	orderRateLimit        int
+	priceBands            *PriceBands
 }
 
 // NewRiskManager creates a new risk manager
@@ -34,9 +35,21 @@ func NewRiskManager() *RiskManager {
 		},
 		apiRateLimit:   100,
 		orderRateLimit: 10,
+		priceBands:     NewPriceBands(decimal.NewFromFloat(0.1)),
 	}
 }
 
+// SetMaxPriceDeviation sets how far, as a fraction of the last trade price,
+// limit order prices may deviate; zero disables the check
+func (rm *RiskManager) SetMaxPriceDeviation(maxDeviation float64) {
+	rm.priceBands.SetMaxDeviation(decimal.NewFromFloat(maxDeviation))
+}
+
+// RecordTrade moves the symbol's price band to the trade price
+func (rm *RiskManager) RecordTrade(trade *models.Trade) {
+	rm.priceBands.Update(trade.Symbol, trade.Price)
+}
+
 // ValidateOrder validates order against risk rules
 func (rm *RiskManager) ValidateOrder(ctx context.Context, order *models.Order, user *models.User) error {
 	// Check order size
@@ -65,29 +78,7 @@ func (rm *RiskManager) ValidateOrder(ctx context.Context, order *models.Order, u
 
 // checkPriceDeviation checks if order price deviates too much from market
 func (rm *RiskManager) checkPriceDeviation(order *models.Order) error {
-	if order.Type == models.OrderTypeMarket {
-		return nil
-	}
-
-	// Get last trade price
-	var lastTrade models.Trade
-	if err := database.DB.Where("symbol = ?", order.Symbol).
-		Order("trade_time DESC").
-		First(&lastTrade).Error; err != nil {
-		// No previous trades, allow any price
-		return nil
-	}
-
-	// Check if price deviates more than 10% from last trade
-	maxDeviation := decimal.NewFromFloat(0.1)
-	priceDiff := order.Price.Sub(lastTrade.Price).Abs()
-	deviationPercent := priceDiff.Div(lastTrade.Price)
-
-	if deviationPercent.GreaterThan(maxDeviation) {
-		return fmt.Errorf("price deviates more than 10%% from market price")
-	}
-
-	return nil
+	return rm.priceBands.Check(order)
 }
 
 // checkOrderFrequency checks order frequency
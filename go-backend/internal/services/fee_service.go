@@ -0,0 +1,159 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// pairFees holds the fee rates of a trading pair
+type pairFees struct {
+	makerRate decimal.Decimal
+	takerRate decimal.Decimal
+}
+
+// FeeService resolves fee rates for the matching engine from the trading
+// pair maker/taker rates and per-user overrides. Everything is served from
+// memory because it runs on the order placement path.
+type FeeService struct {
+	pairs     map[string]pairFees                        // symbol -> pair rates
+	overrides map[uint]map[string]models.UserFeeOverride // user -> symbol -> override
+	tiers     FeeTierSource
+	mutex     sync.RWMutex
+}
+
+// FeeTierSource reports the rates of a user's VIP tier, if above the base tier
+type FeeTierSource interface {
+	TierRates(userID uint) (makerRate, takerRate decimal.Decimal, ok bool)
+}
+
+// NewFeeService creates a new fee service
+func NewFeeService() *FeeService {
+	return &FeeService{
+		pairs:     make(map[string]pairFees),
+		overrides: make(map[uint]map[string]models.UserFeeOverride),
+	}
+}
+
+// SetTierSource makes users above the base VIP tier pay their tier rates
+// where those are below the pair rates
+func (s *FeeService) SetTierSource(tiers FeeTierSource) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tiers = tiers
+}
+
+// UpdatePairs replaces the pair rates; it is registered with the symbol
+// registry so rate changes are picked up with the other pair settings
+func (s *FeeService) UpdatePairs(pairs []models.TradingPair) {
+	fees := make(map[string]pairFees, len(pairs))
+	for _, pair := range pairs {
+		fees[pair.Symbol] = pairFees{
+			makerRate: pair.MakerFeeRate,
+			takerRate: pair.TakerFeeRate,
+		}
+	}
+
+	s.mutex.Lock()
+	s.pairs = fees
+	s.mutex.Unlock()
+}
+
+// LoadOverrides reads all per-user fee overrides
+func (s *FeeService) LoadOverrides() error {
+	var rows []models.UserFeeOverride
+	if err := database.DB.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	overrides := make(map[uint]map[string]models.UserFeeOverride)
+	for _, row := range rows {
+		if overrides[row.UserID] == nil {
+			overrides[row.UserID] = make(map[string]models.UserFeeOverride)
+		}
+		overrides[row.UserID][row.Symbol] = row
+	}
+
+	s.mutex.Lock()
+	s.overrides = overrides
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// SetUserOverride saves a user's fee override; an empty symbol applies to all pairs
+func (s *FeeService) SetUserOverride(userID uint, symbol string, makerRate, takerRate decimal.Decimal) error {
+	override := models.UserFeeOverride{
+		UserID:       userID,
+		Symbol:       symbol,
+		MakerFeeRate: makerRate,
+		TakerFeeRate: takerRate,
+		UpdateTime:   time.Now(),
+	}
+
+	var existing models.UserFeeOverride
+	if err := database.DB.Where("user_id = ? AND symbol = ?", userID, symbol).First(&existing).Error; err == nil {
+		override.ID = existing.ID
+		override.CreateTime = existing.CreateTime
+	} else {
+		override.CreateTime = override.UpdateTime
+	}
+
+	if err := database.DB.Save(&override).Error; err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.overrides[userID] == nil {
+		s.overrides[userID] = make(map[string]models.UserFeeOverride)
+	}
+	s.overrides[userID][symbol] = override
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// GetFeeRates returns the maker and taker rates a user pays on a symbol. It
+// implements matching.FeeCalculator; the engine stamps the rates on new
+// orders, so a change applies to orders placed after it.
+func (s *FeeService) GetFeeRates(userID uint, symbol string) (decimal.Decimal, decimal.Decimal) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.ratesLocked(userID, symbol)
+}
+
+// ratesLocked resolves a user's rates; the caller must hold the mutex.
+// Overrides win over everything else. A VIP tier rate applies only where it
+// is below the pair rate, so promotional pair rates stay the cheapest.
+func (s *FeeService) ratesLocked(userID uint, symbol string) (decimal.Decimal, decimal.Decimal) {
+	// A symbol-specific override wins over an account-wide one
+	if overrides, exists := s.overrides[userID]; exists {
+		if o, exists := overrides[symbol]; exists {
+			return o.MakerFeeRate, o.TakerFeeRate
+		}
+		if o, exists := overrides[""]; exists {
+			return o.MakerFeeRate, o.TakerFeeRate
+		}
+	}
+
+	maker, taker := matching.DefaultFeeRate, matching.DefaultFeeRate
+	if pair, exists := s.pairs[symbol]; exists {
+		maker, taker = pair.makerRate, pair.takerRate
+	}
+
+	if s.tiers != nil {
+		if tierMaker, tierTaker, ok := s.tiers.TierRates(userID); ok {
+			maker, taker = decimal.Min(maker, tierMaker), decimal.Min(taker, tierTaker)
+		}
+	}
+	return maker, taker
+}
@@ -0,0 +1,368 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// feeTierWindow is the traded-volume window tiers are computed over
+const feeTierWindow = 30 * 24 * time.Hour
+
+// FeeTierProgress shows a user's tier and what is missing for the next one
+type FeeTierProgress struct {
+	Tier               models.FeeTier  `json:"tier"`
+	Volume30d          decimal.Decimal `json:"volume_30d"`
+	TokenHolding       decimal.Decimal `json:"token_holding"`
+	NextTier           *models.FeeTier `json:"next_tier,omitempty"`
+	VolumeToNext       decimal.Decimal `json:"volume_to_next"`
+	TokenHoldingToNext decimal.Decimal `json:"token_holding_to_next,omitempty"`
+	ComputeTime        time.Time       `json:"compute_time"`
+}
+
+// FeeTierService computes VIP fee tiers from 30-day traded notional and
+// token holdings once a night and serves the cached result to fee pricing.
+// Notional is counted in volumeCurrency: pairs quoted in another currency are
+// converted at the last price of that currency against it.
+type FeeTierService struct {
+	tokenCurrency  string
+	volumeCurrency string
+	runAt          time.Duration // offset from UTC midnight
+	tiers          []models.FeeTier
+	userTiers      map[uint]models.UserFeeTier
+	mutex          sync.RWMutex
+	stopChan       chan struct{}
+	running        bool
+}
+
+// NewFeeTierService creates a new fee tier service. Volume is measured in
+// volumeCurrency and holdings of tokenCurrency also qualify for tiers; runAt
+// is the time of day (UTC) of the nightly computation.
+func NewFeeTierService(tokenCurrency, volumeCurrency string, runAt time.Duration) *FeeTierService {
+	return &FeeTierService{
+		tokenCurrency:  tokenCurrency,
+		volumeCurrency: volumeCurrency,
+		runAt:          runAt,
+		userTiers:      make(map[uint]models.UserFeeTier),
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// Load reads the tier schedule and the last computed user tiers
+func (s *FeeTierService) Load() error {
+	var tiers []models.FeeTier
+	if err := database.DB.Order("level ASC").Find(&tiers).Error; err != nil {
+		return err
+	}
+
+	var rows []models.UserFeeTier
+	if err := database.DB.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	userTiers := make(map[uint]models.UserFeeTier, len(rows))
+	for _, row := range rows {
+		userTiers[row.UserID] = row
+	}
+
+	s.mutex.Lock()
+	s.tiers = tiers
+	s.userTiers = userTiers
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Start runs the tier computation every night
+func (s *FeeTierService) Start() {
+	s.mutex.Lock()
+	if s.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.running = true
+	s.mutex.Unlock()
+
+	go s.scheduleLoop()
+}
+
+// Stop stops the nightly job
+func (s *FeeTierService) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.running {
+		return
+	}
+
+	s.running = false
+	close(s.stopChan)
+}
+
+// scheduleLoop sleeps until the next run time and computes the tiers
+func (s *FeeTierService) scheduleLoop() {
+	for {
+		timer := time.NewTimer(time.Until(s.nextRun(time.Now())))
+
+		select {
+		case <-timer.C:
+			if err := s.ComputeTiers(); err != nil {
+				fmt.Printf("Error computing fee tiers: %v\n", err)
+			}
+		case <-s.stopChan:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// nextRun returns the first run time after now
+func (s *FeeTierService) nextRun(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(s.runAt)
+	if !next.After(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+// ComputeTiers recomputes every active user's tier from the trades of the
+// last 30 days and the token holdings, then replaces the cache
+func (s *FeeTierService) ComputeTiers() error {
+	if err := s.Load(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	since := now.Add(-feeTierWindow)
+
+	quotes, err := s.quoteCurrencies()
+	if err != nil {
+		return err
+	}
+
+	volumes := make(map[uint]decimal.Decimal)
+	rates := make(map[string]*decimal.Decimal) // quote currency -> volume currency per unit
+	for _, column := range []string{"buyer_id", "seller_id"} {
+		var rows []struct {
+			UserID uint
+			Symbol string
+			Volume decimal.Decimal
+		}
+		if err := database.DB.Model(&models.Trade{}).
+			Select(column+" AS user_id, symbol, SUM(amount) AS volume").
+			Where("trade_time >= ?", since).
+			Group(column + ", symbol").
+			Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			quote, exists := quotes[row.Symbol]
+			if !exists {
+				_, quote = matching.SplitSymbol(row.Symbol)
+			}
+			rate, cached := rates[quote]
+			if !cached {
+				if rate, err = s.conversionRate(quote); err != nil {
+					return err
+				}
+				if rate == nil {
+					fmt.Printf("Error computing fee tiers: no %s price for %s volume\n", s.volumeCurrency, quote)
+				}
+				rates[quote] = rate
+			}
+			if rate != nil {
+				volumes[row.UserID] = volumes[row.UserID].Add(row.Volume.Mul(*rate))
+			}
+		}
+	}
+
+	holdings := make(map[uint]decimal.Decimal)
+	if s.tokenCurrency != "" {
+		var rows []struct {
+			UserID  uint
+			Holding decimal.Decimal
+		}
+		if err := database.DB.Model(&models.UserAsset{}).
+			Select("user_id, SUM(available + frozen) AS holding").
+			Where("currency = ?", s.tokenCurrency).
+			Group("user_id").
+			Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			holdings[row.UserID] = row.Holding
+		}
+	}
+
+	s.mutex.RLock()
+	tiers := s.tiers
+	s.mutex.RUnlock()
+
+	userIDs := make(map[uint]struct{}, len(volumes))
+	for userID := range volumes {
+		userIDs[userID] = struct{}{}
+	}
+	for userID := range holdings {
+		userIDs[userID] = struct{}{}
+	}
+
+	userTiers := make(map[uint]models.UserFeeTier, len(userIDs))
+	rows := make([]models.UserFeeTier, 0, len(userIDs))
+	for userID := range userIDs {
+		row := models.UserFeeTier{
+			UserID:       userID,
+			Volume30d:    volumes[userID],
+			TokenHolding: holdings[userID],
+			ComputeTime:  now,
+		}
+		row.Level = qualifyingTier(tiers, row.Volume30d, row.TokenHolding).Level
+		userTiers[userID] = row
+		rows = append(rows, row)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Users without recent activity drop back to the base tier
+		if err := tx.Where("compute_time < ?", now).Delete(&models.UserFeeTier{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(rows, 500).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.userTiers = userTiers
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// quoteCurrencies returns the quote currency of every trading pair
+func (s *FeeTierService) quoteCurrencies() (map[string]string, error) {
+	var pairs []models.TradingPair
+	if err := database.DB.Select("symbol, quote_currency").Find(&pairs).Error; err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		quotes[pair.Symbol] = pair.QuoteCurrency
+	}
+	return quotes, nil
+}
+
+// conversionRate returns the value of one unit of currency in the volume
+// currency from the last trade of the pair between them, or nil when they
+// have not traded
+func (s *FeeTierService) conversionRate(currency string) (*decimal.Decimal, error) {
+	one := decimal.NewFromInt(1)
+	if currency == s.volumeCurrency {
+		return &one, nil
+	}
+
+	for _, pair := range []struct {
+		symbol  string
+		inverse bool
+	}{
+		{currency + "_" + s.volumeCurrency, false},
+		{s.volumeCurrency + "_" + currency, true},
+	} {
+		var trade models.Trade
+		err := database.DB.Where("symbol = ?", pair.symbol).Order("trade_time DESC").First(&trade).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !trade.Price.IsPositive()) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rate := trade.Price
+		if pair.inverse {
+			rate = one.Div(trade.Price)
+		}
+		return &rate, nil
+	}
+	return nil, nil
+}
+
+// qualifyingTier returns the highest tier reached by volume or holdings;
+// tiers must be sorted by level
+func qualifyingTier(tiers []models.FeeTier, volume, holding decimal.Decimal) models.FeeTier {
+	var best models.FeeTier
+	for _, tier := range tiers {
+		byVolume := volume.GreaterThanOrEqual(tier.MinVolume30d)
+		byHolding := tier.MinTokenHolding.IsPositive() && holding.GreaterThanOrEqual(tier.MinTokenHolding)
+		if byVolume || byHolding {
+			best = tier
+		}
+	}
+	return best
+}
+
+// TierRates returns the fee rates of a user's cached tier. It reports false
+// for users at the base tier, who pay the pair rates.
+func (s *FeeTierService) TierRates(userID uint) (decimal.Decimal, decimal.Decimal, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	userTier, exists := s.userTiers[userID]
+	if !exists || userTier.Level == 0 {
+		return decimal.Zero, decimal.Zero, false
+	}
+
+	i := sort.Search(len(s.tiers), func(i int) bool { return s.tiers[i].Level >= userTier.Level })
+	if i == len(s.tiers) || s.tiers[i].Level != userTier.Level {
+		return decimal.Zero, decimal.Zero, false
+	}
+	return s.tiers[i].MakerFeeRate, s.tiers[i].TakerFeeRate, true
+}
+
+// GetUserTier returns a user's current tier and progress to the next one
+func (s *FeeTierService) GetUserTier(userID uint) (*FeeTierProgress, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.tiers) == 0 {
+		return nil, errors.New("fee tiers are not configured")
+	}
+
+	userTier := s.userTiers[userID]
+	progress := &FeeTierProgress{
+		Tier:         s.tiers[0],
+		Volume30d:    userTier.Volume30d,
+		TokenHolding: userTier.TokenHolding,
+		ComputeTime:  userTier.ComputeTime,
+	}
+
+	for i, tier := range s.tiers {
+		if tier.Level > userTier.Level {
+			next := s.tiers[i]
+			progress.NextTier = &next
+			progress.VolumeToNext = decimal.Max(next.MinVolume30d.Sub(userTier.Volume30d), decimal.Zero)
+			if next.MinTokenHolding.IsPositive() {
+				progress.TokenHoldingToNext = decimal.Max(next.MinTokenHolding.Sub(userTier.TokenHolding), decimal.Zero)
+			}
+			break
+		}
+		progress.Tier = tier
+	}
+
+	return progress, nil
+}
@@ -0,0 +1,149 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupFeeTiers loads a three-level schedule where VIP2 is also reached by
+// holding 500 EASI, and returns a computed tier service
+func setupFeeTiers(t *testing.T) *FeeTierService {
+	t.Helper()
+
+	db := useTestDB(t, &models.Trade{}, &models.TradingPair{}, &models.UserAsset{},
+		&models.FeeTier{}, &models.UserFeeTier{}, &models.UserFeeOverride{})
+
+	d := decimal.RequireFromString
+	require.NoError(t, db.Create([]models.FeeTier{
+		{Level: 0, Name: "VIP0", MakerFeeRate: d("0.001"), TakerFeeRate: d("0.001")},
+		{Level: 1, Name: "VIP1", MinVolume30d: d("10000"), MakerFeeRate: d("0.0008"), TakerFeeRate: d("0.0009")},
+		{Level: 2, Name: "VIP2", MinVolume30d: d("100000"), MinTokenHolding: d("500"), MakerFeeRate: d("0.0005"), TakerFeeRate: d("0.0006")},
+	}).Error)
+	require.NoError(t, db.Create([]models.TradingPair{
+		{Symbol: "BTC_USDT", BaseCurrency: "BTC", QuoteCurrency: "USDT"},
+		{Symbol: "ETH_BTC", BaseCurrency: "ETH", QuoteCurrency: "BTC"},
+	}).Error)
+
+	now := time.Now()
+	require.NoError(t, db.Create([]models.Trade{
+		// 6000 USDT for users 1 and 2
+		{ID: "t1", Symbol: "BTC_USDT", BuyerID: 1, SellerID: 2, Price: d("50000"), Quantity: d("0.12"), Amount: d("6000"), TradeTime: now.Add(-time.Hour)},
+		// 0.1 BTC, worth 5000 USDT, for users 1 and 3
+		{ID: "t2", Symbol: "ETH_BTC", BuyerID: 3, SellerID: 1, Price: d("0.05"), Quantity: d("2"), Amount: d("0.1"), TradeTime: now.Add(-time.Hour)},
+		// Outside the 30-day window
+		{ID: "t3", Symbol: "BTC_USDT", BuyerID: 2, SellerID: 3, Price: d("50000"), Quantity: d("2"), Amount: d("100000"), TradeTime: now.Add(-31 * 24 * time.Hour)},
+	}).Error)
+	require.NoError(t, db.Create(&models.UserAsset{UserID: 4, Currency: "EASI", Available: d("400"), Frozen: d("200")}).Error)
+
+	service := NewFeeTierService("EASI", "USDT", 5*time.Minute)
+	require.NoError(t, service.ComputeTiers())
+	return service
+}
+
+// TestComputeTiers checks volume is counted in the volume currency across
+// quote currencies and that token holdings alone qualify
+func TestComputeTiers(t *testing.T) {
+	service := setupFeeTiers(t)
+
+	tests := []struct {
+		userID uint
+		level  int
+		volume string
+	}{
+		{1, 1, "11000"}, // 6000 USDT plus 0.1 BTC at 50000
+		{2, 0, "6000"},  // the old trade has left the window
+		{3, 0, "5000"},
+		{4, 2, "0"}, // by holding 600 EASI
+	}
+	for _, tt := range tests {
+		progress, err := service.GetUserTier(tt.userID)
+		require.NoError(t, err)
+		assert.Equal(t, tt.level, progress.Tier.Level, "user %d", tt.userID)
+		assert.True(t, progress.Volume30d.Equal(decimal.RequireFromString(tt.volume)), "user %d volume %s", tt.userID, progress.Volume30d)
+	}
+
+	_, _, ok := service.TierRates(2)
+	assert.False(t, ok, "base tier users pay the pair rates")
+	maker, taker, ok := service.TierRates(4)
+	require.True(t, ok)
+	assert.True(t, maker.Equal(decimal.RequireFromString("0.0005")))
+	assert.True(t, taker.Equal(decimal.RequireFromString("0.0006")))
+}
+
+// TestFeeTierProgress checks the distance to the next tier
+func TestFeeTierProgress(t *testing.T) {
+	service := setupFeeTiers(t)
+
+	progress, err := service.GetUserTier(1)
+	require.NoError(t, err)
+	require.NotNil(t, progress.NextTier)
+	assert.Equal(t, "VIP2", progress.NextTier.Name)
+	assert.True(t, progress.VolumeToNext.Equal(decimal.RequireFromString("89000")), "volume to next %s", progress.VolumeToNext)
+	assert.True(t, progress.TokenHoldingToNext.Equal(decimal.RequireFromString("500")), "holding to next %s", progress.TokenHoldingToNext)
+
+	// Users never seen by the job start at the base tier
+	progress, err = service.GetUserTier(99)
+	require.NoError(t, err)
+	assert.Equal(t, "VIP0", progress.Tier.Name)
+	assert.True(t, progress.VolumeToNext.Equal(decimal.RequireFromString("10000")))
+
+	progress, err = service.GetUserTier(4)
+	require.NoError(t, err)
+	assert.Equal(t, "VIP2", progress.Tier.Name)
+	assert.Nil(t, progress.NextTier)
+}
+
+// TestFeeRatePrecedence checks overrides win over VIP tiers, which win over
+// the pair rates
+func TestFeeRatePrecedence(t *testing.T) {
+	tiers := setupFeeTiers(t)
+	d := decimal.RequireFromString
+
+	fees := NewFeeService()
+	fees.UpdatePairs([]models.TradingPair{
+		{Symbol: "BTC_USDT", MakerFeeRate: d("0.002"), TakerFeeRate: d("0.003")},
+		{Symbol: "PROMO_USDT", MakerFeeRate: d("0"), TakerFeeRate: d("0.0005")},
+		{Symbol: "MIXED_USDT", MakerFeeRate: d("0.0004"), TakerFeeRate: d("0.002")},
+	})
+	fees.SetTierSource(tiers)
+
+	assertRates := func(userID uint, symbol, maker, taker string) {
+		t.Helper()
+		gotMaker, gotTaker := fees.GetFeeRates(userID, symbol)
+		assert.True(t, gotMaker.Equal(d(maker)), "user %d %s maker rate %s", userID, symbol, gotMaker)
+		assert.True(t, gotTaker.Equal(d(taker)), "user %d %s taker rate %s", userID, symbol, gotTaker)
+	}
+
+	assertRates(2, "BTC_USDT", "0.002", "0.003")
+	assertRates(2, "ETH_USDT", matching.DefaultFeeRate.String(), matching.DefaultFeeRate.String())
+	assertRates(1, "BTC_USDT", "0.0008", "0.0009")
+
+	// A pair rate below the tier rate is kept, side by side
+	assertRates(1, "PROMO_USDT", "0", "0.0005")
+	assertRates(1, "MIXED_USDT", "0.0004", "0.0009")
+	assertRates(2, "PROMO_USDT", "0", "0.0005")
+
+	require.NoError(t, fees.SetUserOverride(1, "", d("0.0002"), d("0.0003")))
+	assertRates(1, "BTC_USDT", "0.0002", "0.0003")
+
+	require.NoError(t, fees.SetUserOverride(1, "BTC_USDT", d("0"), d("0.0001")))
+	assertRates(1, "BTC_USDT", "0", "0.0001")
+	assertRates(1, "ETH_BTC", "0.0002", "0.0003")
+
+	// Overrides survive a reload
+	reloaded := NewFeeService()
+	require.NoError(t, reloaded.LoadOverrides())
+	maker, taker := reloaded.GetFeeRates(1, "BTC_USDT")
+	assert.True(t, maker.IsZero())
+	assert.True(t, taker.Equal(d("0.0001")))
+}
@@ -0,0 +1,240 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// History page sizes
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+)
+
+// ErrInvalidCursor is returned for a cursor that was not issued by a history query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrTradeNotFound is returned when a trade to page from does not exist
+var ErrTradeNotFound = errors.New("trade not found")
+
+// HistoryFilter narrows an order or trade history query. Empty fields match
+// everything; Statuses and Types only apply to orders.
+type HistoryFilter struct {
+	Symbols  []string
+	Side     models.OrderSide
+	Statuses []models.OrderStatus
+	Types    []models.OrderType
+	Start    *time.Time // inclusive
+	End      *time.Time // inclusive
+	Cursor   string     // NextCursor of the previous page
+	Limit    int
+}
+
+// OrderPage is one page of order history, newest first
+type OrderPage struct {
+	Orders     []models.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// TradePage is one page of a user's fills, newest first
+type TradePage struct {
+	Trades     []models.Trade `json:"trades"`
+	NextCursor string         `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// PublicTrade is the public view of a trade, without the orders, users and
+// fees behind it
+type PublicTrade struct {
+	ID            string          `json:"id"`
+	Price         decimal.Decimal `json:"price"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	QuoteQuantity decimal.Decimal `json:"quote_quantity"`
+	Time          time.Time       `json:"time"`
+	BuyerIsMaker  bool            `json:"buyer_is_maker"`
+}
+
+// publicTrades returns the public views of trades
+func publicTrades(trades []models.Trade) []PublicTrade {
+	views := make([]PublicTrade, len(trades))
+	for i, trade := range trades {
+		views[i] = PublicTrade{
+			ID:            trade.ID,
+			Price:         trade.Price,
+			Quantity:      trade.Quantity,
+			QuoteQuantity: trade.Amount,
+			Time:          trade.TradeTime,
+			BuyerIsMaker:  trade.BuyerIsMaker,
+		}
+	}
+	return views
+}
+
+// historyCursor is the position after the last row of a page. Pages are
+// ordered by time and ID, so rows inserted meanwhile do not shift later
+// pages the way offsets do.
+type historyCursor struct {
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+// encode returns the cursor as an opaque string
+func (c historyCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned by encode
+func decodeCursor(s string) (*historyCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c historyCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// historyLimit returns the page size of a filter
+func historyLimit(limit int) int {
+	if limit <= 0 {
+		return defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		return maxHistoryLimit
+	}
+	return limit
+}
+
+// pageQuery applies the time range, cursor and newest-first order shared by
+// the history queries; it fetches one row more than the page to tell whether
+// another page follows
+func pageQuery(query *gorm.DB, column string, filter HistoryFilter) (*gorm.DB, error) {
+	if len(filter.Symbols) > 0 {
+		query = query.Where("symbol IN ?", filter.Symbols)
+	}
+	if filter.Start != nil {
+		query = query.Where(column+" >= ?", *filter.Start)
+	}
+	if filter.End != nil {
+		query = query.Where(column+" <= ?", *filter.End)
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(column+" < ? OR ("+column+" = ? AND id < ?)", cursor.Time, cursor.Time, cursor.ID)
+	}
+	return query.Order(column + " DESC").Order("id DESC").Limit(historyLimit(filter.Limit) + 1), nil
+}
+
+// GetOrderHistory gets a page of a user's orders
+func (s *OrderService) GetOrderHistory(userID uint, filter HistoryFilter) (*OrderPage, error) {
+	query := database.DB.Where("user_id = ?", userID)
+	if filter.Side != "" {
+		query = query.Where("side = ?", filter.Side)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+
+	query, err := pageQuery(query, "create_time", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
+		return nil, err
+	}
+
+	page := &OrderPage{Orders: orders}
+	if limit := historyLimit(filter.Limit); len(orders) > limit {
+		page.Orders = orders[:limit]
+		last := page.Orders[limit-1]
+		page.NextCursor = historyCursor{Time: last.CreateTime, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// GetUserTrades gets a page of a user's fills; Side selects the trades the
+// user bought or sold in
+func (s *OrderService) GetUserTrades(userID uint, filter HistoryFilter) (*TradePage, error) {
+	query := database.DB.Model(&models.Trade{})
+	switch filter.Side {
+	case models.OrderSideBuy:
+		query = query.Where("buyer_id = ?", userID)
+	case models.OrderSideSell:
+		query = query.Where("seller_id = ?", userID)
+	default:
+		query = query.Where("buyer_id = ? OR seller_id = ?", userID, userID)
+	}
+
+	query, err := pageQuery(query, "trade_time", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var trades []models.Trade
+	if err := query.Find(&trades).Error; err != nil {
+		return nil, err
+	}
+
+	page := &TradePage{Trades: trades}
+	if limit := historyLimit(filter.Limit); len(trades) > limit {
+		page.Trades = trades[:limit]
+		last := page.Trades[limit-1]
+		page.NextCursor = historyCursor{Time: last.TradeTime, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// GetHistoricalTrades gets up to limit public trades of a symbol, oldest
+// first, starting at trade fromID, or the latest ones when fromID is empty
+func (s *OrderService) GetHistoricalTrades(symbol, fromID string, limit int) ([]PublicTrade, error) {
+	limit = historyLimit(limit)
+	query := database.DB.Where("symbol = ?", symbol)
+
+	var trades []models.Trade
+	if fromID == "" {
+		if err := query.Order("trade_time DESC").Order("id DESC").Limit(limit).Find(&trades).Error; err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+			trades[i], trades[j] = trades[j], trades[i]
+		}
+		return publicTrades(trades), nil
+	}
+
+	var from models.Trade
+	if err := database.DB.Where("id = ? AND symbol = ?", fromID, symbol).First(&from).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTradeNotFound
+		}
+		return nil, err
+	}
+
+	if err := query.Where("trade_time > ? OR (trade_time = ? AND id >= ?)", from.TradeTime, from.TradeTime, from.ID).
+		Order("trade_time ASC").Order("id ASC").
+		Limit(limit).
+		Find(&trades).Error; err != nil {
+		return nil, err
+	}
+	return publicTrades(trades), nil
+}
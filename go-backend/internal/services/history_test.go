@@ -0,0 +1,249 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var historyBase = time.Date(2025, 11, 2, 12, 0, 0, 0, time.UTC)
+
+func historyOrder(t *testing.T, id string, userID uint, second int) {
+	t.Helper()
+
+	require.NoError(t, database.DB.Create(&models.Order{
+		ID:         id,
+		UserID:     userID,
+		Symbol:     "BTC_USDT",
+		Side:       models.OrderSideBuy,
+		Type:       models.OrderTypeLimit,
+		Price:      decimal.NewFromInt(100),
+		Quantity:   decimal.NewFromInt(1),
+		Status:     models.OrderStatusPending,
+		CreateTime: historyBase.Add(time.Duration(second) * time.Second),
+	}).Error)
+}
+
+func historyTrade(t *testing.T, id string, buyerID, sellerID uint, second int) {
+	t.Helper()
+
+	require.NoError(t, database.DB.Create(&models.Trade{
+		ID:           id,
+		Symbol:       "BTC_USDT",
+		BuyOrderID:   "buy-" + id,
+		SellOrderID:  "sell-" + id,
+		BuyerID:      buyerID,
+		SellerID:     sellerID,
+		Price:        decimal.NewFromInt(100),
+		Quantity:     decimal.NewFromInt(2),
+		Amount:       decimal.NewFromInt(200),
+		BuyerFee:     decimal.RequireFromString("0.002"),
+		BuyerIsMaker: true,
+		TradeTime:    historyBase.Add(time.Duration(second) * time.Second),
+	}).Error)
+}
+
+// orderHistoryIDs pages through a user's whole order history
+func orderHistoryIDs(t *testing.T, service *OrderService, userID uint, limit int) [][]string {
+	t.Helper()
+
+	var pages [][]string
+	filter := HistoryFilter{Limit: limit}
+	for {
+		page, err := service.GetOrderHistory(userID, filter)
+		require.NoError(t, err)
+
+		ids := make([]string, len(page.Orders))
+		for i, order := range page.Orders {
+			ids[i] = order.ID
+		}
+		pages = append(pages, ids)
+
+		if page.NextCursor == "" {
+			return pages
+		}
+		require.Less(t, len(pages), 100, "runaway paging")
+		filter.Cursor = page.NextCursor
+	}
+}
+
+// TestHistoryCursorRoundTrip checks a cursor decodes to what it encodes
+func TestHistoryCursorRoundTrip(t *testing.T) {
+	want := historyCursor{Time: historyBase.Add(1500 * time.Millisecond), ID: "order-1"}
+
+	got, err := decodeCursor(want.encode())
+	require.NoError(t, err)
+	assert.True(t, want.Time.Equal(got.Time))
+	assert.Equal(t, want.ID, got.ID)
+}
+
+// TestOrderHistoryPages checks pages run newest first and stop with an empty
+// cursor, leaving out other users' orders
+func TestOrderHistoryPages(t *testing.T) {
+	useTestDB(t, &models.Order{})
+	service := NewOrderService(nil, nil, nil)
+
+	for i := 1; i <= 5; i++ {
+		historyOrder(t, fmt.Sprintf("o%d", i), 1, i)
+	}
+	historyOrder(t, "other", 2, 3)
+
+	assert.Equal(t, [][]string{{"o5", "o4"}, {"o3", "o2"}, {"o1"}}, orderHistoryIDs(t, service, 1, 2))
+}
+
+// TestOrderHistoryInsertsBetweenPages checks orders placed while paging do
+// not shift or repeat the following pages
+func TestOrderHistoryInsertsBetweenPages(t *testing.T) {
+	useTestDB(t, &models.Order{})
+	service := NewOrderService(nil, nil, nil)
+
+	for i := 1; i <= 4; i++ {
+		historyOrder(t, fmt.Sprintf("o%d", i), 1, i)
+	}
+
+	first, err := service.GetOrderHistory(1, HistoryFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, first.Orders, 2)
+	assert.Equal(t, "o4", first.Orders[0].ID)
+	assert.Equal(t, "o3", first.Orders[1].ID)
+
+	historyOrder(t, "o5", 1, 5)
+	historyOrder(t, "o6", 1, 6)
+
+	second, err := service.GetOrderHistory(1, HistoryFilter{Limit: 2, Cursor: first.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, second.Orders, 2)
+	assert.Equal(t, "o2", second.Orders[0].ID)
+	assert.Equal(t, "o1", second.Orders[1].ID)
+	assert.Empty(t, second.NextCursor)
+}
+
+// TestOrderHistoryEqualTimestamps checks orders created in the same instant
+// are split across pages by ID, each exactly once
+func TestOrderHistoryEqualTimestamps(t *testing.T) {
+	useTestDB(t, &models.Order{})
+	service := NewOrderService(nil, nil, nil)
+
+	for _, id := range []string{"c", "a", "e", "b", "d"} {
+		historyOrder(t, id, 1, 0)
+	}
+
+	assert.Equal(t, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}, orderHistoryIDs(t, service, 1, 2))
+}
+
+// TestHistoryInvalidCursor checks cursors not issued by a history query are
+// rejected
+func TestHistoryInvalidCursor(t *testing.T) {
+	useTestDB(t, &models.Order{}, &models.Trade{})
+	service := NewOrderService(nil, nil, nil)
+
+	for _, cursor := range []string{
+		"not a cursor",
+		base64.RawURLEncoding.EncodeToString([]byte("[1, 2]")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2025-11-02T12:00:00Z"}`)),
+	} {
+		_, err := service.GetOrderHistory(1, HistoryFilter{Cursor: cursor})
+		assert.ErrorIs(t, err, ErrInvalidCursor, "cursor %q", cursor)
+
+		_, err = service.GetUserTrades(1, HistoryFilter{Cursor: cursor})
+		assert.ErrorIs(t, err, ErrInvalidCursor, "cursor %q", cursor)
+	}
+}
+
+// TestUserTradesPages checks a user's fills on either side page by time and
+// ID without picking up other users' trades
+func TestUserTradesPages(t *testing.T) {
+	useTestDB(t, &models.Trade{})
+	service := NewOrderService(nil, nil, nil)
+
+	historyTrade(t, "t1", 1, 2, 1)
+	historyTrade(t, "t2", 3, 1, 2)
+	historyTrade(t, "t3", 1, 3, 2)
+	historyTrade(t, "other", 2, 3, 2)
+	historyTrade(t, "t4", 2, 1, 3)
+
+	var ids []string
+	filter := HistoryFilter{Limit: 2}
+	for {
+		page, err := service.GetUserTrades(1, filter)
+		require.NoError(t, err)
+		for _, trade := range page.Trades {
+			ids = append(ids, trade.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"t4", "t3", "t2", "t1"}, ids)
+
+	page, err := service.GetUserTrades(1, HistoryFilter{Side: models.OrderSideSell})
+	require.NoError(t, err)
+	require.Len(t, page.Trades, 2)
+	assert.Equal(t, "t4", page.Trades[0].ID)
+	assert.Equal(t, "t2", page.Trades[1].ID)
+}
+
+// TestHistoricalTradesFromID checks paging from a trade includes it, runs
+// oldest first, and only exposes the public view
+func TestHistoricalTradesFromID(t *testing.T) {
+	useTestDB(t, &models.Trade{})
+	service := NewOrderService(nil, nil, nil)
+
+	historyTrade(t, "t1", 1, 2, 1)
+	historyTrade(t, "t2", 1, 2, 2)
+	historyTrade(t, "t3", 1, 2, 3)
+	historyTrade(t, "t4", 1, 2, 3)
+	historyTrade(t, "t5", 1, 2, 4)
+
+	tradeIDs := func(trades []PublicTrade) []string {
+		ids := make([]string, len(trades))
+		for i, trade := range trades {
+			ids[i] = trade.ID
+		}
+		return ids
+	}
+
+	trades, err := service.GetHistoricalTrades("BTC_USDT", "t3", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"t3", "t4"}, tradeIDs(trades))
+
+	trades, err = service.GetHistoricalTrades("BTC_USDT", "t4", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"t4", "t5"}, tradeIDs(trades))
+
+	trades, err = service.GetHistoricalTrades("BTC_USDT", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"t4", "t5"}, tradeIDs(trades))
+
+	_, err = service.GetHistoricalTrades("BTC_USDT", "missing", 2)
+	assert.ErrorIs(t, err, ErrTradeNotFound)
+	_, err = service.GetHistoricalTrades("ETH_USDT", "t3", 2)
+	assert.ErrorIs(t, err, ErrTradeNotFound)
+
+	assert.True(t, trades[1].QuoteQuantity.Equal(decimal.NewFromInt(200)))
+	assert.True(t, trades[1].Time.Equal(historyBase.Add(4*time.Second)))
+	assert.True(t, trades[1].BuyerIsMaker)
+
+	data, err := json.Marshal(trades[1])
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{"id", "price", "quantity", "quote_quantity", "time", "buyer_is_maker"}, keys)
+}
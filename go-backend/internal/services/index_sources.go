@@ -0,0 +1,283 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/shopspring/decimal"
+	"golang.org/x/crypto/sha3"
+)
+
+// indexSourceTimeout bounds a request to an external price source
+const indexSourceTimeout = 2 * time.Second
+
+// ErrNoPrice is returned by a price source that has no price for a symbol
+var ErrNoPrice = errors.New("no price")
+
+// Quote is a source's price of a symbol and when it was observed
+type Quote struct {
+	Price decimal.Decimal
+	Time  time.Time
+}
+
+// PriceSource quotes symbol prices for the index price
+type PriceSource interface {
+	Name() string
+	Quote(symbol string) (Quote, error)
+}
+
+// BookMidSource quotes the middle of a symbol's best bid and ask. Traders
+// on our books can move it, so the index never lets it set the median its
+// external sources are checked against.
+type BookMidSource struct {
+	engine *matching.MatchingEngine
+}
+
+// NewBookMidSource creates a price source on the engine's order books
+func NewBookMidSource(engine *matching.MatchingEngine) *BookMidSource {
+	return &BookMidSource{engine: engine}
+}
+
+// Name returns the source name
+func (s *BookMidSource) Name() string {
+	return "book"
+}
+
+// Quote returns the book mid as of now
+func (s *BookMidSource) Quote(symbol string) (Quote, error) {
+	price, err := s.Price(symbol)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: price, Time: time.Now()}, nil
+}
+
+// Price returns the book mid; a book missing either side has none
+func (s *BookMidSource) Price(symbol string) (decimal.Decimal, error) {
+	ob, exists := s.engine.GetOrderBook(symbol)
+	if !exists {
+		return decimal.Zero, ErrNoPrice
+	}
+
+	bid, hasBid := ob.GetBestBid()
+	ask, hasAsk := ob.GetBestAsk()
+	if !hasBid || !hasAsk {
+		return decimal.Zero, ErrNoPrice
+	}
+	return bid.Add(ask).Div(decimal.NewFromInt(2)), nil
+}
+
+// DEXToken is the on-chain token of a currency
+type DEXToken struct {
+	Address  string
+	Decimals int32
+}
+
+// ParseDEXTokens parses a token list of the form
+// "USDT:0xdAC1...:6,WETH:0xC02a...:18"
+func ParseDEXTokens(spec string) (map[string]DEXToken, error) {
+	tokens := make(map[string]DEXToken)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid DEX token %q", entry)
+		}
+		if len(abiAddress(parts[1])) != 20 {
+			return nil, fmt.Errorf("invalid address of DEX token %q", entry)
+		}
+		decimals, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimals of DEX token %q", entry)
+		}
+		tokens[parts[0]] = DEXToken{Address: parts[1], Decimals: int32(decimals)}
+	}
+	return tokens, nil
+}
+
+// getBestQuoteSelector is the selector of
+// DEXAggregator.getBestQuote(address,address,uint256)
+var getBestQuoteSelector = func() []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte("getBestQuote(address,address,uint256)"))
+	return hash.Sum(nil)[:4]
+}()
+
+// DEXPriceSource quotes the DEXAggregator contract's best price for selling
+// one unit of a symbol's base currency, read with eth_call
+type DEXPriceSource struct {
+	rpcURL   string
+	contract string
+	symbols  *SymbolRegistry
+	tokens   map[string]DEXToken // currency -> token
+	client   *http.Client
+}
+
+// NewDEXPriceSource creates a price source on the DEXAggregator at contract
+func NewDEXPriceSource(rpcURL, contract string, symbols *SymbolRegistry, tokens map[string]DEXToken) *DEXPriceSource {
+	return &DEXPriceSource{
+		rpcURL:   rpcURL,
+		contract: contract,
+		symbols:  symbols,
+		tokens:   tokens,
+		client:   &http.Client{Timeout: indexSourceTimeout},
+	}
+}
+
+// Name returns the source name
+func (s *DEXPriceSource) Name() string {
+	return "dex"
+}
+
+// Quote returns the quote of one base unit in quote currency at the latest
+// block, timed when it was read
+func (s *DEXPriceSource) Quote(symbol string) (Quote, error) {
+	info, exists := s.symbols.GetSymbol(symbol)
+	if !exists {
+		return Quote{}, ErrNoPrice
+	}
+	base, hasBase := s.tokens[info.BaseCurrency]
+	quote, hasQuote := s.tokens[info.QuoteCurrency]
+	if !hasBase || !hasQuote {
+		return Quote{}, ErrNoPrice
+	}
+
+	amountIn := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(base.Decimals)), nil)
+	data := append([]byte{}, getBestQuoteSelector...)
+	for _, word := range [][]byte{abiAddress(base.Address), abiAddress(quote.Address), amountIn.Bytes()} {
+		data = append(data, make([]byte, 32-len(word))...)
+		data = append(data, word...)
+	}
+
+	now := time.Now()
+	result, err := s.call(data)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	// The Quote struct holds a dynamic array, so it is returned behind an
+	// offset word: offset, dex, amountOut, ...
+	if len(result) < 96 {
+		return Quote{}, fmt.Errorf("short getBestQuote result of %d bytes", len(result))
+	}
+	amountOut := new(big.Int).SetBytes(result[64:96])
+	if amountOut.Sign() == 0 {
+		return Quote{}, ErrNoPrice
+	}
+	return Quote{Price: decimal.NewFromBigInt(amountOut, -quote.Decimals), Time: now}, nil
+}
+
+// call runs eth_call against the contract at the latest block
+func (s *DEXPriceSource) call(data []byte) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_call",
+		"params": []interface{}{
+			map[string]string{"to": s.contract, "data": "0x" + hex.EncodeToString(data)},
+			"latest",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Post(s.rpcURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, fmt.Errorf("eth_call: %s", reply.Error.Message)
+	}
+	return hex.DecodeString(strings.TrimPrefix(reply.Result, "0x"))
+}
+
+// abiAddress returns the 20 bytes of a hex address
+func abiAddress(address string) []byte {
+	raw, _ := hex.DecodeString(strings.TrimPrefix(address, "0x"))
+	return raw
+}
+
+// HTTPPriceSource is a stand-in for an external price feed: it reads
+// {"price": "...", "time": ms} from a URL with the symbol in place of %s.
+// Without a time the quote is timed when it was read.
+type HTTPPriceSource struct {
+	name        string
+	urlTemplate string
+	client      *http.Client
+}
+
+// NewHTTPPriceSource creates a price source on an HTTP feed
+func NewHTTPPriceSource(name, urlTemplate string) *HTTPPriceSource {
+	return &HTTPPriceSource{
+		name:        name,
+		urlTemplate: urlTemplate,
+		client:      &http.Client{Timeout: indexSourceTimeout},
+	}
+}
+
+// Name returns the source name
+func (s *HTTPPriceSource) Name() string {
+	return s.name
+}
+
+// Quote fetches the feed's price of a symbol
+func (s *HTTPPriceSource) Quote(symbol string) (Quote, error) {
+	now := time.Now()
+	resp, err := s.client.Get(fmt.Sprintf(s.urlTemplate, symbol))
+	if err != nil {
+		return Quote{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Quote{}, ErrNoPrice
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("%s feed returned %s", s.name, resp.Status)
+	}
+
+	var body struct {
+		Price decimal.Decimal `json:"price"`
+		Time  int64           `json:"time"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Quote{}, err
+	}
+	if !body.Price.IsPositive() {
+		return Quote{}, ErrNoPrice
+	}
+
+	quote := Quote{Price: body.Price, Time: now}
+	if body.Time > 0 {
+		quote.Time = time.UnixMilli(body.Time)
+	}
+	return quote, nil
+}
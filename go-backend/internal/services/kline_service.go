@@ -0,0 +1,461 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+// klineCloseDelay is how long after its end a bar waits for late trades
+// before it is closed
+const klineCloseDelay = 2 * time.Second
+
+// klineQueueSize is how many batches of bar changes wait for the publisher
+const klineQueueSize = 10000
+
+// ErrInvalidKlineInterval is returned for an unsupported kline interval
+var ErrInvalidKlineInterval = errors.New("invalid kline interval")
+
+// klineInterval is a supported bar length
+type klineInterval struct {
+	name   string
+	length time.Duration
+}
+
+// klineIntervals lists the bars maintained for every symbol
+var klineIntervals = []klineInterval{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+	{"1h", time.Hour},
+	{"4h", 4 * time.Hour},
+	{"1d", 24 * time.Hour},
+	{"1w", 7 * 24 * time.Hour},
+}
+
+// klineLength returns the length of a named interval
+func klineLength(name string) (time.Duration, bool) {
+	for _, interval := range klineIntervals {
+		if interval.name == name {
+			return interval.length, true
+		}
+	}
+	return 0, false
+}
+
+// klineOpenTime returns the start of the bar containing t. Truncate counts
+// from January 1 of year 1, a Monday, so weekly bars start on Mondays.
+func klineOpenTime(t time.Time, length time.Duration) time.Time {
+	return t.UTC().Truncate(length)
+}
+
+// klineKey identifies the bars of one symbol and interval
+type klineKey struct {
+	symbol   string
+	interval string
+}
+
+// klineBar is the latest bar of a symbol and interval
+type klineBar struct {
+	kline  models.Kline
+	closed bool
+}
+
+// klineEvent is a bar change to persist and publish
+type klineEvent struct {
+	kline  models.Kline
+	closed bool
+	late   *models.Trade // set when a past bar must be rebuilt to include it
+}
+
+// KlineService aggregates trades into OHLCV bars. The latest bar of each
+// symbol and interval is kept in memory; closed bars are persisted to the
+// klines table by a publisher goroutine, so adding a trade never waits for
+// the database. Intervals without trades have no bar.
+type KlineService struct {
+	bars     map[klineKey]*klineBar
+	onUpdate func(kline *models.Kline, closed bool)
+	mutex    sync.Mutex
+	queue    chan []klineEvent
+	queueMu  sync.Mutex // keeps the queue in the order bars changed
+	stopChan chan struct{}
+	done     chan struct{}
+	running  bool
+}
+
+// NewKlineService creates a new kline service
+func NewKlineService() *KlineService {
+	return &KlineService{
+		bars:     make(map[klineKey]*klineBar),
+		queue:    make(chan []klineEvent, klineQueueSize),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// OnUpdate registers a callback run after every bar change, with closed set
+// once the bar is final
+func (s *KlineService) OnUpdate(fn func(kline *models.Kline, closed bool)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onUpdate = fn
+}
+
+// Load rebuilds the bars of the last backfill period from the trades table.
+// Each interval starts at the bar containing now minus backfill, so rebuilt
+// bars are complete and persisting them again is harmless.
+func (s *KlineService) Load(backfill time.Duration) error {
+	from := time.Now().Add(-backfill)
+	since := from
+	for _, interval := range klineIntervals {
+		if start := klineOpenTime(from, interval.length); start.Before(since) {
+			since = start
+		}
+	}
+
+	rows, err := database.DB.Model(&models.Trade{}).
+		Where("trade_time >= ?", since).
+		Order("trade_time ASC, id ASC").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var closed []models.Kline
+	s.mutex.Lock()
+	for rows.Next() {
+		var trade models.Trade
+		if err := database.DB.ScanRows(rows, &trade); err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+
+		for _, interval := range klineIntervals {
+			if trade.TradeTime.Before(klineOpenTime(from, interval.length)) {
+				continue
+			}
+			for _, event := range s.addTrade(interval, &trade) {
+				if event.closed && event.late == nil {
+					closed = append(closed, event.kline)
+				}
+			}
+		}
+	}
+	s.mutex.Unlock()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(closed) == 0 {
+		return nil
+	}
+	return database.DB.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(closed, 500).Error
+}
+
+// Start closes bars once their interval has passed and publishes bar changes
+func (s *KlineService) Start() {
+	s.mutex.Lock()
+	if s.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.running = true
+	s.mutex.Unlock()
+
+	go s.closeLoop()
+	go s.publishLoop()
+}
+
+// Stop stops closing bars, and returns once the queued changes are published
+func (s *KlineService) Stop() {
+	s.mutex.Lock()
+	if !s.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.running = false
+	close(s.stopChan)
+	s.mutex.Unlock()
+
+	<-s.done
+}
+
+// closeLoop closes due bars every second
+func (s *KlineService) closeLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.closeDue(now)
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// closeDue closes the bars that ended more than klineCloseDelay ago
+func (s *KlineService) closeDue(now time.Time) {
+	var events []klineEvent
+
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	s.mutex.Lock()
+	for _, bar := range s.bars {
+		if !bar.closed && now.Sub(bar.kline.CloseTime) > klineCloseDelay {
+			bar.closed = true
+			events = append(events, klineEvent{kline: bar.kline, closed: true})
+		}
+	}
+	s.mutex.Unlock()
+
+	s.enqueue(events)
+}
+
+// AddTrade adds a trade to the bars of every interval of its symbol. The
+// changes are persisted and published by the publisher goroutine.
+func (s *KlineService) AddTrade(trade *models.Trade) {
+	var events []klineEvent
+
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	s.mutex.Lock()
+	for _, interval := range klineIntervals {
+		events = append(events, s.addTrade(interval, trade)...)
+	}
+	s.mutex.Unlock()
+
+	s.enqueue(events)
+}
+
+// enqueue hands bar changes to the publisher; the caller must hold queueMu.
+// When the queue is full, changes to open bars are dropped, as the next
+// change to the bar supersedes them, and closed bars wait for room so none
+// goes unsaved.
+func (s *KlineService) enqueue(events []klineEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	select {
+	case s.queue <- events:
+		return
+	default:
+	}
+
+	var closed []klineEvent
+	for _, event := range events {
+		if event.closed {
+			closed = append(closed, event)
+		}
+	}
+	if len(closed) > 0 {
+		s.queue <- closed
+	}
+}
+
+// publishLoop publishes queued bar changes until the service is stopped,
+// then publishes what is left in the queue
+func (s *KlineService) publishLoop() {
+	defer close(s.done)
+
+	for {
+		select {
+		case events := <-s.queue:
+			s.publish(events)
+		case <-s.stopChan:
+			for {
+				select {
+				case events := <-s.queue:
+					s.publish(events)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// addTrade adds a trade to one interval's bar, closing the previous bar
+// when the trade starts a new one. A late trade for a closed bar corrects
+// it; a trade older than the latest bar has its bar rebuilt from the trades
+// table when published. The caller must hold the lock.
+func (s *KlineService) addTrade(interval klineInterval, trade *models.Trade) []klineEvent {
+	key := klineKey{symbol: trade.Symbol, interval: interval.name}
+	openTime := klineOpenTime(trade.TradeTime, interval.length)
+
+	var events []klineEvent
+	bar := s.bars[key]
+	switch {
+	case bar == nil || openTime.After(bar.kline.OpenTime):
+		if bar != nil && !bar.closed {
+			bar.closed = true
+			events = append(events, klineEvent{kline: bar.kline, closed: true})
+		}
+		bar = &klineBar{kline: models.Kline{
+			Symbol:    trade.Symbol,
+			Interval:  interval.name,
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(interval.length - time.Millisecond),
+		}}
+		s.bars[key] = bar
+	case openTime.Before(bar.kline.OpenTime):
+		// The bar it belongs to is already closed; publish rebuilds it
+		past := models.Kline{
+			Symbol:    trade.Symbol,
+			Interval:  interval.name,
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(interval.length - time.Millisecond),
+		}
+		return []klineEvent{{kline: past, closed: true, late: trade}}
+	}
+
+	addToKline(&bar.kline, trade)
+	return append(events, klineEvent{kline: bar.kline, closed: bar.closed})
+}
+
+// addToKline adds a trade to a bar; the first trade of a bar opens it
+func addToKline(k *models.Kline, trade *models.Trade) {
+	if k.TradeCount == 0 {
+		k.Open, k.High, k.Low = trade.Price, trade.Price, trade.Price
+	}
+	if trade.Price.GreaterThan(k.High) {
+		k.High = trade.Price
+	}
+	if trade.Price.LessThan(k.Low) {
+		k.Low = trade.Price
+	}
+	k.Close = trade.Price
+	k.Volume = k.Volume.Add(trade.Quantity)
+	k.QuoteVolume = k.QuoteVolume.Add(trade.Amount)
+	k.TradeCount++
+}
+
+// rebuildKline recomputes a past bar from the stored trades, adding the late
+// trade in time order when it has not been stored yet
+func rebuildKline(k *models.Kline, late *models.Trade) error {
+	var trades []models.Trade
+	if err := database.DB.
+		Where("symbol = ? AND trade_time >= ? AND trade_time < ?", k.Symbol, k.OpenTime, k.CloseTime.Add(time.Millisecond)).
+		Order("trade_time ASC, id ASC").
+		Find(&trades).Error; err != nil {
+		return err
+	}
+
+	stored := false
+	for i := range trades {
+		if trades[i].ID == late.ID {
+			stored = true
+			break
+		}
+	}
+	if !stored {
+		i := sort.Search(len(trades), func(i int) bool { return trades[i].TradeTime.After(late.TradeTime) })
+		trades = append(trades[:i], append([]models.Trade{*late}, trades[i:]...)...)
+	}
+
+	for i := range trades {
+		addToKline(k, &trades[i])
+	}
+	return nil
+}
+
+// publish persists closed bars and hands every change to the callback
+func (s *KlineService) publish(events []klineEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	onUpdate := s.onUpdate
+	s.mutex.Unlock()
+
+	for i := range events {
+		event := &events[i]
+		if event.late != nil {
+			if err := rebuildKline(&event.kline, event.late); err != nil {
+				fmt.Printf("Error rebuilding %s kline of %s for late trade %s: %v\n", event.kline.Interval, event.kline.Symbol, event.late.ID, err)
+				continue
+			}
+		}
+		if event.closed {
+			if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&event.kline).Error; err != nil {
+				fmt.Printf("Error saving kline: %v\n", err)
+			}
+		}
+		if onUpdate != nil {
+			onUpdate(&event.kline, event.closed)
+		}
+	}
+}
+
+// GetKlines returns up to limit bars of a symbol, oldest first, including
+// the bar still open. With a start time the bars from it are returned,
+// otherwise the latest ones.
+func (s *KlineService) GetKlines(symbol, interval string, start, end *time.Time, limit int) ([]models.Kline, error) {
+	if _, ok := klineLength(interval); !ok {
+		return nil, ErrInvalidKlineInterval
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 500
+	}
+
+	query := database.DB.Where(&models.Kline{Symbol: symbol, Interval: interval})
+	if start != nil {
+		query = query.Where("open_time >= ?", *start)
+	}
+	if end != nil {
+		query = query.Where("open_time <= ?", *end)
+	}
+
+	var klines []models.Kline
+	if start != nil {
+		if err := query.Order("open_time ASC").Limit(limit).Find(&klines).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if err := query.Order("open_time DESC").Limit(limit).Find(&klines).Error; err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(klines)-1; i < j; i, j = i+1, j-1 {
+			klines[i], klines[j] = klines[j], klines[i]
+		}
+	}
+
+	s.mutex.Lock()
+	bar := s.bars[klineKey{symbol: symbol, interval: interval}]
+	if bar == nil || bar.closed {
+		s.mutex.Unlock()
+		return klines, nil
+	}
+	current := bar.kline
+	s.mutex.Unlock()
+
+	if (start != nil && current.OpenTime.Before(*start)) || (end != nil && current.OpenTime.After(*end)) {
+		return klines, nil
+	}
+	if len(klines) > 0 && !current.OpenTime.After(klines[len(klines)-1].OpenTime) {
+		return klines, nil
+	}
+	if len(klines) == limit {
+		if start != nil {
+			return klines, nil
+		}
+		klines = klines[1:]
+	}
+	return append(klines, current), nil
+}
@@ -0,0 +1,307 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// klineStart is a Monday, so every interval's bars start on it
+var klineStart = time.Date(2025, 11, 3, 10, 0, 0, 0, time.UTC)
+
+// klineTrade returns a BTC_USDT trade of qty at price, offset from klineStart
+func klineTrade(id string, offset time.Duration, price, qty string) *models.Trade {
+	p, q := decimal.RequireFromString(price), decimal.RequireFromString(qty)
+	return &models.Trade{ID: id, Symbol: "BTC_USDT", Price: p, Quantity: q, Amount: p.Mul(q), TradeTime: klineStart.Add(offset)}
+}
+
+// storedKline reads a persisted bar
+func storedKline(t *testing.T, interval string, openTime time.Time) models.Kline {
+	t.Helper()
+
+	var kline models.Kline
+	require.NoError(t, database.DB.Where("symbol = ? AND interval = ? AND open_time = ?", "BTC_USDT", interval, openTime).First(&kline).Error)
+	return kline
+}
+
+// assertOHLCV compares a bar's prices, volume and trade count
+func assertOHLCV(t *testing.T, k models.Kline, open, high, low, close, volume string, count int) {
+	t.Helper()
+
+	for name, pair := range map[string][2]decimal.Decimal{
+		"open":   {k.Open, decimal.RequireFromString(open)},
+		"high":   {k.High, decimal.RequireFromString(high)},
+		"low":    {k.Low, decimal.RequireFromString(low)},
+		"close":  {k.Close, decimal.RequireFromString(close)},
+		"volume": {k.Volume, decimal.RequireFromString(volume)},
+	} {
+		assert.True(t, pair[0].Equal(pair[1]), "%s %s %s, want %s", k.Interval, name, pair[0], pair[1])
+	}
+	assert.Equal(t, count, k.TradeCount, "%s trade count", k.Interval)
+}
+
+// flushKlines publishes the queued bar changes, as the publisher goroutine
+// of a started service does
+func flushKlines(s *KlineService) {
+	for {
+		select {
+		case events := <-s.queue:
+			s.publish(events)
+		default:
+			return
+		}
+	}
+}
+
+// TestKlineRollover checks a trade in the next interval closes and persists
+// the previous bar
+func TestKlineRollover(t *testing.T) {
+	useTestDB(t, &models.Trade{}, &models.Kline{})
+
+	service := NewKlineService()
+	var closed []models.Kline
+	service.OnUpdate(func(kline *models.Kline, isClosed bool) {
+		if isClosed {
+			closed = append(closed, *kline)
+		}
+	})
+
+	service.AddTrade(klineTrade("t1", 10*time.Second, "100", "1"))
+	service.AddTrade(klineTrade("t2", 20*time.Second, "105", "2"))
+	service.AddTrade(klineTrade("t3", 50*time.Second, "98", "1"))
+	flushKlines(service)
+	assert.Empty(t, closed)
+
+	service.AddTrade(klineTrade("t4", 65*time.Second, "101", "1"))
+	flushKlines(service)
+	require.Len(t, closed, 1)
+	assert.Equal(t, "1m", closed[0].Interval)
+	assert.Equal(t, klineStart.Add(time.Minute-time.Millisecond), closed[0].CloseTime)
+	assertOHLCV(t, storedKline(t, "1m", klineStart), "100", "105", "98", "98", "4", 3)
+
+	// The 5m bar holds all four trades and stays open
+	klines, err := service.GetKlines("BTC_USDT", "5m", nil, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, klines, 1)
+	assertOHLCV(t, klines[0], "100", "105", "98", "101", "5", 4)
+}
+
+// TestKlineCloseDue checks idle bars close only after klineCloseDelay
+func TestKlineCloseDue(t *testing.T) {
+	useTestDB(t, &models.Trade{}, &models.Kline{})
+
+	service := NewKlineService()
+	service.AddTrade(klineTrade("t1", 10*time.Second, "100", "1"))
+	end := klineStart.Add(time.Minute)
+
+	service.closeDue(end.Add(klineCloseDelay / 2))
+	flushKlines(service)
+	var count int64
+	require.NoError(t, database.DB.Model(&models.Kline{}).Count(&count).Error)
+	assert.Zero(t, count, "closed before the delay")
+
+	service.closeDue(end.Add(klineCloseDelay + time.Second))
+	flushKlines(service)
+	assertOHLCV(t, storedKline(t, "1m", klineStart), "100", "100", "100", "100", "1", 1)
+	require.NoError(t, database.DB.Model(&models.Kline{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count, "only the 1m bar has ended")
+
+	// A closed bar is not closed again
+	var updates int
+	service.OnUpdate(func(*models.Kline, bool) { updates++ })
+	service.closeDue(end.Add(time.Minute))
+	flushKlines(service)
+	assert.Zero(t, updates)
+}
+
+// TestKlineWeeklyOpenTime checks weekly bars start on Monday 00:00 UTC
+func TestKlineWeeklyOpenTime(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	for _, at := range []time.Time{
+		time.Date(2025, 11, 3, 0, 0, 0, 0, time.UTC),    // Monday
+		time.Date(2025, 11, 6, 15, 4, 5, 0, time.UTC),   // Thursday
+		time.Date(2025, 11, 9, 23, 59, 59, 0, time.UTC), // Sunday
+		time.Date(2025, 11, 6, 1, 0, 0, 0, time.FixedZone("UTC+8", 8*3600)),
+	} {
+		open := klineOpenTime(at, week)
+		assert.Equal(t, time.Monday, open.Weekday(), "bar of %s", at)
+		assert.Equal(t, time.Date(2025, 11, 3, 0, 0, 0, 0, time.UTC), open, "bar of %s", at)
+	}
+	assert.Equal(t, time.Date(2025, 11, 10, 0, 0, 0, 0, time.UTC), klineOpenTime(time.Date(2025, 11, 10, 0, 0, 0, 0, time.UTC), week))
+}
+
+// TestKlineLoadBackfill checks rebuilding from the trades table reproduces
+// the bars built live
+func TestKlineLoadBackfill(t *testing.T) {
+	useTestDB(t, &models.Trade{}, &models.Kline{})
+
+	live := NewKlineService()
+	for i := 0; i < 12; i++ {
+		trade := klineTrade(fmt.Sprintf("t%d", i), time.Duration(i)*37*time.Second, fmt.Sprintf("%d", 100+i%5), "1")
+		require.NoError(t, database.DB.Create(trade).Error)
+		live.AddTrade(trade)
+	}
+	flushKlines(live)
+
+	var before []models.Kline
+	require.NoError(t, database.DB.Order("interval, open_time").Find(&before).Error)
+	require.NotEmpty(t, before)
+
+	rebuilt := NewKlineService()
+	require.NoError(t, rebuilt.Load(time.Since(klineStart)+time.Hour))
+
+	var after []models.Kline
+	require.NoError(t, database.DB.Order("interval, open_time").Find(&after).Error)
+	require.Len(t, after, len(before))
+	for i := range before {
+		assert.Equal(t, before[i].OpenTime, after[i].OpenTime)
+		assertOHLCV(t, after[i], before[i].Open.String(), before[i].High.String(), before[i].Low.String(),
+			before[i].Close.String(), before[i].Volume.String(), before[i].TradeCount)
+	}
+
+	for _, interval := range klineIntervals {
+		want, err := live.GetKlines("BTC_USDT", interval.name, nil, nil, 1)
+		require.NoError(t, err)
+		got, err := rebuilt.GetKlines("BTC_USDT", interval.name, nil, nil, 1)
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, want[0].OpenTime, got[0].OpenTime, interval.name)
+		assertOHLCV(t, got[0], want[0].Open.String(), want[0].High.String(), want[0].Low.String(),
+			want[0].Close.String(), want[0].Volume.String(), want[0].TradeCount)
+	}
+}
+
+// TestGetKlinesOpenBar checks the open bar is merged with the stored ones
+// within the limit and time range
+func TestGetKlinesOpenBar(t *testing.T) {
+	useTestDB(t, &models.Trade{}, &models.Kline{})
+
+	service := NewKlineService()
+	for i := 0; i < 4; i++ {
+		service.AddTrade(klineTrade(fmt.Sprintf("t%d", i), time.Duration(i)*time.Minute, fmt.Sprintf("%d", 100+i), "1"))
+	}
+	flushKlines(service)
+	minute := func(i int) time.Time { return klineStart.Add(time.Duration(i) * time.Minute) }
+	openTimes := func(klines []models.Kline) []time.Time {
+		var times []time.Time
+		for _, k := range klines {
+			times = append(times, k.OpenTime)
+		}
+		return times
+	}
+
+	tests := []struct {
+		name       string
+		start, end *time.Time
+		limit      int
+		want       []time.Time
+	}{
+		{"latest with the open bar", nil, nil, 10, []time.Time{minute(0), minute(1), minute(2), minute(3)}},
+		{"limit keeps the newest", nil, nil, 2, []time.Time{minute(2), minute(3)}},
+		{"limit from start keeps the oldest", at(minute(1)), nil, 2, []time.Time{minute(1), minute(2)}},
+		{"start includes the open bar", at(minute(2)), nil, 10, []time.Time{minute(2), minute(3)}},
+		{"end before the open bar", nil, at(minute(1)), 10, []time.Time{minute(0), minute(1)}},
+		{"only the open bar", at(minute(3)), at(minute(3)), 10, []time.Time{minute(3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			klines, err := service.GetKlines("BTC_USDT", "1m", tt.start, tt.end, tt.limit)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, openTimes(klines))
+		})
+	}
+
+	_, err := service.GetKlines("BTC_USDT", "2m", nil, nil, 10)
+	assert.ErrorIs(t, err, ErrInvalidKlineInterval)
+}
+
+// TestKlineLateTrade checks a trade for an already closed bar is merged into
+// the persisted bar instead of being dropped
+func TestKlineLateTrade(t *testing.T) {
+	useTestDB(t, &models.Trade{}, &models.Kline{})
+
+	service := NewKlineService()
+	var corrected *models.Kline
+	service.OnUpdate(func(kline *models.Kline, closed bool) {
+		if closed && kline.OpenTime.Equal(klineStart) {
+			copied := *kline
+			corrected = &copied
+		}
+	})
+
+	for _, trade := range []*models.Trade{
+		klineTrade("t1", 10*time.Second, "100", "1"),
+		klineTrade("t2", 40*time.Second, "102", "1"),
+		klineTrade("t3", 70*time.Second, "103", "1"),
+	} {
+		require.NoError(t, database.DB.Create(trade).Error)
+		service.AddTrade(trade)
+	}
+	flushKlines(service)
+	assertOHLCV(t, storedKline(t, "1m", klineStart), "100", "102", "100", "102", "2", 2)
+
+	// The late trade reaches the service before it is stored
+	service.AddTrade(klineTrade("late", 20*time.Second, "110", "3"))
+	flushKlines(service)
+	assertOHLCV(t, storedKline(t, "1m", klineStart), "100", "110", "100", "102", "5", 3)
+	require.NotNil(t, corrected)
+	assert.Equal(t, 3, corrected.TradeCount)
+
+	// Stored or not, the bar is rebuilt the same way
+	require.NoError(t, database.DB.Create(klineTrade("late", 20*time.Second, "110", "3")).Error)
+	service.AddTrade(klineTrade("late", 20*time.Second, "110", "3"))
+	flushKlines(service)
+	assertOHLCV(t, storedKline(t, "1m", klineStart), "100", "110", "100", "102", "5", 3)
+
+	// The open bar is unaffected
+	klines, err := service.GetKlines("BTC_USDT", "1m", at(klineStart.Add(time.Minute)), nil, 10)
+	require.NoError(t, err)
+	require.Len(t, klines, 1)
+	assertOHLCV(t, klines[0], "103", "103", "103", "103", "1", 1)
+}
+
+// TestKlinePublishedAsync checks adding trades never writes to the database
+// or runs the callback itself, and a started service publishes the changes
+// in order
+func TestKlinePublishedAsync(t *testing.T) {
+	useTestDB(t, &models.Trade{}, &models.Kline{})
+
+	service := NewKlineService()
+	updates := make(chan models.Kline, 100)
+	service.OnUpdate(func(kline *models.Kline, closed bool) {
+		if kline.Interval == "1m" {
+			updates <- *kline
+		}
+	})
+
+	service.AddTrade(klineTrade("t1", 10*time.Second, "100", "1"))
+	service.AddTrade(klineTrade("t2", 65*time.Second, "101", "1"))
+	var count int64
+	require.NoError(t, database.DB.Model(&models.Kline{}).Count(&count).Error)
+	assert.Zero(t, count)
+	assert.Empty(t, updates)
+
+	service.Start()
+	service.Stop()
+	assertOHLCV(t, storedKline(t, "1m", klineStart), "100", "100", "100", "100", "1", 1)
+
+	require.Len(t, updates, 3)
+	for _, openTime := range []time.Time{klineStart, klineStart, klineStart.Add(time.Minute)} {
+		assert.Equal(t, openTime, (<-updates).OpenTime)
+	}
+}
+
+// at returns a pointer to a time, for optional time bounds
+func at(t time.Time) *time.Time {
+	return &t
+}
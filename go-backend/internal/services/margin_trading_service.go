@@ -400,6 +400,23 @@ func (s *MarginTradingService) UpdatePosition(ctx context.Context, positionID ui
 	return s.db.Save(&position).Error
 }
 
+// MarkPositions revalues the open positions of a symbol at its mark price,
+// liquidating or closing those it takes past their liquidation, stop-loss or
+// take-profit price. The last trade price is not used as it is easy to move.
+func (s *MarginTradingService) MarkPositions(ctx context.Context, symbol string, markPrice decimal.Decimal) error {
+	var ids []uint
+	if err := s.db.Model(&MarginPosition{}).Where("symbol = ? AND status = ?", symbol, "open").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := s.UpdatePosition(ctx, id, markPrice); err != nil {
+			fmt.Printf("Error marking position %d: %v\n", id, err)
+		}
+	}
+	return nil
+}
+
 // shouldLiquidate checks if position should be liquidated
 func (s *MarginTradingService) shouldLiquidate(position *MarginPosition) bool {
 	if position.Side == "long" {
@@ -550,6 +567,27 @@ func (s *MarginTradingService) GetUserPositions(ctx context.Context, userID uint
 	return positions, nil
 }
 
+// GetNetPosition returns the user's net open position in a symbol:
+// long quantity minus short quantity
+func (s *MarginTradingService) GetNetPosition(ctx context.Context, userID uint, symbol string) (decimal.Decimal, error) {
+	var positions []MarginPosition
+	if err := s.db.Where("user_id = ? AND symbol = ? AND status = ?", userID, symbol, "open").
+		Find(&positions).Error; err != nil {
+		return decimal.Zero, err
+	}
+
+	net := decimal.Zero
+	for _, position := range positions {
+		if position.Side == "short" {
+			net = net.Sub(position.Quantity)
+		} else {
+			net = net.Add(position.Quantity)
+		}
+	}
+
+	return net, nil
+}
+
 // GetUserLoans gets all loans for a user
 func (s *MarginTradingService) GetUserLoans(ctx context.Context, userID uint, status string) ([]MarginLoan, error) {
 	var loans []MarginLoan
@@ -0,0 +1,344 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultIndexMaxAge is how old a source's quote may be and still count
+	defaultIndexMaxAge = 10 * time.Second
+	// defaultIndexMaxDeviation is how far, as a fraction of the median, a
+	// source's quote may stray from the others and still count
+	defaultIndexMaxDeviation = "0.05"
+)
+
+// weightedSource is a price source and its weight in the index
+type weightedSource struct {
+	source PriceSource
+	weight decimal.Decimal
+}
+
+// MarkPriceService computes an index price per symbol as the weighted
+// average of its price sources, and a mark price as the index plus the
+// exponentially smoothed basis of the book mid over the index. Stale quotes
+// and quotes too far from the median are left out and the other weights
+// scaled up. While any external source quotes a symbol, the median is theirs
+// alone, so a book mid pushed away from them drops out of the index. Changed
+// prices are persisted, fed to the engine's mark and index triggers and
+// handed to the listeners.
+//
+// A symbol no source quotes gets no price at all, and mark and index
+// triggers and the liquidations driven by the listeners pause until a
+// source quotes again.
+type MarkPriceService struct {
+	engine       *matching.MatchingEngine
+	symbols      *SymbolRegistry
+	sources      []weightedSource
+	smoothing    decimal.Decimal // weight of the newest basis sample, in (0, 1]
+	maxAge       time.Duration
+	maxDeviation decimal.Decimal // also caps the basis, as a fraction of the index
+	prices       map[string]*models.MarkPrice
+	unpriced     map[string]bool
+	listeners    []func(price *models.MarkPrice)
+	mutex        sync.RWMutex
+	stopChan     chan struct{}
+	running      bool
+}
+
+// NewMarkPriceService creates a new mark price service
+func NewMarkPriceService(engine *matching.MatchingEngine, symbols *SymbolRegistry, smoothing decimal.Decimal) *MarkPriceService {
+	if !smoothing.IsPositive() || smoothing.GreaterThan(decimal.NewFromInt(1)) {
+		smoothing = decimal.NewFromInt(1)
+	}
+	return &MarkPriceService{
+		engine:       engine,
+		symbols:      symbols,
+		smoothing:    smoothing,
+		maxAge:       defaultIndexMaxAge,
+		maxDeviation: decimal.RequireFromString(defaultIndexMaxDeviation),
+		prices:       make(map[string]*models.MarkPrice),
+		unpriced:     make(map[string]bool),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// SetQuoteLimits sets how old a source's quote may be and how far it may
+// stray from the median of the sources; non-positive values keep the defaults
+func (s *MarkPriceService) SetQuoteLimits(maxAge time.Duration, maxDeviation decimal.Decimal) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if maxAge > 0 {
+		s.maxAge = maxAge
+	}
+	if maxDeviation.IsPositive() {
+		s.maxDeviation = maxDeviation
+	}
+}
+
+// AddSource adds a price source to the index; sources without a positive
+// weight are ignored
+func (s *MarkPriceService) AddSource(source PriceSource, weight decimal.Decimal) {
+	if !weight.IsPositive() {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sources = append(s.sources, weightedSource{source: source, weight: weight})
+}
+
+// OnUpdate registers a function called with every changed mark price
+func (s *MarkPriceService) OnUpdate(fn func(price *models.MarkPrice)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Load reads the persisted prices so the basis keeps its smoothing across
+// restarts
+func (s *MarkPriceService) Load() error {
+	var rows []models.MarkPrice
+	if err := database.DB.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range rows {
+		s.prices[rows[i].Symbol] = &rows[i]
+	}
+	return nil
+}
+
+// Start recomputes the prices of every symbol each interval
+func (s *MarkPriceService) Start(interval time.Duration) {
+	s.mutex.Lock()
+	if s.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.running = true
+	s.mutex.Unlock()
+
+	go s.updateLoop(interval)
+}
+
+// Stop stops the price updates
+func (s *MarkPriceService) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.running {
+		return
+	}
+
+	s.running = false
+	close(s.stopChan)
+}
+
+// updateLoop recomputes the prices every interval
+func (s *MarkPriceService) updateLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.UpdatePrices()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// UpdatePrices recomputes the index and mark price of every symbol
+func (s *MarkPriceService) UpdatePrices() {
+	for _, info := range s.symbols.GetSymbols() {
+		price, changed := s.updatePrice(info)
+		if !changed {
+			continue
+		}
+
+		if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(price).Error; err != nil {
+			fmt.Printf("Error saving mark price of %s: %v\n", info.Symbol, err)
+		}
+		if err := s.engine.UpdateTriggerPrice(info.Symbol, models.TriggerPriceIndex, price.IndexPrice); err != nil {
+			fmt.Printf("Error feeding index price of %s: %v\n", info.Symbol, err)
+		}
+		if err := s.engine.UpdateTriggerPrice(info.Symbol, models.TriggerPriceMark, price.MarkPrice); err != nil {
+			fmt.Printf("Error feeding mark price of %s: %v\n", info.Symbol, err)
+		}
+
+		s.mutex.RLock()
+		listeners := s.listeners
+		s.mutex.RUnlock()
+		for _, fn := range listeners {
+			fn(price)
+		}
+	}
+}
+
+// updatePrice computes a symbol's prices, rounded to its tick size, and
+// returns a copy with whether they changed
+func (s *MarkPriceService) updatePrice(info *SymbolInfo) (*models.MarkPrice, bool) {
+	now := time.Now()
+	index, ok := s.indexPrice(info.Symbol, now)
+
+	s.mutex.Lock()
+	wasUnpriced := s.unpriced[info.Symbol]
+	s.unpriced[info.Symbol] = !ok
+	maxDeviation := s.maxDeviation
+	s.mutex.Unlock()
+
+	if !ok {
+		if !wasUnpriced {
+			fmt.Printf("No index price for %s: mark price frozen, triggers and liquidations paused\n", info.Symbol)
+		}
+		return nil, false
+	}
+	mid, err := NewBookMidSource(s.engine).Price(info.Symbol)
+	hasMid := err == nil
+
+	places := int32(8)
+	if info.Filters != nil && info.Filters.TickSize.IsPositive() {
+		places = -info.Filters.TickSize.Exponent()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, exists := s.prices[info.Symbol]
+	basis := decimal.Zero
+	if exists {
+		basis = previous.Basis
+	}
+	if hasMid {
+		// A book pushed far from the index only moves the mark by the cap
+		limit := index.Mul(maxDeviation)
+		sample := decimal.Min(decimal.Max(mid.Sub(index), limit.Neg()), limit)
+		if exists {
+			basis = basis.Add(s.smoothing.Mul(sample.Sub(basis)))
+		} else {
+			basis = sample
+		}
+	}
+
+	price := &models.MarkPrice{
+		Symbol:     info.Symbol,
+		IndexPrice: index.Round(places),
+		MarkPrice:  index.Add(basis).Round(places),
+		Basis:      basis,
+		UpdateTime: now,
+	}
+	if !price.MarkPrice.IsPositive() {
+		return nil, false
+	}
+	s.prices[info.Symbol] = price
+
+	changed := !exists || !previous.IndexPrice.Equal(price.IndexPrice) || !previous.MarkPrice.Equal(price.MarkPrice)
+	copied := *price
+	return &copied, changed
+}
+
+// indexPrice returns the weighted average of the sources quoting a symbol,
+// leaving out quotes older than the max age and quotes deviating from the
+// median by more than the max deviation. The median is that of the external
+// quotes when there are any.
+func (s *MarkPriceService) indexPrice(symbol string, now time.Time) (decimal.Decimal, bool) {
+	s.mutex.RLock()
+	sources, maxAge, maxDeviation := s.sources, s.maxAge, s.maxDeviation
+	s.mutex.RUnlock()
+
+	type sourceQuote struct {
+		source weightedSource
+		price  decimal.Decimal
+	}
+	var quotes []sourceQuote
+	for _, ws := range sources {
+		quote, err := ws.source.Quote(symbol)
+		if err != nil {
+			if !errors.Is(err, ErrNoPrice) {
+				fmt.Printf("Error getting %s price of %s: %v\n", ws.source.Name(), symbol, err)
+			}
+			continue
+		}
+		if !quote.Price.IsPositive() || now.Sub(quote.Time) > maxAge {
+			continue
+		}
+		quotes = append(quotes, sourceQuote{source: ws, price: quote.Price})
+	}
+	if len(quotes) == 0 {
+		return decimal.Zero, false
+	}
+
+	var prices, external []decimal.Decimal
+	for _, q := range quotes {
+		prices = append(prices, q.price)
+		if _, internal := q.source.source.(*BookMidSource); !internal {
+			external = append(external, q.price)
+		}
+	}
+	if len(external) > 0 {
+		prices = external
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].LessThan(prices[j]) })
+	median := prices[len(prices)/2]
+	if len(prices)%2 == 0 {
+		median = prices[len(prices)/2-1].Add(median).Div(decimal.NewFromInt(2))
+	}
+
+	sum, weights := decimal.Zero, decimal.Zero
+	for _, q := range quotes {
+		if q.price.Sub(median).Abs().GreaterThan(median.Mul(maxDeviation)) {
+			fmt.Printf("Ignoring %s price %s of %s: more than %s off the median %s\n",
+				q.source.source.Name(), q.price, symbol, maxDeviation, median)
+			continue
+		}
+		sum = sum.Add(q.price.Mul(q.source.weight))
+		weights = weights.Add(q.source.weight)
+	}
+	if !weights.IsPositive() {
+		return decimal.Zero, false
+	}
+	return sum.Div(weights), true
+}
+
+// GetMarkPrice returns the latest prices of a symbol
+func (s *MarkPriceService) GetMarkPrice(symbol string) (models.MarkPrice, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	price, exists := s.prices[symbol]
+	if !exists {
+		return models.MarkPrice{}, false
+	}
+	return *price, true
+}
+
+// GetMarkPrices returns the latest prices of every symbol, sorted by symbol
+func (s *MarkPriceService) GetMarkPrices() []models.MarkPrice {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	prices := make([]models.MarkPrice, 0, len(s.prices))
+	for _, price := range s.prices {
+		prices = append(prices, *price)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Symbol < prices[j].Symbol })
+	return prices
+}
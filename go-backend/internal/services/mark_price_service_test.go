@@ -0,0 +1,310 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource quotes a fixed BTC_USDT price, or fails with err
+type fakeSource struct {
+	name  string
+	price decimal.Decimal
+	time  time.Time
+	err   error
+}
+
+func (s *fakeSource) Name() string {
+	return s.name
+}
+
+func (s *fakeSource) Quote(symbol string) (Quote, error) {
+	if s.err != nil {
+		return Quote{}, s.err
+	}
+	if symbol != "BTC_USDT" {
+		return Quote{}, ErrNoPrice
+	}
+	quoted := s.time
+	if quoted.IsZero() {
+		quoted = time.Now()
+	}
+	return Quote{Price: s.price, Time: quoted}, nil
+}
+
+func quoteSource(name, price string) *fakeSource {
+	return &fakeSource{name: name, price: decimal.RequireFromString(price)}
+}
+
+// setupMarkPrices creates a mark price service on a BTC_USDT engine with a
+// 0.01 tick size and no price sources
+func setupMarkPrices(t *testing.T, smoothing string, tables ...interface{}) (*MarkPriceService, *matching.MatchingEngine) {
+	t.Helper()
+
+	db := useTestDB(t, append([]interface{}{&models.TradingPair{}, &models.MarkPrice{}}, tables...)...)
+	require.NoError(t, db.Create(&models.TradingPair{
+		Symbol: "BTC_USDT", BaseCurrency: "BTC", QuoteCurrency: "USDT",
+		PricePrecision: 2, QuantityPrecision: 4, MaxQuantity: decimal.NewFromInt(1000), IsActive: true,
+	}).Error)
+
+	engine := matching.NewMatchingEngine()
+	go func() {
+		for range engine.GetTradeChan() {
+		}
+	}()
+	t.Cleanup(engine.Stop)
+
+	registry := NewSymbolRegistry(engine)
+	require.NoError(t, registry.Load())
+
+	return NewMarkPriceService(engine, registry, decimal.RequireFromString(smoothing)), engine
+}
+
+// setBook rests a bid and an ask on the BTC_USDT book
+func setBook(t *testing.T, engine *matching.MatchingEngine, bid, ask string) {
+	t.Helper()
+
+	for i, level := range []struct {
+		side  models.OrderSide
+		price string
+	}{{models.OrderSideBuy, bid}, {models.OrderSideSell, ask}} {
+		_, err := engine.Execute(&models.Order{
+			ID:          fmt.Sprintf("book-%s-%d", level.price, i),
+			UserID:      uint(i + 1),
+			Symbol:      "BTC_USDT",
+			Side:        level.side,
+			Type:        models.OrderTypeLimit,
+			Price:       decimal.RequireFromString(level.price),
+			Quantity:    decimal.NewFromInt(1),
+			TimeInForce: models.TimeInForceGTC,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func assertMarkPrice(t *testing.T, service *MarkPriceService, index, mark string) {
+	t.Helper()
+
+	price, exists := service.GetMarkPrice("BTC_USDT")
+	require.True(t, exists)
+	assert.True(t, price.IndexPrice.Equal(decimal.RequireFromString(index)), "index %s, want %s", price.IndexPrice, index)
+	assert.True(t, price.MarkPrice.Equal(decimal.RequireFromString(mark)), "mark %s, want %s", price.MarkPrice, mark)
+}
+
+// TestIndexPriceMissingSource checks sources without a price are left out
+// and the other weights scaled up
+func TestIndexPriceMissingSource(t *testing.T) {
+	service, _ := setupMarkPrices(t, "1")
+	service.AddSource(quoteSource("a", "100"), decimal.NewFromInt(1))
+	service.AddSource(quoteSource("b", "104"), decimal.NewFromInt(3))
+	service.AddSource(&fakeSource{name: "missing", err: ErrNoPrice}, decimal.NewFromInt(2))
+	service.AddSource(&fakeSource{name: "down", err: errors.New("connection refused")}, decimal.NewFromInt(2))
+
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "103", "103")
+}
+
+// TestIndexPriceStaleAndOutliers checks stale quotes and quotes far from the
+// median of the sources do not count
+func TestIndexPriceStaleAndOutliers(t *testing.T) {
+	service, _ := setupMarkPrices(t, "1")
+	service.AddSource(quoteSource("a", "100"), decimal.NewFromInt(1))
+	service.AddSource(quoteSource("b", "101"), decimal.NewFromInt(1))
+	service.AddSource(&fakeSource{name: "stale", price: decimal.NewFromInt(102), time: time.Now().Add(-time.Minute)}, decimal.NewFromInt(2))
+	service.AddSource(quoteSource("outlier", "150"), decimal.NewFromInt(1))
+
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "100.5", "100.5")
+
+	// A wider limit lets the stale quote back in, but not the outlier
+	service.SetQuoteLimits(2*time.Minute, decimal.Zero)
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "101.25", "101.25")
+}
+
+// TestMarkPriceWithoutIndex checks a book that is not an index source never
+// sets a price, and a price whose sources all go stale is no longer updated
+func TestMarkPriceWithoutIndex(t *testing.T) {
+	service, engine := setupMarkPrices(t, "1")
+	setBook(t, engine, "99", "101")
+
+	var updates []models.MarkPrice
+	service.OnUpdate(func(price *models.MarkPrice) { updates = append(updates, *price) })
+
+	service.UpdatePrices()
+	_, exists := service.GetMarkPrice("BTC_USDT")
+	assert.False(t, exists)
+	assert.Empty(t, updates)
+
+	var stored int64
+	require.NoError(t, database.DB.Model(&models.MarkPrice{}).Count(&stored).Error)
+	assert.Zero(t, stored)
+
+	source := quoteSource("feed", "100")
+	service.AddSource(source, decimal.NewFromInt(1))
+	service.UpdatePrices()
+	require.Len(t, updates, 1)
+
+	source.price, source.time = decimal.NewFromInt(90), time.Now().Add(-time.Hour)
+	setBook(t, engine, "79", "81")
+	service.UpdatePrices()
+	assert.Len(t, updates, 1)
+	assertMarkPrice(t, service, "100", "100")
+}
+
+// TestIndexPriceBookMid checks the book mid counts in the index while it
+// stays near the external sources, cannot move their median, and is the
+// index alone once they go stale
+func TestIndexPriceBookMid(t *testing.T) {
+	service, engine := setupMarkPrices(t, "1")
+	service.AddSource(NewBookMidSource(engine), decimal.NewFromInt(1))
+	setBook(t, engine, "99", "101")
+
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "100", "100")
+
+	// Index (100 + 102) / 2, plus the basis of the mid over it
+	feed := quoteSource("feed", "102")
+	service.AddSource(feed, decimal.NewFromInt(1))
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "101", "100")
+
+	// The bid at 149 takes the ask at 101: a mid of 125 is far off the feed
+	// and left out, and its basis capped at 5% of the index
+	setBook(t, engine, "149", "151")
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "102", "107.1")
+
+	feed.time = time.Now().Add(-time.Hour)
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "125", "125")
+}
+
+// TestMarkPriceBasisSmoothing checks the basis of the book mid over the index
+// is smoothed and capped at the max deviation
+func TestMarkPriceBasisSmoothing(t *testing.T) {
+	service, engine := setupMarkPrices(t, "0.5")
+	source := quoteSource("feed", "100")
+	service.AddSource(source, decimal.NewFromInt(1))
+	setBook(t, engine, "101", "103")
+
+	// The first sample is taken whole
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "100", "102")
+
+	// Basis 2 + 0.5 * (1 - 2)
+	source.price = decimal.NewFromInt(101)
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "101", "102.5")
+
+	// A book pushed far up samples a basis of 5% of the index at most:
+	// 1.5 + 0.5 * (5.05 - 1.5)
+	setBook(t, engine, "199", "201")
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "101", "104.28")
+}
+
+// TestMarkPriceTickRounding checks prices are rounded to the symbol's tick size
+func TestMarkPriceTickRounding(t *testing.T) {
+	service, engine := setupMarkPrices(t, "1")
+	service.AddSource(quoteSource("a", "100"), decimal.NewFromInt(1))
+	service.AddSource(quoteSource("b", "100.01"), decimal.NewFromInt(2))
+
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "100.01", "100.01")
+
+	setBook(t, engine, "100.01", "100.02")
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "100.01", "100.02")
+}
+
+// TestMarkPositionsLiquidation checks margin positions are liquidated when
+// the mark price, not the book, reaches their liquidation price
+func TestMarkPositionsLiquidation(t *testing.T) {
+	service, engine := setupMarkPrices(t, "1", &MarginAccount{}, &MarginPosition{})
+	margin := NewMarginTradingService(nil, database.DB)
+	service.OnUpdate(func(price *models.MarkPrice) {
+		require.NoError(t, margin.MarkPositions(context.Background(), price.Symbol, price.MarkPrice))
+	})
+
+	require.NoError(t, database.DB.Create(&MarginAccount{UserID: 7, Collateral: decimal.NewFromInt(1000)}).Error)
+	position := &MarginPosition{
+		UserID: 7, Symbol: "BTC_USDT", Side: "long", Status: "open",
+		EntryPrice: decimal.NewFromInt(100), Quantity: decimal.NewFromInt(2), Leverage: 10,
+		Margin: decimal.NewFromInt(20), LiquidationPrice: decimal.NewFromInt(95),
+	}
+	require.NoError(t, database.DB.Create(position).Error)
+
+	// The book trades far below the liquidation price, the index does not
+	service.AddSource(quoteSource("feed", "96"), decimal.NewFromInt(1))
+	setBook(t, engine, "80", "80.02")
+	service.SetQuoteLimits(0, decimal.RequireFromString("0.01"))
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "96", "95.04")
+
+	var stored MarginPosition
+	require.NoError(t, database.DB.First(&stored, position.ID).Error)
+	assert.Equal(t, "open", stored.Status)
+	assert.True(t, stored.CurrentPrice.Equal(decimal.RequireFromString("95.04")), "current price %s", stored.CurrentPrice)
+
+	service.SetQuoteLimits(0, decimal.RequireFromString("0.02"))
+	service.UpdatePrices()
+	assertMarkPrice(t, service, "96", "94.08")
+
+	require.NoError(t, database.DB.First(&stored, position.ID).Error)
+	assert.Equal(t, "liquidated", stored.Status)
+	assert.True(t, stored.RealizedPnL.Equal(decimal.NewFromInt(-10)), "realized pnl %s", stored.RealizedPnL)
+
+	var account MarginAccount
+	require.NoError(t, database.DB.Where("user_id = ?", 7).First(&account).Error)
+	assert.True(t, account.Collateral.Equal(decimal.NewFromInt(980)), "collateral %s", account.Collateral)
+}
+
+// TestExerciseOptionAtMark checks exercises settle at the mark price and are
+// refused without one
+func TestExerciseOptionAtMark(t *testing.T) {
+	service, _ := setupMarkPrices(t, "1", &OptionContract{}, &OptionPosition{})
+	options := NewOptionsTradingService(database.DB)
+	options.SetMarkPrices(service)
+	service.OnUpdate(func(price *models.MarkPrice) {
+		require.NoError(t, options.MarkContracts(context.Background(), price.Symbol, price.MarkPrice))
+	})
+	ctx := context.Background()
+
+	contract, err := options.CreateOptionContract(ctx, "BTC_USDT", "call",
+		decimal.NewFromInt(100), decimal.NewFromInt(2),
+		decimal.NewFromInt(10), time.Now().Add(24*time.Hour),
+		decimal.NewFromInt(100))
+	require.NoError(t, err)
+	position, err := options.BuyOption(ctx, 1, contract.ID, decimal.NewFromInt(3))
+	require.NoError(t, err)
+
+	assert.Error(t, options.ExerciseOption(ctx, position.ID))
+
+	service.AddSource(quoteSource("feed", "110"), decimal.NewFromInt(1))
+	service.UpdatePrices()
+
+	var marked OptionContract
+	require.NoError(t, database.DB.First(&marked, contract.ID).Error)
+	assert.True(t, marked.UnderlyingPrice.Equal(decimal.NewFromInt(110)), "underlying price %s", marked.UnderlyingPrice)
+
+	require.NoError(t, options.ExerciseOption(ctx, position.ID))
+	var exercised OptionPosition
+	require.NoError(t, database.DB.First(&exercised, position.ID).Error)
+	assert.Equal(t, "exercised", exercised.Status)
+	// (110 - 100) * 3 - 2 * 3
+	assert.True(t, exercised.RealizedPnL.Equal(decimal.NewFromInt(24)), "realized pnl %s", exercised.RealizedPnL)
+}
@@ -10,10 +10,19 @@ import (
 	"sync"
 	"time"
 
+	"github.com/easitradecoins/backend/internal/models"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
+// maxSettlementMarkAge is how old a mark price may be to settle an exercise
+const maxSettlementMarkAge = time.Minute
+
+// MarkPriceProvider provides the latest mark price of a symbol
+type MarkPriceProvider interface {
+	GetMarkPrice(symbol string) (models.MarkPrice, bool)
+}
+
 // OptionContract represents an option contract
 // 期权合约
 type OptionContract struct {
@@ -49,10 +58,12 @@ type OptionPosition struct {
 	UpdateTime      time.Time       `json:"update_time"`
 }
 
-// OptionsTradingService manages options trading
+// OptionsTradingService manages options trading. Exercises settle at the
+// underlying's mark price, never at a price chosen by the caller.
 type OptionsTradingService struct {
 	mutex sync.RWMutex
 	db    *gorm.DB
+	marks MarkPriceProvider
 }
 
 // NewOptionsTradingService creates a new options trading service
@@ -62,6 +73,25 @@ func NewOptionsTradingService(db *gorm.DB) *OptionsTradingService {
 	}
 }
 
+// SetMarkPrices sets the provider of the underlying prices exercises settle at
+func (s *OptionsTradingService) SetMarkPrices(marks MarkPriceProvider) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.marks = marks
+}
+
+// settlementPrice returns the fresh mark price of an underlying
+func (s *OptionsTradingService) settlementPrice(symbol string) (decimal.Decimal, error) {
+	if s.marks == nil {
+		return decimal.Zero, errors.New("no mark price for settlement")
+	}
+	mark, exists := s.marks.GetMarkPrice(symbol)
+	if !exists || time.Since(mark.UpdateTime) > maxSettlementMarkAge {
+		return decimal.Zero, errors.New("no mark price for settlement")
+	}
+	return mark.MarkPrice, nil
+}
+
 // CreateOptionContract creates a new option contract
 func (s *OptionsTradingService) CreateOptionContract(
 	ctx context.Context,
@@ -188,17 +218,16 @@ func (s *OptionsTradingService) SellOption(
 	return position, nil
 }
 
-// ExerciseOption exercises an option position
+// ExerciseOption exercises an option position at the underlying's mark price
 func (s *OptionsTradingService) ExerciseOption(
 	ctx context.Context,
 	positionID uint,
-	currentUnderlyingPrice decimal.Decimal,
 ) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	var position OptionPosition
-	if err := s.db.Preload("Contract").First(&position, positionID).Error; err != nil {
+	if err := s.db.First(&position, positionID).Error; err != nil {
 		return errors.New("position not found")
 	}
 
@@ -215,6 +244,11 @@ func (s *OptionsTradingService) ExerciseOption(
 		return errors.New("contract has expired")
 	}
 
+	currentUnderlyingPrice, err := s.settlementPrice(contract.Symbol)
+	if err != nil {
+		return err
+	}
+
 	// Calculate exercise value
 	var exerciseValue decimal.Decimal
 
@@ -328,6 +362,13 @@ func (s *OptionsTradingService) UpdateContractPremium(
 	return s.db.Save(&contract).Error
 }
 
+// MarkContracts updates the underlying price of a symbol's active contracts
+func (s *OptionsTradingService) MarkContracts(ctx context.Context, symbol string, markPrice decimal.Decimal) error {
+	return s.db.Model(&OptionContract{}).
+		Where("symbol = ? AND status = ?", symbol, "active").
+		Updates(map[string]interface{}{"underlying_price": markPrice, "update_time": time.Now()}).Error
+}
+
 // ExpireContracts expires all contracts past their expiry time
 func (s *OptionsTradingService) ExpireContracts(ctx context.Context) error {
 	var contracts []OptionContract
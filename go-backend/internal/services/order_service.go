@@ -7,19 +7,38 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/easitradecoins/backend/internal/database"
 	"github.com/easitradecoins/backend/internal/matching"
 	"github.com/easitradecoins/backend/internal/models"
 	"github.com/easitradecoins/backend/internal/security"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
+// PositionProvider reports a user's net position in a symbol: positive for
+// long, negative for short. It backs reduce-only validation.
+type PositionProvider interface {
+	GetNetPosition(ctx context.Context, userID uint, symbol string) (decimal.Decimal, error)
+}
+
+// conditionalOrderTypes are the order types held in the engine's trigger
+// book until their trigger price is reached
+var conditionalOrderTypes = []models.OrderType{
+	models.OrderTypeStopLoss,
+	models.OrderTypeTakeProfit,
+	models.OrderTypeStopLimit,
+	models.OrderTypeTrailingStop,
+}
+
 // OrderService handles order-related operations
 type OrderService struct {
 	engine       *matching.MatchingEngine
 	assetService *AssetService
 	riskManager  *security.RiskManager
+	positions    PositionProvider
 }
 
 // NewOrderService creates a new order service
@@ -31,16 +50,52 @@ func NewOrderService(engine *matching.MatchingEngine, assetService *AssetService
 	}
 }
 
+// SetPositionProvider sets the source of positions used for reduce-only orders
+func (s *OrderService) SetPositionProvider(positions PositionProvider) {
+	s.positions = positions
+}
+
 // CreateOrder creates a new order
 func (s *OrderService) CreateOrder(order *models.Order) (*models.Order, []*models.Trade, error) {
 	var trades []*models.Trade
 
+	if err := s.validateTimeInForce(order); err != nil {
+		return nil, nil, err
+	}
+
 	// Get user for risk validation
 	var user models.User
 	if err := database.DB.First(&user, order.UserID).Error; err != nil {
 		return nil, nil, errors.New("user not found")
 	}
 
+	// Pegged bids move with the book, so their funds are frozen at the cap
+	if order.PegType != "" && order.Side == models.OrderSideBuy && order.PegLimit == nil {
+		return nil, nil, errors.New("pegged buy orders need a peg limit")
+	}
+
+	// Market buys spend at most a quote budget, so they freeze exactly what
+	// they can spend. Buys sized in base currency are budgeted at what the
+	// quantity costs in the book now, and fill less if it moves against them.
+	if order.Type == models.OrderTypeMarket && order.Side == models.OrderSideBuy && order.QuoteOrderQty == nil {
+		cost := s.engine.MarketBuyCost(order.Symbol, order.Quantity)
+		if !cost.IsPositive() {
+			return nil, nil, errors.New("insufficient liquidity")
+		}
+		order.QuoteOrderQty = &cost
+	}
+
+	// Conditional buys that place a market order cannot be costed until they
+	// trigger, so they freeze a quote budget given up front
+	if matching.SpendsQuoteBudget(order) && order.QuoteOrderQty == nil {
+		return nil, nil, errors.New("conditional market buy orders need a quote order quantity")
+	}
+
+	// Orders without their own mode use the account's self-trade prevention
+	if order.STPMode == "" {
+		order.STPMode = user.STPMode
+	}
+
 	// Risk validation - validate order before processing
 	if s.riskManager != nil {
 		ctx := context.Background()
@@ -49,6 +104,13 @@ func (s *OrderService) CreateOrder(order *models.Order) (*models.Order, []*model
 		}
 	}
 
+	// Reduce-only orders are capped to what is left of the position
+	if order.ReduceOnly {
+		if err := s.validateReduceOnly(order); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Use database transaction for entire order creation process
 	err := database.DB.Transaction(func(tx *gorm.DB) error {
 		// Validate user has sufficient balance
@@ -62,33 +124,32 @@ func (s *OrderService) CreateOrder(order *models.Order) (*models.Order, []*model
 		}
 
 		// Process order in matching engine
-		matchedTrades, err := s.engine.ProcessOrder(order)
+		frozen := *order
+		report, err := s.engine.Execute(order)
 		if err != nil {
 			return err
 		}
-		trades = matchedTrades
+		trades = report.Trades
+
+		// Repricing and self-trade decrements can shrink what the order needs
+		if err := s.releaseExcessFrozenWithTx(tx, &frozen, order); err != nil {
+			return err
+		}
 
 		// Save order to database
 		if err := tx.Create(order).Error; err != nil {
 			return err
 		}
 
-		// Save trades to database and check for self-trading
-		for _, trade := range trades {
-			// Check for self-trading before saving
-			if s.riskManager != nil {
-				isSelfTrading := s.riskManager.DetectSelfTrading(trade.BuyerID, trade.SellerID)
-				if isSelfTrading {
-					return errors.New("self-trading detected: buyer and seller are the same")
-				}
-			}
-
-			if err := tx.Create(trade).Error; err != nil {
-				return err // Fail entire transaction if trade save fails
-			}
+		// Persist resting orders and trades and settle the fills
+		if err := s.settleReportWithTx(tx, report); err != nil {
+			return err
+		}
 
-			// Update user assets based on trades within transaction
-			if err := s.processTradeSettlementWithTx(tx, trade); err != nil {
+		// Release whatever the engine did not fill or rest, and the budget a
+		// market buy did not spend
+		if order.Status == models.OrderStatusCancelled || spentBudget(order) {
+			if err := s.unfreezeOrderAssetsWithTx(tx, order); err != nil {
 				return err
 			}
 		}
@@ -117,22 +178,254 @@ func (s *OrderService) CancelOrder(orderID string, userID uint) error {
 	}
 
 	// Cancel in matching engine
-	if err := s.engine.CancelOrder(order.Symbol, orderID); err != nil {
+	report, err := s.engine.Cancel(order.Symbol, orderID)
+	if err != nil {
 		return err
 	}
 
-	// Update order status in database
-	order.Status = models.OrderStatusCancelled
-	if err := database.DB.Save(&order).Error; err != nil {
-		return err
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// Update order status in database
+		order.Status = models.OrderStatusCancelled
+		order.CancelReason = models.CancelReasonUser
+		if err := tx.Save(&order).Error; err != nil {
+			return err
+		}
+
+		// Unfreeze remaining assets
+		if err := s.unfreezeOrderAssetsWithTx(tx, &order); err != nil {
+			return err
+		}
+
+		// Pegged orders follow the book the cancel changed
+		return s.saveMakerUpdatesWithTx(tx, changedOrders(report))
+	})
+}
+
+// CancelAllOrders cancels all of a user's open orders on symbol, or on every
+// symbol when it is empty, optionally on one side only. Each symbol is
+// cancelled with one engine command and the orders are closed and their
+// assets released in one transaction.
+func (s *OrderService) CancelAllOrders(userID uint, symbol string, side models.OrderSide, reason string) ([]*models.Order, error) {
+	symbols := []string{symbol}
+	if symbol == "" {
+		symbols = nil
+		if err := database.DB.Model(&models.Order{}).
+			Where("user_id = ? AND status IN ?", userID, []models.OrderStatus{models.OrderStatusPending, models.OrderStatusPartial}).
+			Distinct().Pluck("symbol", &symbols).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var cancelled, repriced []*models.Order
+	for _, sym := range symbols {
+		report, err := s.engine.MassCancel(sym, userID, side, reason)
+		if err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, report.Cancelled...)
+		repriced = append(repriced, report.Makers...)
+	}
+
+	if len(cancelled) == 0 {
+		return cancelled, nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := s.closeCancelledOrdersWithTx(tx, cancelled); err != nil {
+			return err
+		}
+		// Pegged orders follow the book the cancels changed
+		return s.saveMakerUpdatesWithTx(tx, repriced)
+	})
+	return cancelled, err
+}
+
+// closeCancelledOrdersWithTx marks orders cancelled by the engine as such
+// and unfreezes their open parts with one update per user and currency
+func (s *OrderService) closeCancelledOrdersWithTx(tx *gorm.DB, orders []*models.Order) error {
+	type assetKey struct {
+		userID   uint
+		currency string
+	}
+	pairs := make(map[string]*models.TradingPair)
+	release := make(map[assetKey]decimal.Decimal)
+	ids := make(map[string][]string) // cancel reason -> order IDs
+	updateTime := orders[0].UpdateTime
+
+	for _, order := range orders {
+		pair, exists := pairs[order.Symbol]
+		if !exists {
+			pair = &models.TradingPair{}
+			if err := tx.Where("symbol = ?", order.Symbol).First(pair).Error; err != nil {
+				return err
+			}
+			pairs[order.Symbol] = pair
+		}
+
+		currency, amount := orderFreezeAmount(pair, order, order.Quantity.Sub(order.FilledQty))
+		key := assetKey{userID: order.UserID, currency: currency}
+		release[key] = release[key].Add(amount)
+		ids[order.CancelReason] = append(ids[order.CancelReason], order.ID)
+		if order.UpdateTime.After(updateTime) {
+			updateTime = order.UpdateTime
+		}
 	}
 
-	// Unfreeze remaining assets
-	s.unfreezeOrderAssets(&order)
+	for reason, orderIDs := range ids {
+		if err := tx.Model(&models.Order{}).Where("id IN ?", orderIDs).Updates(map[string]interface{}{
+			"status":        models.OrderStatusCancelled,
+			"cancel_reason": reason,
+			"update_time":   updateTime,
+		}).Error; err != nil {
+			return err
+		}
+	}
 
+	for key, amount := range release {
+		if amount.LessThanOrEqual(decimal.Zero) {
+			continue
+		}
+		if err := s.assetService.UnfreezeAssetWithTx(tx, key.userID, key.currency, "ERC20", amount); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// AmendOrder changes the price and/or quantity of an open limit order; a
+// zero value keeps the current one. The frozen assets are adjusted by the
+// difference in the same transaction.
+func (s *OrderService) AmendOrder(orderID string, userID uint, price, quantity decimal.Decimal) (*models.Order, []*models.Trade, error) {
+	var stored models.Order
+	if err := database.DB.Where("id = ? AND user_id = ?", orderID, userID).First(&stored).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if stored.Status != models.OrderStatusPending && stored.Status != models.OrderStatusPartial {
+		return nil, nil, errors.New("order cannot be amended")
+	}
+	if stored.Type != models.OrderTypeLimit {
+		return nil, nil, errors.New("only limit orders can be amended")
+	}
+
+	requested := stored
+	if price.IsPositive() {
+		requested.Price = price
+	}
+	if quantity.IsPositive() {
+		requested.Quantity = quantity
+	}
+
+	var order *models.Order
+	var trades []*models.Trade
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var pair models.TradingPair
+		if err := tx.Where("symbol = ?", stored.Symbol).First(&pair).Error; err != nil {
+			return err
+		}
+
+		// Freeze any increase before the engine can match the amended order
+		open := stored.Quantity.Sub(stored.FilledQty)
+		currency, held := orderFreezeAmount(&pair, &stored, open)
+		_, needed := orderFreezeAmount(&pair, &requested, requested.Quantity.Sub(stored.FilledQty))
+		frozen := &stored
+		if needed.GreaterThan(held) {
+			if err := s.assetService.FreezeAssetWithTx(tx, userID, currency, "ERC20", needed.Sub(held)); err != nil {
+				return err
+			}
+			frozen = &requested
+		}
+
+		report, err := s.engine.AmendOrder(stored.Symbol, orderID, userID, price, quantity)
+		if err != nil {
+			return err
+		}
+		order = report.Order
+		trades = report.Trades
+
+		// Release decreases, including a post-only reprice
+		if err := s.releaseExcessFrozenWithTx(tx, frozen, order); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Order{}).Where("id = ?", orderID).Updates(map[string]interface{}{
+			"price":         order.Price,
+			"quantity":      order.Quantity,
+			"filled_qty":    order.FilledQty,
+			"filled_amount": order.FilledAmount,
+			"avg_price":     order.AvgPrice,
+			"fee":           order.Fee,
+			"fee_currency":  order.FeeCurrency,
+			"status":        order.Status,
+			"cancel_reason": order.CancelReason,
+			"update_time":   order.UpdateTime,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := s.settleReportWithTx(tx, report); err != nil {
+			return err
+		}
+
+		// Self-trade prevention may cancel the re-queued order
+		if order.Status == models.OrderStatusCancelled {
+			return s.unfreezeOrderAssetsWithTx(tx, order)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return order, trades, nil
+}
+
+// ProcessEngineReports persists orders the engine changed on its own, such
+// as expired GTD orders, auction uncrosses and orders fired by mark or index
+// prices, and releases their frozen assets. It returns when the engine is
+// stopped.
+func (s *OrderService) ProcessEngineReports() {
+	for report := range s.engine.GetReportChan() {
+		if err := s.applyEngineReport(report); err != nil {
+			fmt.Printf("Error applying engine report %d for %s: %v\n", report.Seq, report.Symbol, err)
+		}
+	}
+}
+
+// applyEngineReport writes one engine-initiated report to the database
+func (s *OrderService) applyEngineReport(report *matching.ExecutionReport) error {
+	if report.Order == nil && len(report.Trades) == 0 && len(report.Makers) == 0 && len(report.Triggered) == 0 {
+		return nil
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		order := report.Order
+		if order == nil {
+			// Auction uncrosses and trigger prices only change existing orders
+			return s.settleReportWithTx(tx, report)
+		}
+
+		if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+			"status":        order.Status,
+			"cancel_reason": order.CancelReason,
+			"update_time":   order.UpdateTime,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := s.settleReportWithTx(tx, report); err != nil {
+			return err
+		}
+
+		if order.Status == models.OrderStatusCancelled {
+			return s.unfreezeOrderAssetsWithTx(tx, order)
+		}
+		return nil
+	})
+}
+
 // GetOrder gets an order by ID
 func (s *OrderService) GetOrder(orderID string, userID uint) (*models.Order, error) {
 	var order models.Order
@@ -142,12 +435,13 @@ func (s *OrderService) GetOrder(orderID string, userID uint) (*models.Order, err
 	return &order, nil
 }
 
-// GetOpenOrders gets all open orders for a user
+// GetOpenOrders gets all open limit and market orders for a user; untriggered
+// conditional orders are listed by GetConditionalOrders
 func (s *OrderService) GetOpenOrders(userID uint, symbol string) ([]models.Order, error) {
-	query := database.DB.Where("user_id = ? AND status IN ?", userID, []models.OrderStatus{
+	query := database.DB.Where("user_id = ? AND status IN ? AND type IN ?", userID, []models.OrderStatus{
 		models.OrderStatusPending,
 		models.OrderStatusPartial,
-	})
+	}, []models.OrderType{models.OrderTypeLimit, models.OrderTypeMarket})
 
 	if symbol != "" {
 		query = query.Where("symbol = ?", symbol)
@@ -161,31 +455,113 @@ func (s *OrderService) GetOpenOrders(userID uint, symbol string) ([]models.Order
 	return orders, nil
 }
 
-// GetOrderHistory gets order history for a user
-func (s *OrderService) GetOrderHistory(userID uint, symbol string, limit, offset int) ([]models.Order, error) {
-	query := database.DB.Where("user_id = ?", userID)
+// GetConditionalOrders gets a user's stop, take-profit and trailing orders
+// that are waiting for their trigger price
+func (s *OrderService) GetConditionalOrders(userID uint, symbol string) ([]models.Order, error) {
+	query := database.DB.Where("user_id = ? AND status = ? AND type IN ?", userID, models.OrderStatusPending, conditionalOrderTypes)
 
 	if symbol != "" {
 		query = query.Where("symbol = ?", symbol)
 	}
 
 	var orders []models.Order
-	if err := query.Order("create_time DESC").Limit(limit).Offset(offset).Find(&orders).Error; err != nil {
+	if err := query.Order("create_time DESC").Find(&orders).Error; err != nil {
 		return nil, err
 	}
 
 	return orders, nil
 }
 
-// GetOrderBookDepth gets order book depth
-func (s *OrderService) GetOrderBookDepth(symbol string, depth int) ([]matching.PriceLevelInfo, []matching.PriceLevelInfo) {
+// OrderDiscrepancy describes an open order whose database and engine state disagree
+type OrderDiscrepancy struct {
+	OrderID string `json:"order_id"`
+	Symbol  string `json:"symbol"`
+	Issue   string `json:"issue"`
+	Detail  string `json:"detail"`
+}
+
+// ReconcileOpenOrders compares the orders resting in the matching engine and
+// waiting in its trigger books with the open orders in the database
+func (s *OrderService) ReconcileOpenOrders() ([]OrderDiscrepancy, error) {
+	var dbOrders []models.Order
+	if err := database.DB.Where("type IN ? AND status IN ?", append([]models.OrderType{models.OrderTypeLimit}, conditionalOrderTypes...), []models.OrderStatus{
+		models.OrderStatusPending,
+		models.OrderStatusPartial,
+	}).Find(&dbOrders).Error; err != nil {
+		return nil, err
+	}
+
+	engineOrders := make(map[string]*models.Order)
+	for _, order := range s.engine.OpenOrders() {
+		engineOrders[order.ID] = order
+	}
+
+	var discrepancies []OrderDiscrepancy
+	for _, dbOrder := range dbOrders {
+		engineOrder, exists := engineOrders[dbOrder.ID]
+		if !exists {
+			discrepancies = append(discrepancies, OrderDiscrepancy{
+				OrderID: dbOrder.ID,
+				Symbol:  dbOrder.Symbol,
+				Issue:   "missing_in_engine",
+				Detail:  fmt.Sprintf("database status %s is not resting in the book", dbOrder.Status),
+			})
+			continue
+		}
+		delete(engineOrders, dbOrder.ID)
+
+		if !engineOrder.FilledQty.Equal(dbOrder.FilledQty) || engineOrder.Status != dbOrder.Status ||
+			!engineOrder.Price.Equal(dbOrder.Price) || !engineOrder.Quantity.Equal(dbOrder.Quantity) {
+			discrepancies = append(discrepancies, OrderDiscrepancy{
+				OrderID: dbOrder.ID,
+				Symbol:  dbOrder.Symbol,
+				Issue:   "state_mismatch",
+				Detail: fmt.Sprintf("database %s %s@%s filled %s, engine %s %s@%s filled %s",
+					dbOrder.Status, dbOrder.Quantity, dbOrder.Price, dbOrder.FilledQty,
+					engineOrder.Status, engineOrder.Quantity, engineOrder.Price, engineOrder.FilledQty),
+			})
+		}
+	}
+
+	for _, engineOrder := range engineOrders {
+		discrepancies = append(discrepancies, OrderDiscrepancy{
+			OrderID: engineOrder.ID,
+			Symbol:  engineOrder.Symbol,
+			Issue:   "missing_in_database",
+			Detail:  fmt.Sprintf("resting in the book as %s but not open in the database", engineOrder.Status),
+		})
+	}
+
+	return discrepancies, nil
+}
+
+// GetOrderBookDepth gets order book depth with the ID of the last depth
+// update it includes
+func (s *OrderService) GetOrderBookDepth(symbol string, depth int) *matching.DepthSnapshot {
 	ob, exists := s.engine.GetOrderBook(symbol)
 	if !exists {
-		return []matching.PriceLevelInfo{}, []matching.PriceLevelInfo{}
+		return &matching.DepthSnapshot{
+			Symbol: symbol,
+			Bids:   []matching.PriceLevelInfo{},
+			Asks:   []matching.PriceLevelInfo{},
+		}
 	}
 
-	bids, asks := ob.GetDepth(depth)
-	return bids, asks
+	return ob.GetDepthSnapshot(depth)
+}
+
+// GetOrderBookL3 gets every resting order of a symbol with anonymized IDs
+func (s *OrderService) GetOrderBookL3(symbol string) *matching.L3Snapshot {
+	ob, exists := s.engine.GetOrderBook(symbol)
+	if !exists {
+		return &matching.L3Snapshot{
+			Symbol: symbol,
+			Bids:   []matching.L3Order{},
+			Asks:   []matching.L3Order{},
+		}
+	}
+
+	return ob.GetL3Snapshot()
 }
 
 // GetRecentTrades gets recent trades for a symbol
@@ -201,6 +577,62 @@ func (s *OrderService) GetRecentTrades(symbol string, limit int) ([]models.Trade
 	return trades, nil
 }
 
+// validateTimeInForce checks the time-in-force and order flag combinations
+func (s *OrderService) validateTimeInForce(order *models.Order) error {
+	switch order.TimeInForce {
+	case "", models.TimeInForceGTC, models.TimeInForceIOC, models.TimeInForceFOK:
+	case models.TimeInForceGTD:
+		if order.ExpireTime == nil || !order.ExpireTime.After(time.Now()) {
+			return errors.New("expire time must be in the future")
+		}
+	default:
+		return errors.New("invalid time in force")
+	}
+
+	return nil
+}
+
+// validateReduceOnly rejects reduce-only orders that would open or increase a
+// position and caps the quantity to the unreserved part of the position
+func (s *OrderService) validateReduceOnly(order *models.Order) error {
+	if s.positions == nil {
+		return errors.New("reduce-only orders are not supported")
+	}
+
+	position, err := s.positions.GetNetPosition(context.Background(), order.UserID, order.Symbol)
+	if err != nil {
+		return err
+	}
+
+	// A buy can only reduce a short and a sell can only reduce a long
+	if (order.Side == models.OrderSideBuy && !position.IsNegative()) ||
+		(order.Side == models.OrderSideSell && !position.IsPositive()) {
+		return errors.New("reduce-only order would increase position")
+	}
+
+	// Open reduce-only orders on the same side already reserve part of it
+	var openOrders []models.Order
+	if err := database.DB.Where("user_id = ? AND symbol = ? AND side = ? AND reduce_only = ? AND status IN (?, ?)",
+		order.UserID, order.Symbol, order.Side, true, models.OrderStatusPending, models.OrderStatusPartial).
+		Find(&openOrders).Error; err != nil {
+		return err
+	}
+
+	available := position.Abs()
+	for _, open := range openOrders {
+		available = available.Sub(open.Quantity.Sub(open.FilledQty))
+	}
+	if available.LessThanOrEqual(decimal.Zero) {
+		return errors.New("reduce-only order would increase position")
+	}
+
+	if order.Quantity.GreaterThan(available) {
+		order.Quantity = available
+	}
+
+	return nil
+}
+
 // validateOrderBalance validates user has sufficient balance
 func (s *OrderService) validateOrderBalance(order *models.Order) error {
 	// Get trading pair to determine currencies
@@ -209,19 +641,8 @@ func (s *OrderService) validateOrderBalance(order *models.Order) error {
 		return errors.New("invalid trading pair")
 	}
 
-	var currency string
-	var requiredAmount = order.Quantity
-
-	if order.Side == models.OrderSideBuy {
-		// For buy orders, check quote currency (e.g., USDT in BTC_USDT)
-		currency = pair.QuoteCurrency
-		if order.Type == models.OrderTypeLimit {
-			requiredAmount = order.Quantity.Mul(order.Price)
-		}
-	} else {
-		// For sell orders, check base currency (e.g., BTC in BTC_USDT)
-		currency = pair.BaseCurrency
-	}
+	// Buy orders need quote currency (e.g. USDT in BTC_USDT), sell orders base currency
+	currency, requiredAmount := orderFreezeAmount(&pair, order, order.Quantity)
 
 	// Get user asset
 	asset, err := s.assetService.GetUserAsset(order.UserID, currency, "ERC20")
@@ -243,18 +664,7 @@ func (s *OrderService) freezeOrderAssets(order *models.Order) error {
 		return err
 	}
 
-	var currency string
-	var amount = order.Quantity
-
-	if order.Side == models.OrderSideBuy {
-		currency = pair.QuoteCurrency
-		if order.Type == models.OrderTypeLimit {
-			amount = order.Quantity.Mul(order.Price)
-		}
-	} else {
-		currency = pair.BaseCurrency
-	}
-
+	currency, amount := orderFreezeAmount(&pair, order, order.Quantity)
 	return s.assetService.FreezeAsset(context.Background(), order.UserID, currency, "ERC20", amount)
 }
 
@@ -265,79 +675,132 @@ func (s *OrderService) unfreezeOrderAssets(order *models.Order) error {
 		return err
 	}
 
-	var currency string
-	remainingQty := order.Quantity.Sub(order.FilledQty)
-	var amount = remainingQty
-
-	if order.Side == models.OrderSideBuy {
-		currency = pair.QuoteCurrency
-		if order.Type == models.OrderTypeLimit {
-			amount = remainingQty.Mul(order.Price)
-		}
-	} else {
-		currency = pair.BaseCurrency
-	}
-
+	currency, amount := orderFreezeAmount(&pair, order, order.Quantity.Sub(order.FilledQty))
 	return s.assetService.UnfreezeAsset(context.Background(), order.UserID, currency, "ERC20", amount)
 }
 
 // processTradeSettlement processes trade settlement
 func (s *OrderService) processTradeSettlement(trade *models.Trade) error {
 	return database.DB.Transaction(func(tx *gorm.DB) error {
-		// Get trading pair
-		var pair models.TradingPair
-		if err := tx.Where("symbol = ?", trade.Symbol).First(&pair).Error; err != nil {
-			return err
+		return s.processTradeSettlementWithTx(tx, trade)
+	})
+}
+
+// settleReportWithTx persists the resting and triggered orders an execution
+// touched and its trades, and settles the fills. The engine never matches a
+// user with themselves.
+func (s *OrderService) settleReportWithTx(tx *gorm.DB, report *matching.ExecutionReport) error {
+	if err := s.saveMakerUpdatesWithTx(tx, changedOrders(report)); err != nil {
+		return err
+	}
+
+	for _, trade := range report.Trades {
+		if err := tx.Create(trade).Error; err != nil {
+			return err // Fail entire transaction if trade save fails
 		}
 
-		// Update buyer assets (give base currency, take quote currency)
-		var buyerBaseAsset models.UserAsset
-		if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-			trade.BuyerID, pair.BaseCurrency, "ERC20").First(&buyerBaseAsset).Error; err != nil {
+		// Update user assets based on trades within transaction
+		if err := s.processTradeSettlementWithTx(tx, trade); err != nil {
 			return err
 		}
+	}
 
-		buyerBaseAsset.Available = buyerBaseAsset.Available.Add(trade.Quantity)
-		if err := tx.Save(&buyerBaseAsset).Error; err != nil {
-			return err
+	return s.releasePriceImprovementWithTx(tx, report)
+}
+
+// releasePriceImprovementWithTx unfreezes the quote a limit buy order froze
+// at its own price but spent at a lower trade price, as happens to takers
+// and to every bid filled by an auction uncross
+func (s *OrderService) releasePriceImprovementWithTx(tx *gorm.DB, report *matching.ExecutionReport) error {
+	orders := make(map[string]*models.Order, len(report.Makers)+len(report.Triggered)+1)
+	if report.Order != nil {
+		orders[report.Order.ID] = report.Order
+	}
+	for _, order := range changedOrders(report) {
+		orders[order.ID] = order
+	}
+
+	for _, trade := range report.Trades {
+		buy, exists := orders[trade.BuyOrderID]
+		if !exists || buy.Type != models.OrderTypeLimit {
+			continue
+		}
+		improvement := frozenPrice(buy).Sub(trade.Price).Mul(trade.Quantity)
+		if !improvement.IsPositive() {
+			continue
 		}
 
-		var buyerQuoteAsset models.UserAsset
-		if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-			trade.BuyerID, pair.QuoteCurrency, "ERC20").First(&buyerQuoteAsset).Error; err != nil {
+		var pair models.TradingPair
+		if err := tx.Where("symbol = ?", trade.Symbol).First(&pair).Error; err != nil {
 			return err
 		}
-
-		buyerQuoteAsset.Frozen = buyerQuoteAsset.Frozen.Sub(trade.Amount.Add(trade.BuyerFee))
-		if err := tx.Save(&buyerQuoteAsset).Error; err != nil {
+		if err := s.assetService.UnfreezeAssetWithTx(tx, buy.UserID, pair.QuoteCurrency, "ERC20", improvement); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// Update seller assets (take base currency, give quote currency)
-		var sellerBaseAsset models.UserAsset
-		if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-			trade.SellerID, pair.BaseCurrency, "ERC20").First(&sellerBaseAsset).Error; err != nil {
-			return err
+// changedOrders returns the triggered and resting orders of a report other
+// than its own order, once each. An order fired by the command may also be
+// hit as a maker later in it; every entry holds its final state.
+func changedOrders(report *matching.ExecutionReport) []*models.Order {
+	seen := make(map[string]bool, len(report.Triggered)+len(report.Makers)+1)
+	if report.Order != nil {
+		seen[report.Order.ID] = true
+	}
+
+	var orders []*models.Order
+	for _, list := range [][]*models.Order{report.Triggered, report.Makers} {
+		for _, order := range list {
+			if !seen[order.ID] {
+				seen[order.ID] = true
+				orders = append(orders, order)
+			}
 		}
+	}
+	return orders
+}
 
-		sellerBaseAsset.Frozen = sellerBaseAsset.Frozen.Sub(trade.Quantity.Add(trade.SellerFee))
-		if err := tx.Save(&sellerBaseAsset).Error; err != nil {
+// saveMakerUpdatesWithTx writes the engine's view of resting and triggered
+// orders back to the database and releases assets of orders that were
+// reduced or cancelled
+func (s *OrderService) saveMakerUpdatesWithTx(tx *gorm.DB, makers []*models.Order) error {
+	for _, maker := range makers {
+		var stored models.Order
+		if err := tx.Where("id = ?", maker.ID).First(&stored).Error; err != nil {
 			return err
 		}
 
-		var sellerQuoteAsset models.UserAsset
-		if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-			trade.SellerID, pair.QuoteCurrency, "ERC20").First(&sellerQuoteAsset).Error; err != nil {
+		if err := tx.Model(&models.Order{}).Where("id = ?", maker.ID).Updates(map[string]interface{}{
+			"price":         maker.Price,
+			"quantity":      maker.Quantity,
+			"filled_qty":    maker.FilledQty,
+			"filled_amount": maker.FilledAmount,
+			"avg_price":     maker.AvgPrice,
+			"fee":           maker.Fee,
+			"fee_currency":  maker.FeeCurrency,
+			"status":        maker.Status,
+			"cancel_reason": maker.CancelReason,
+			"type":          maker.Type,
+			"stop_price":    maker.StopPrice,
+			"is_triggered":  maker.IsTriggered,
+			"trigger_time":  maker.TriggerTime,
+			"update_time":   maker.UpdateTime,
+		}).Error; err != nil {
 			return err
 		}
 
-		sellerQuoteAsset.Available = sellerQuoteAsset.Available.Add(trade.Amount)
-		if err := tx.Save(&sellerQuoteAsset).Error; err != nil {
+		if err := s.releaseExcessFrozenWithTx(tx, &stored, maker); err != nil {
 			return err
 		}
-
-		return nil
-	})
+		if maker.Status == models.OrderStatusCancelled || spentBudget(maker) {
+			if err := s.unfreezeOrderAssetsWithTx(tx, maker); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // freezeOrderAssetsWithTx freezes assets for an order within a transaction
@@ -347,74 +810,134 @@ func (s *OrderService) freezeOrderAssetsWithTx(tx *gorm.DB, order *models.Order)
 		return err
 	}
 
-	var currency string
-	var amount = order.Quantity
+	currency, amount := orderFreezeAmount(&pair, order, order.Quantity)
+	return s.assetService.FreezeAssetWithTx(tx, order.UserID, currency, "ERC20", amount)
+}
 
-	if order.Side == models.OrderSideBuy {
-		currency = pair.QuoteCurrency
-		if order.Type == models.OrderTypeLimit {
-			amount = order.Quantity.Mul(order.Price)
-		}
-	} else {
-		currency = pair.BaseCurrency
+// unfreezeOrderAssetsWithTx unfreezes the unfilled part of an order within a transaction
+func (s *OrderService) unfreezeOrderAssetsWithTx(tx *gorm.DB, order *models.Order) error {
+	var pair models.TradingPair
+	if err := tx.Where("symbol = ?", order.Symbol).First(&pair).Error; err != nil {
+		return err
 	}
 
-	return s.assetService.FreezeAssetWithTx(tx, order.UserID, currency, "ERC20", amount)
+	currency, amount := orderFreezeAmount(&pair, order, order.Quantity.Sub(order.FilledQty))
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return nil
+	}
+
+	return s.assetService.UnfreezeAssetWithTx(tx, order.UserID, currency, "ERC20", amount)
 }
 
-// processTradeSettlementWithTx processes trade settlement within a transaction
-func (s *OrderService) processTradeSettlementWithTx(tx *gorm.DB, trade *models.Trade) error {
-	// Get trading pair
+// releaseExcessFrozenWithTx unfreezes what was frozen for the open part of
+// an order as it was before but is no longer needed after the engine lowered
+// its price or quantity. Fills since before are settled separately.
+func (s *OrderService) releaseExcessFrozenWithTx(tx *gorm.DB, before, after *models.Order) error {
+	if before.Quantity.Equal(after.Quantity) && before.Price.Equal(after.Price) {
+		return nil
+	}
+	// Quote budgets are released once the order closes
+	if after.QuoteOrderQty != nil {
+		return nil
+	}
+
 	var pair models.TradingPair
-	if err := tx.Where("symbol = ?", trade.Symbol).First(&pair).Error; err != nil {
+	if err := tx.Where("symbol = ?", after.Symbol).First(&pair).Error; err != nil {
 		return err
 	}
 
-	// Update buyer assets (give base currency, take quote currency)
-	var buyerBaseAsset models.UserAsset
-	if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-		trade.BuyerID, pair.BaseCurrency, "ERC20").First(&buyerBaseAsset).Error; err != nil {
-		return err
+	currency, frozen := orderFreezeAmount(&pair, before, before.Quantity.Sub(before.FilledQty))
+	_, needed := orderFreezeAmount(&pair, after, after.Quantity.Sub(before.FilledQty))
+	excess := frozen.Sub(needed)
+	if excess.LessThanOrEqual(decimal.Zero) {
+		return nil
 	}
 
-	buyerBaseAsset.Available = buyerBaseAsset.Available.Add(trade.Quantity)
-	if err := tx.Save(&buyerBaseAsset).Error; err != nil {
-		return err
+	return s.assetService.UnfreezeAssetWithTx(tx, after.UserID, currency, "ERC20", excess)
+}
+
+// orderFreezeAmount returns the currency and amount frozen for quantity of
+// an order. Conditional orders freeze what the order they place needs from
+// the start, so triggering them never changes the frozen amount. Market
+// buys, and conditional buys placing one, freeze the unspent part of their
+// quote budget whatever the quantity.
+func orderFreezeAmount(pair *models.TradingPair, order *models.Order, quantity decimal.Decimal) (string, decimal.Decimal) {
+	if order.Side == models.OrderSideBuy {
+		if matching.HasLimitPrice(order) {
+			return pair.QuoteCurrency, quantity.Mul(frozenPrice(order))
+		}
+		if order.QuoteOrderQty != nil {
+			return pair.QuoteCurrency, order.QuoteOrderQty.Sub(order.FilledAmount)
+		}
+		return pair.QuoteCurrency, quantity
 	}
+	return pair.BaseCurrency, quantity
+}
 
-	var buyerQuoteAsset models.UserAsset
-	if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-		trade.BuyerID, pair.QuoteCurrency, "ERC20").First(&buyerQuoteAsset).Error; err != nil {
-		return err
+// spentBudget reports whether order is a market buy that has executed, so
+// the part of its quote budget it did not spend is released. Conditional
+// buys keep their budget frozen until they trigger.
+func spentBudget(order *models.Order) bool {
+	return order.QuoteOrderQty != nil && order.Type == models.OrderTypeMarket
+}
+
+// frozenPrice returns the price a limit bid's funds are frozen at. Pegged
+// bids freeze at their peg limit, so repricing never changes the amount.
+func frozenPrice(order *models.Order) decimal.Decimal {
+	if order.PegType != "" && order.PegLimit != nil {
+		return *order.PegLimit
 	}
+	return order.Price
+}
 
-	buyerQuoteAsset.Frozen = buyerQuoteAsset.Frozen.Sub(trade.Amount.Add(trade.BuyerFee))
-	if err := tx.Save(&buyerQuoteAsset).Error; err != nil {
+// processTradeSettlementWithTx processes trade settlement within a transaction.
+// Each side's frozen funds cover exactly what it gives; its fee is taken from
+// the balance of the fee currency, normally the currency it receives.
+func (s *OrderService) processTradeSettlementWithTx(tx *gorm.DB, trade *models.Trade) error {
+	// Get trading pair
+	var pair models.TradingPair
+	if err := tx.Where("symbol = ?", trade.Symbol).First(&pair).Error; err != nil {
 		return err
 	}
 
-	// Update seller assets (take base currency, give quote currency)
-	var sellerBaseAsset models.UserAsset
-	if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-		trade.SellerID, pair.BaseCurrency, "ERC20").First(&sellerBaseAsset).Error; err != nil {
+	// Buyer gives quote currency and receives base currency
+	buyerBase, buyerQuote := trade.Quantity, decimal.Zero
+	if trade.BuyerFeeCurrency == pair.QuoteCurrency {
+		buyerQuote = buyerQuote.Sub(trade.BuyerFee)
+	} else {
+		buyerBase = buyerBase.Sub(trade.BuyerFee)
+	}
+	if err := settleAssetWithTx(tx, trade.BuyerID, pair.BaseCurrency, buyerBase, decimal.Zero); err != nil {
 		return err
 	}
-
-	sellerBaseAsset.Frozen = sellerBaseAsset.Frozen.Sub(trade.Quantity.Add(trade.SellerFee))
-	if err := tx.Save(&sellerBaseAsset).Error; err != nil {
+	if err := settleAssetWithTx(tx, trade.BuyerID, pair.QuoteCurrency, buyerQuote, trade.Amount); err != nil {
 		return err
 	}
 
-	var sellerQuoteAsset models.UserAsset
-	if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
-		trade.SellerID, pair.QuoteCurrency, "ERC20").First(&sellerQuoteAsset).Error; err != nil {
+	// Seller gives base currency and receives quote currency
+	sellerBase, sellerQuote := decimal.Zero, trade.Amount
+	if trade.SellerFeeCurrency == pair.BaseCurrency {
+		sellerBase = sellerBase.Sub(trade.SellerFee)
+	} else {
+		sellerQuote = sellerQuote.Sub(trade.SellerFee)
+	}
+	if err := settleAssetWithTx(tx, trade.SellerID, pair.BaseCurrency, sellerBase, trade.Quantity); err != nil {
 		return err
 	}
+	return settleAssetWithTx(tx, trade.SellerID, pair.QuoteCurrency, sellerQuote, decimal.Zero)
+}
 
-	sellerQuoteAsset.Available = sellerQuoteAsset.Available.Add(trade.Amount)
-	if err := tx.Save(&sellerQuoteAsset).Error; err != nil {
+// settleAssetWithTx adds availableDelta to a user's available balance and
+// releases spentFrozen from the frozen balance
+func settleAssetWithTx(tx *gorm.DB, userID uint, currency string, availableDelta, spentFrozen decimal.Decimal) error {
+	var asset models.UserAsset
+	if err := tx.Where("user_id = ? AND currency = ? AND chain = ?",
+		userID, currency, "ERC20").First(&asset).Error; err != nil {
 		return err
 	}
 
-	return nil
+	asset.Available = asset.Available.Add(availableDelta)
+	asset.Frozen = asset.Frozen.Sub(spentFrozen)
+	asset.UpdateTime = time.Now()
+	return tx.Save(&asset).Error
 }
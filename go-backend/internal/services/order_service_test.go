@@ -0,0 +1,112 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupOrders creates an order service on a BTC_USDT engine, with users 1
+// and 2 funded with 1000 USDT and 10 BTC. Orders check balances outside
+// their transaction, so the database is a file that takes more than one
+// connection.
+func setupOrders(t *testing.T) (*OrderService, *matching.MatchingEngine) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "orders.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.TradingPair{}, &models.UserAsset{}, &models.Order{}, &models.Trade{}))
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+
+	require.NoError(t, db.Create(&models.TradingPair{
+		Symbol: "BTC_USDT", BaseCurrency: "BTC", QuoteCurrency: "USDT",
+		PricePrecision: 2, QuantityPrecision: 4, MaxQuantity: decimal.NewFromInt(1000), IsActive: true,
+	}).Error)
+	for _, user := range []models.User{{ID: 1, Email: "buyer@example.com", Phone: "1"}, {ID: 2, Email: "seller@example.com", Phone: "2"}} {
+		require.NoError(t, db.Create(&user).Error)
+		for _, asset := range []models.UserAsset{
+			{UserID: user.ID, Currency: "USDT", Chain: "ERC20", Available: decimal.NewFromInt(1000)},
+			{UserID: user.ID, Currency: "BTC", Chain: "ERC20", Available: decimal.NewFromInt(10)},
+		} {
+			require.NoError(t, db.Create(&asset).Error)
+		}
+	}
+
+	engine := matching.NewMatchingEngine()
+	go func() {
+		for range engine.GetTradeChan() {
+		}
+	}()
+	t.Cleanup(engine.Stop)
+	require.NoError(t, NewSymbolRegistry(engine).Load())
+
+	return NewOrderService(engine, NewAssetService(), nil), engine
+}
+
+func assertAsset(t *testing.T, userID uint, currency, available, frozen string) {
+	t.Helper()
+
+	var asset models.UserAsset
+	require.NoError(t, database.DB.Where("user_id = ? AND currency = ?", userID, currency).First(&asset).Error)
+	assert.True(t, asset.Available.Equal(decimal.RequireFromString(available)), "%s available %s, want %s", currency, asset.Available, available)
+	assert.True(t, asset.Frozen.Equal(decimal.RequireFromString(frozen)), "%s frozen %s, want %s", currency, asset.Frozen, frozen)
+}
+
+// TestConditionalMarketBuyBudget checks a stop buy freezes its quote budget
+// when placed, and releases what it did not spend once triggered
+func TestConditionalMarketBuyBudget(t *testing.T) {
+	service, engine := setupOrders(t)
+	stop := decimal.NewFromInt(100)
+	stopBuy := func(id string) *models.Order {
+		return &models.Order{ID: id, UserID: 1, Symbol: "BTC_USDT", Side: models.OrderSideBuy,
+			Type: models.OrderTypeStopLoss, Quantity: decimal.NewFromInt(1), StopPrice: &stop,
+			TriggerPriceType: models.TriggerPriceMark, TimeInForce: models.TimeInForceGTC}
+	}
+
+	_, _, err := service.CreateOrder(stopBuy("no-budget"))
+	assert.Error(t, err)
+	assertAsset(t, 1, "USDT", "1000", "0")
+
+	order := stopBuy("stop-buy")
+	budget := decimal.NewFromInt(150)
+	order.QuoteOrderQty = &budget
+	_, _, err = service.CreateOrder(order)
+	require.NoError(t, err)
+	assertAsset(t, 1, "USDT", "850", "150")
+
+	_, _, err = service.CreateOrder(&models.Order{ID: "ask", UserID: 2, Symbol: "BTC_USDT", Side: models.OrderSideSell,
+		Type: models.OrderTypeLimit, Price: decimal.NewFromInt(120), Quantity: decimal.NewFromInt(2),
+		TimeInForce: models.TimeInForceGTC})
+	require.NoError(t, err)
+
+	// The stop fills 1 BTC at 120, less the taker fee, and hands back the
+	// other 30 of its budget
+	require.NoError(t, engine.UpdateTriggerPrice("BTC_USDT", models.TriggerPriceMark, decimal.NewFromInt(101)))
+	select {
+	case report := <-engine.GetReportChan():
+		require.NoError(t, service.applyEngineReport(report))
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop buy was not triggered")
+	}
+
+	var stored models.Order
+	require.NoError(t, database.DB.First(&stored, "id = ?", "stop-buy").Error)
+	assert.Equal(t, models.OrderStatusFilled, stored.Status)
+	assertAsset(t, 1, "USDT", "880", "0")
+	assertAsset(t, 1, "BTC", "10.999", "0")
+}
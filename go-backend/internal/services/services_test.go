@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/easitradecoins/backend/internal/database"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,6 +36,24 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	return db
 }
 
+// useTestDB makes a test database the package database, with the given
+// models migrated as well, for services that use database.DB
+func useTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	db := setupTestDB(t)
+
+	// Every connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(tables...))
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+
+	return db
+}
+
 // TestMarginTradingService tests margin trading functionality
 func TestMarginTradingService(t *testing.T) {
 	db := setupTestDB(t)
@@ -0,0 +1,78 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// Conditional orders are held in the matching engine's trigger book and fire
+// on the trade, mark or index price their TriggerPriceType selects. The
+// constructors below fill in the trigger condition for the common cases.
+
+// CreateStopLossOrder creates a stop-loss order
+func CreateStopLossOrder(userID uint, symbol string, side models.OrderSide, quantity, stopPrice decimal.Decimal) *models.Order {
+	triggerCondition := "<="
+	if side == models.OrderSideBuy {
+		triggerCondition = ">="
+	}
+
+	return &models.Order{
+		UserID:           userID,
+		Symbol:           symbol,
+		Side:             side,
+		Type:             models.OrderTypeStopLoss,
+		Quantity:         quantity,
+		StopPrice:        &stopPrice,
+		TriggerCondition: triggerCondition,
+		Status:           models.OrderStatusPending,
+		TimeInForce:      models.TimeInForceGTC,
+		IsTriggered:      false,
+	}
+}
+
+// CreateTakeProfitOrder creates a take-profit order
+func CreateTakeProfitOrder(userID uint, symbol string, side models.OrderSide, quantity, takeProfitPrice decimal.Decimal) *models.Order {
+	triggerCondition := ">="
+	if side == models.OrderSideBuy {
+		triggerCondition = "<="
+	}
+
+	return &models.Order{
+		UserID:           userID,
+		Symbol:           symbol,
+		Side:             side,
+		Type:             models.OrderTypeTakeProfit,
+		Quantity:         quantity,
+		TakeProfitPrice:  &takeProfitPrice,
+		TriggerCondition: triggerCondition,
+		Status:           models.OrderStatusPending,
+		TimeInForce:      models.TimeInForceGTC,
+		IsTriggered:      false,
+	}
+}
+
+// CreateTrailingStopOrder creates a trailing stop order
+func CreateTrailingStopOrder(userID uint, symbol string, side models.OrderSide, quantity, initialStopPrice, trailingDelta decimal.Decimal) *models.Order {
+	triggerCondition := "<="
+	if side == models.OrderSideBuy {
+		triggerCondition = ">="
+	}
+
+	return &models.Order{
+		UserID:           userID,
+		Symbol:           symbol,
+		Side:             side,
+		Type:             models.OrderTypeTrailingStop,
+		Quantity:         quantity,
+		StopPrice:        &initialStopPrice,
+		TrailingDelta:    &trailingDelta,
+		TriggerCondition: triggerCondition,
+		Status:           models.OrderStatusPending,
+		TimeInForce:      models.TimeInForceGTC,
+		IsTriggered:      false,
+	}
+}
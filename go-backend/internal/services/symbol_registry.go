@@ -0,0 +1,302 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+)
+
+// pairUpdatesChannel is the Redis channel announcing trading pair changes
+const pairUpdatesChannel = "trading_pairs:updated"
+
+// SymbolInfo describes a tradable symbol and its filters
+type SymbolInfo struct {
+	Symbol        string                 `json:"symbol"`
+	BaseCurrency  string                 `json:"base_currency"`
+	QuoteCurrency string                 `json:"quote_currency"`
+	Status        string                 `json:"status"` // a matching.TradingStatus; inactive pairs are halted
+	Filters       *matching.SymbolFilter `json:"filters"`
+}
+
+// SymbolRegistry keeps the trading pairs in memory and the matching engine's
+// symbol filters and trading statuses in sync with the trading_pairs table.
+// Changes are picked up immediately when made through UpdatePair or announced
+// over Redis, and by a periodic reload otherwise.
+type SymbolRegistry struct {
+	engine    *matching.MatchingEngine
+	symbols   map[string]*SymbolInfo
+	listeners []func(pairs []models.TradingPair)
+	statuses  map[string]string // symbol -> trading status last applied to the engine
+	mutex     sync.RWMutex
+	stopChan  chan struct{}
+	running   bool
+}
+
+// NewSymbolRegistry creates a new symbol registry
+func NewSymbolRegistry(engine *matching.MatchingEngine) *SymbolRegistry {
+	return &SymbolRegistry{
+		engine:   engine,
+		symbols:  make(map[string]*SymbolInfo),
+		statuses: make(map[string]string),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// OnLoad registers a function called with all trading pairs on every load
+func (r *SymbolRegistry) OnLoad(fn func(pairs []models.TradingPair)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Load reads all trading pairs and installs their filters in the engine.
+// Trading statuses and auctions are applied once the registry is started, as
+// they are engine commands and must not precede the journal recovery.
+func (r *SymbolRegistry) Load() error {
+	var pairs []models.TradingPair
+	if err := database.DB.Find(&pairs).Error; err != nil {
+		return err
+	}
+
+	symbols := make(map[string]*SymbolInfo, len(pairs))
+	filters := make([]*matching.SymbolFilter, 0, len(pairs))
+	for i := range pairs {
+		filter := matching.NewSymbolFilter(&pairs[i])
+		if !matching.ValidAlgorithm(filter.Algorithm) {
+			fmt.Printf("Error loading %s: unknown matching algorithm %q, using fifo\n", pairs[i].Symbol, filter.Algorithm)
+			filter.Algorithm = matching.AlgorithmFIFO
+		}
+		symbols[pairs[i].Symbol] = &SymbolInfo{
+			Symbol:        pairs[i].Symbol,
+			BaseCurrency:  pairs[i].BaseCurrency,
+			QuoteCurrency: pairs[i].QuoteCurrency,
+			Filters:       filter,
+		}
+		filters = append(filters, filter)
+	}
+
+	r.mutex.Lock()
+	r.symbols = symbols
+	listeners := r.listeners
+	running := r.running
+	r.mutex.Unlock()
+
+	r.engine.SetSymbolFilters(filters)
+	if running {
+		r.applyStatuses(pairs)
+	}
+	for _, fn := range listeners {
+		fn(pairs)
+	}
+	return nil
+}
+
+// applyStatuses pushes trading statuses changed in the database and
+// scheduled auctions to the engine. Statuses the engine set itself, such as
+// circuit breaker halts, stand until the stored status changes.
+func (r *SymbolRegistry) applyStatuses(pairs []models.TradingPair) {
+	now := time.Now()
+	for _, pair := range pairs {
+		if end := pair.AuctionEndTime; end != nil && end.After(now) {
+			r.startAuction(pair.Symbol, *end)
+			continue
+		}
+
+		status := pair.TradingStatus
+		if status == "" {
+			status = string(matching.StatusTrading)
+		}
+
+		r.mutex.Lock()
+		applied, exists := r.statuses[pair.Symbol]
+		r.statuses[pair.Symbol] = status
+		r.mutex.Unlock()
+
+		// A symbol first seen trading is left alone so a restart keeps
+		// whatever the engine recovered
+		if applied == status || (!exists && status == string(matching.StatusTrading)) {
+			continue
+		}
+		if err := r.engine.SetTradingStatus(pair.Symbol, matching.TradingStatus(status)); err != nil {
+			fmt.Printf("Error setting trading status of %s: %v\n", pair.Symbol, err)
+		}
+	}
+}
+
+// startAuction puts a symbol into its scheduled auction unless the engine
+// is already running it
+func (r *SymbolRegistry) startAuction(symbol string, end time.Time) {
+	if current, ok := r.engine.AuctionEnd(symbol); ok && current.Equal(end) {
+		return
+	}
+	if err := r.engine.StartAuction(symbol, end); err != nil {
+		fmt.Printf("Error starting auction for %s: %v\n", symbol, err)
+	}
+}
+
+// Start applies the trading statuses and reloads the trading pairs every
+// interval and on Redis announcements
+func (r *SymbolRegistry) Start(interval time.Duration) {
+	r.mutex.Lock()
+	if r.running {
+		r.mutex.Unlock()
+		return
+	}
+	r.running = true
+	r.mutex.Unlock()
+
+	if err := r.Load(); err != nil {
+		fmt.Printf("Error reloading trading pairs: %v\n", err)
+	}
+	go r.reloadLoop(interval)
+}
+
+// Stop stops the registry
+func (r *SymbolRegistry) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.running {
+		return
+	}
+
+	r.running = false
+	close(r.stopChan)
+}
+
+// reloadLoop is the main reload loop
+func (r *SymbolRegistry) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var updates <-chan struct{}
+	if database.Redis != nil {
+		updates = r.subscribe()
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-updates:
+		case <-r.stopChan:
+			return
+		}
+
+		if err := r.Load(); err != nil {
+			fmt.Printf("Error reloading trading pairs: %v\n", err)
+		}
+	}
+}
+
+// subscribe forwards trading pair announcements until the registry stops
+func (r *SymbolRegistry) subscribe() <-chan struct{} {
+	updates := make(chan struct{}, 1)
+	pubsub := database.Redis.Subscribe(context.Background(), pairUpdatesChannel)
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-messages:
+				select {
+				case updates <- struct{}{}:
+				default:
+				}
+			case <-r.stopChan:
+				return
+			}
+		}
+	}()
+
+	return updates
+}
+
+// UpdatePair saves a trading pair and applies its filters right away
+func (r *SymbolRegistry) UpdatePair(pair *models.TradingPair) error {
+	if err := database.DB.Save(pair).Error; err != nil {
+		return err
+	}
+
+	if err := r.Load(); err != nil {
+		return err
+	}
+
+	// Let other instances reload as well
+	if database.Redis != nil {
+		database.Redis.Publish(context.Background(), pairUpdatesChannel, pair.Symbol)
+	}
+
+	return nil
+}
+
+// SetTradingStatus stores and applies an admin status change; status is a
+// matching.TradingStatus other than auction
+func (r *SymbolRegistry) SetTradingStatus(symbol string, status matching.TradingStatus) error {
+	if err := r.engine.SetTradingStatus(symbol, status); err != nil {
+		return err
+	}
+
+	if err := database.DB.Model(&models.TradingPair{}).Where("symbol = ?", symbol).
+		Update("trading_status", string(status)).Error; err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.statuses[symbol] = string(status)
+	r.mutex.Unlock()
+
+	// Let other instances reload as well
+	if database.Redis != nil {
+		database.Redis.Publish(context.Background(), pairUpdatesChannel, symbol)
+	}
+	return nil
+}
+
+// symbolInfo returns a copy of a symbol with its live trading status
+func (r *SymbolRegistry) symbolInfo(info *SymbolInfo) *SymbolInfo {
+	copied := *info
+	copied.Status = string(r.engine.GetTradingStatus(info.Symbol))
+	if !info.Filters.Active {
+		copied.Status = string(matching.StatusHalted)
+	}
+	return &copied
+}
+
+// GetSymbols returns all symbols ordered by name
+func (r *SymbolRegistry) GetSymbols() []*SymbolInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	symbols := make([]*SymbolInfo, 0, len(r.symbols))
+	for _, info := range r.symbols {
+		symbols = append(symbols, r.symbolInfo(info))
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Symbol < symbols[j].Symbol
+	})
+
+	return symbols
+}
+
+// GetSymbol returns a symbol by name
+func (r *SymbolRegistry) GetSymbol(symbol string) (*SymbolInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	info, exists := r.symbols[symbol]
+	if !exists {
+		return nil, false
+	}
+	return r.symbolInfo(info), true
+}
@@ -0,0 +1,327 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/database"
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// tickerWindow is the rolling period ticker statistics cover
+const tickerWindow = 24 * time.Hour
+
+// Ticker holds the rolling 24-hour statistics and top of book of a symbol.
+// With no trades in the window open, high and low are the last price.
+type Ticker struct {
+	Symbol             string          `json:"symbol"`
+	LastPrice          decimal.Decimal `json:"last_price"`
+	LastQty            decimal.Decimal `json:"last_qty"`
+	OpenPrice          decimal.Decimal `json:"open_price"`
+	HighPrice          decimal.Decimal `json:"high_price"`
+	LowPrice           decimal.Decimal `json:"low_price"`
+	Volume             decimal.Decimal `json:"volume"`       // base currency
+	QuoteVolume        decimal.Decimal `json:"quote_volume"` // quote currency
+	PriceChange        decimal.Decimal `json:"price_change"`
+	PriceChangePercent decimal.Decimal `json:"price_change_percent"`
+	TradeCount         int             `json:"trade_count"`
+	BestBid            decimal.Decimal `json:"best_bid"`
+	BestBidQty         decimal.Decimal `json:"best_bid_qty"`
+	BestAsk            decimal.Decimal `json:"best_ask"`
+	BestAskQty         decimal.Decimal `json:"best_ask_qty"`
+	OpenTime           time.Time       `json:"open_time"` // start of the window
+	CloseTime          time.Time       `json:"close_time"`
+}
+
+// tickerBucket aggregates the trades of one minute
+type tickerBucket struct {
+	start       time.Time
+	first       time.Time // time of the trade open was taken from
+	open        decimal.Decimal
+	high        decimal.Decimal
+	low         decimal.Decimal
+	volume      decimal.Decimal
+	quoteVolume decimal.Decimal
+	count       int
+}
+
+// symbolTicker is the state kept for one symbol
+type symbolTicker struct {
+	buckets   []*tickerBucket // oldest first
+	lastPrice decimal.Decimal
+	lastQty   decimal.Decimal
+	lastTime  time.Time
+	bestBid   matching.PriceLevelInfo
+	bestAsk   matching.PriceLevelInfo
+	changed   bool // since the last push
+}
+
+// TickerService keeps rolling 24-hour ticker statistics in memory, fed from
+// trades and top-of-book changes, and pushes the changed tickers every
+// second. The window moves by the minute.
+type TickerService struct {
+	symbols  map[string]*symbolTicker
+	onUpdate func(tickers []Ticker)
+	mutex    sync.RWMutex
+	stopChan chan struct{}
+	running  bool
+}
+
+// NewTickerService creates a new ticker service
+func NewTickerService() *TickerService {
+	return &TickerService{
+		symbols:  make(map[string]*symbolTicker),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// OnUpdate registers the callback that receives the changed tickers
+func (s *TickerService) OnUpdate(fn func(tickers []Ticker)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onUpdate = fn
+}
+
+// Load fills the window from the trades of the last 24 hours
+func (s *TickerService) Load() error {
+	rows, err := database.DB.Model(&models.Trade{}).
+		Where("trade_time >= ?", time.Now().Add(-tickerWindow)).
+		Order("trade_time ASC, id ASC").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trade models.Trade
+		if err := database.DB.ScanRows(rows, &trade); err != nil {
+			return err
+		}
+		s.AddTrade(&trade)
+	}
+	return rows.Err()
+}
+
+// Start pushes changed tickers every second
+func (s *TickerService) Start() {
+	s.mutex.Lock()
+	if s.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.running = true
+	s.mutex.Unlock()
+
+	go s.pushLoop()
+}
+
+// Stop stops pushing tickers
+func (s *TickerService) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.running {
+		return
+	}
+
+	s.running = false
+	close(s.stopChan)
+}
+
+// pushLoop hands the tickers changed in the last second to the callback
+func (s *TickerService) pushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.push(now)
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// push drops the buckets that left the window and publishes what changed
+func (s *TickerService) push(now time.Time) {
+	var tickers []Ticker
+
+	s.mutex.Lock()
+	for symbol, st := range s.symbols {
+		if st.prune(now) {
+			st.changed = true
+		}
+		if st.changed {
+			st.changed = false
+			tickers = append(tickers, st.ticker(symbol, now))
+		}
+	}
+	onUpdate := s.onUpdate
+	s.mutex.Unlock()
+
+	if len(tickers) == 0 || onUpdate == nil {
+		return
+	}
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Symbol < tickers[j].Symbol })
+	onUpdate(tickers)
+}
+
+// symbol returns the state of a symbol, creating it; the caller must hold
+// the write lock
+func (s *TickerService) symbol(symbol string) *symbolTicker {
+	st, exists := s.symbols[symbol]
+	if !exists {
+		st = &symbolTicker{}
+		s.symbols[symbol] = st
+	}
+	return st
+}
+
+// AddTrade adds a trade to the bucket of its minute; a late trade does not
+// replace the last price
+func (s *TickerService) AddTrade(trade *models.Trade) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.symbol(trade.Symbol)
+	start := trade.TradeTime.Truncate(time.Minute)
+
+	// Trades arrive almost in order, so search from the newest bucket
+	i := len(st.buckets)
+	for i > 0 && st.buckets[i-1].start.After(start) {
+		i--
+	}
+	var bucket *tickerBucket
+	if i > 0 && st.buckets[i-1].start.Equal(start) {
+		bucket = st.buckets[i-1]
+	} else {
+		bucket = &tickerBucket{start: start, first: trade.TradeTime, open: trade.Price, high: trade.Price, low: trade.Price}
+		st.buckets = append(st.buckets, nil)
+		copy(st.buckets[i+1:], st.buckets[i:])
+		st.buckets[i] = bucket
+	}
+	if trade.TradeTime.Before(bucket.first) {
+		bucket.first, bucket.open = trade.TradeTime, trade.Price
+	}
+	if trade.Price.GreaterThan(bucket.high) {
+		bucket.high = trade.Price
+	}
+	if trade.Price.LessThan(bucket.low) {
+		bucket.low = trade.Price
+	}
+	bucket.volume = bucket.volume.Add(trade.Quantity)
+	bucket.quoteVolume = bucket.quoteVolume.Add(trade.Amount)
+	bucket.count++
+
+	if !trade.TradeTime.Before(st.lastTime) {
+		st.lastPrice, st.lastQty, st.lastTime = trade.Price, trade.Quantity, trade.TradeTime
+	}
+	st.changed = true
+}
+
+// UpdateBook records the top of a symbol's book; a nil level is an empty side
+func (s *TickerService) UpdateBook(symbol string, bid, ask *matching.PriceLevelInfo) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.symbol(symbol)
+	next := [2]matching.PriceLevelInfo{}
+	for i, level := range []*matching.PriceLevelInfo{bid, ask} {
+		if level != nil {
+			next[i] = *level
+		}
+	}
+	if !next[0].Price.Equal(st.bestBid.Price) || !next[0].Volume.Equal(st.bestBid.Volume) ||
+		!next[1].Price.Equal(st.bestAsk.Price) || !next[1].Volume.Equal(st.bestAsk.Volume) {
+		st.bestBid, st.bestAsk = next[0], next[1]
+		st.changed = true
+	}
+}
+
+// GetTicker returns the ticker of one symbol
+func (s *TickerService) GetTicker(symbol string) (Ticker, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	st, exists := s.symbols[symbol]
+	if !exists {
+		return Ticker{}, false
+	}
+	return st.ticker(symbol, time.Now()), true
+}
+
+// GetTickers returns the tickers of every symbol, sorted by symbol
+func (s *TickerService) GetTickers() []Ticker {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	tickers := make([]Ticker, 0, len(s.symbols))
+	for symbol, st := range s.symbols {
+		tickers = append(tickers, st.ticker(symbol, now))
+	}
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Symbol < tickers[j].Symbol })
+	return tickers
+}
+
+// prune drops the buckets that ended before the window and reports whether
+// any did
+func (st *symbolTicker) prune(now time.Time) bool {
+	since := now.Add(-tickerWindow).Truncate(time.Minute)
+	n := 0
+	for n < len(st.buckets) && st.buckets[n].start.Before(since) {
+		n++
+	}
+	st.buckets = st.buckets[n:]
+	return n > 0
+}
+
+// ticker computes the statistics of the buckets still in the window
+func (st *symbolTicker) ticker(symbol string, now time.Time) Ticker {
+	since := now.Add(-tickerWindow).Truncate(time.Minute)
+	t := Ticker{
+		Symbol:     symbol,
+		LastPrice:  st.lastPrice,
+		LastQty:    st.lastQty,
+		OpenPrice:  st.lastPrice,
+		HighPrice:  st.lastPrice,
+		LowPrice:   st.lastPrice,
+		BestBid:    st.bestBid.Price,
+		BestBidQty: st.bestBid.Volume,
+		BestAsk:    st.bestAsk.Price,
+		BestAskQty: st.bestAsk.Volume,
+		OpenTime:   since,
+		CloseTime:  now,
+	}
+
+	first := true
+	for _, bucket := range st.buckets {
+		if bucket.start.Before(since) {
+			continue
+		}
+		if first {
+			t.OpenPrice, t.HighPrice, t.LowPrice = bucket.open, bucket.high, bucket.low
+			first = false
+		}
+		t.HighPrice = decimal.Max(t.HighPrice, bucket.high)
+		t.LowPrice = decimal.Min(t.LowPrice, bucket.low)
+		t.Volume = t.Volume.Add(bucket.volume)
+		t.QuoteVolume = t.QuoteVolume.Add(bucket.quoteVolume)
+		t.TradeCount += bucket.count
+	}
+
+	t.PriceChange = t.LastPrice.Sub(t.OpenPrice)
+	if t.OpenPrice.IsPositive() {
+		t.PriceChangePercent = t.PriceChange.Div(t.OpenPrice).Mul(decimal.NewFromInt(100)).Round(2)
+	}
+	return t
+}
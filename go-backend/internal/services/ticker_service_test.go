@@ -0,0 +1,153 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/easitradecoins/backend/internal/matching"
+	"github.com/easitradecoins/backend/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tickerStart is the time of the first trade in the ticker tests
+var tickerStart = time.Date(2025, 11, 3, 10, 0, 0, 0, time.UTC)
+
+// tickerTrade returns a trade of qty at price, offset from tickerStart
+func tickerTrade(symbol string, offset time.Duration, price, qty string) *models.Trade {
+	p, q := decimal.RequireFromString(price), decimal.RequireFromString(qty)
+	return &models.Trade{Symbol: symbol, Price: p, Quantity: q, Amount: p.Mul(q), TradeTime: tickerStart.Add(offset)}
+}
+
+// tickerAt computes a symbol's ticker as of now
+func tickerAt(t *testing.T, s *TickerService, symbol string, now time.Time) Ticker {
+	t.Helper()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st, exists := s.symbols[symbol]
+	require.True(t, exists, "no ticker for %s", symbol)
+	st.prune(now)
+	return st.ticker(symbol, now)
+}
+
+// TestTickerWindowPruning checks trades leave the statistics 24 hours later
+func TestTickerWindowPruning(t *testing.T) {
+	s := NewTickerService()
+	s.AddTrade(tickerTrade("BTC_USDT", 0, "100", "1"))
+	s.AddTrade(tickerTrade("BTC_USDT", time.Hour, "120", "2"))
+	s.AddTrade(tickerTrade("BTC_USDT", 2*time.Hour, "110", "1"))
+
+	ticker := tickerAt(t, s, "BTC_USDT", tickerStart.Add(23*time.Hour))
+	assert.Equal(t, 3, ticker.TradeCount)
+	assert.True(t, ticker.OpenPrice.Equal(decimal.RequireFromString("100")))
+
+	// The first minute has left the window
+	ticker = tickerAt(t, s, "BTC_USDT", tickerStart.Add(tickerWindow+2*time.Minute))
+	assert.Equal(t, 2, ticker.TradeCount)
+	assert.True(t, ticker.Volume.Equal(decimal.RequireFromString("3")), "volume %s", ticker.Volume)
+	assert.True(t, ticker.QuoteVolume.Equal(decimal.RequireFromString("350")), "quote volume %s", ticker.QuoteVolume)
+	assert.True(t, ticker.OpenPrice.Equal(decimal.RequireFromString("120")), "open %s", ticker.OpenPrice)
+	assert.True(t, ticker.HighPrice.Equal(decimal.RequireFromString("120")), "high %s", ticker.HighPrice)
+	assert.True(t, ticker.LowPrice.Equal(decimal.RequireFromString("110")), "low %s", ticker.LowPrice)
+	assert.Equal(t, tickerStart.Add(2*time.Minute), ticker.OpenTime)
+}
+
+// TestTickerEmptyWindow checks open, high and low fall back to the last
+// price once every trade has left the window
+func TestTickerEmptyWindow(t *testing.T) {
+	s := NewTickerService()
+	s.AddTrade(tickerTrade("BTC_USDT", 0, "100", "1"))
+	s.AddTrade(tickerTrade("BTC_USDT", time.Minute, "90", "1"))
+
+	ticker := tickerAt(t, s, "BTC_USDT", tickerStart.Add(2*tickerWindow))
+	last := decimal.RequireFromString("90")
+	assert.True(t, ticker.LastPrice.Equal(last))
+	assert.True(t, ticker.OpenPrice.Equal(last), "open %s", ticker.OpenPrice)
+	assert.True(t, ticker.HighPrice.Equal(last), "high %s", ticker.HighPrice)
+	assert.True(t, ticker.LowPrice.Equal(last), "low %s", ticker.LowPrice)
+	assert.True(t, ticker.Volume.IsZero())
+	assert.Zero(t, ticker.TradeCount)
+	assert.True(t, ticker.PriceChange.IsZero())
+	assert.True(t, ticker.PriceChangePercent.IsZero())
+}
+
+// TestTickerPriceChangePercent checks the change is relative to the window's
+// open price and rounded to two places
+func TestTickerPriceChangePercent(t *testing.T) {
+	tests := []struct {
+		open, last  string
+		change, pct string
+	}{
+		{"100", "105.5", "5.5", "5.5"},
+		{"200", "150", "-50", "-25"},
+		{"3", "4", "1", "33.33"},
+		{"3", "2", "-1", "-33.33"},
+	}
+	for _, tt := range tests {
+		s := NewTickerService()
+		s.AddTrade(tickerTrade("BTC_USDT", 0, tt.open, "1"))
+		s.AddTrade(tickerTrade("BTC_USDT", time.Hour, tt.last, "1"))
+
+		ticker := tickerAt(t, s, "BTC_USDT", tickerStart.Add(2*time.Hour))
+		assert.True(t, ticker.PriceChange.Equal(decimal.RequireFromString(tt.change)), "%s -> %s change %s", tt.open, tt.last, ticker.PriceChange)
+		assert.True(t, ticker.PriceChangePercent.Equal(decimal.RequireFromString(tt.pct)), "%s -> %s percent %s", tt.open, tt.last, ticker.PriceChangePercent)
+	}
+}
+
+// TestTickerPushChanged checks a push carries only the symbols that changed
+// since the previous one
+func TestTickerPushChanged(t *testing.T) {
+	s := NewTickerService()
+	var pushed [][]string
+	s.OnUpdate(func(tickers []Ticker) {
+		var symbols []string
+		for _, ticker := range tickers {
+			symbols = append(symbols, ticker.Symbol)
+		}
+		pushed = append(pushed, symbols)
+	})
+	now := tickerStart.Add(time.Hour)
+
+	s.AddTrade(tickerTrade("ETH_USDT", 0, "10", "1"))
+	s.AddTrade(tickerTrade("BTC_USDT", 0, "100", "1"))
+	s.push(now)
+	s.push(now)
+	require.Equal(t, [][]string{{"BTC_USDT", "ETH_USDT"}}, pushed)
+
+	// An unchanged top of book is not a change
+	bid := &matching.PriceLevelInfo{Price: decimal.RequireFromString("99"), Volume: decimal.RequireFromString("2")}
+	s.UpdateBook("BTC_USDT", bid, nil)
+	s.push(now)
+	s.UpdateBook("BTC_USDT", bid, nil)
+	s.push(now)
+	assert.Equal(t, [][]string{{"BTC_USDT", "ETH_USDT"}, {"BTC_USDT"}}, pushed)
+
+	// Trades leaving the window are
+	s.push(tickerStart.Add(tickerWindow + time.Minute))
+	assert.Equal(t, []string{"BTC_USDT", "ETH_USDT"}, pushed[len(pushed)-1])
+}
+
+// TestTickerLateTrade checks a trade arriving after newer ones is aged out
+// with its own minute and does not replace the last price
+func TestTickerLateTrade(t *testing.T) {
+	s := NewTickerService()
+	s.AddTrade(tickerTrade("BTC_USDT", 5*time.Minute, "100", "1"))
+	s.AddTrade(tickerTrade("BTC_USDT", time.Minute, "90", "2"))
+	s.AddTrade(tickerTrade("BTC_USDT", time.Minute+30*time.Second, "95", "1"))
+
+	ticker := tickerAt(t, s, "BTC_USDT", tickerStart.Add(10*time.Minute))
+	assert.True(t, ticker.LastPrice.Equal(decimal.RequireFromString("100")), "last %s", ticker.LastPrice)
+	assert.True(t, ticker.OpenPrice.Equal(decimal.RequireFromString("90")), "open %s", ticker.OpenPrice)
+	assert.Equal(t, 3, ticker.TradeCount)
+
+	ticker = tickerAt(t, s, "BTC_USDT", tickerStart.Add(tickerWindow+3*time.Minute))
+	assert.Equal(t, 1, ticker.TradeCount)
+	assert.True(t, ticker.Volume.Equal(decimal.RequireFromString("1")), "volume %s", ticker.Volume)
+	assert.True(t, ticker.LowPrice.Equal(decimal.RequireFromString("100")), "low %s", ticker.LowPrice)
+}
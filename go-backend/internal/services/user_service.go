@@ -288,3 +288,22 @@ func (s *AssetService) FreezeAssetWithTx(tx *gorm.DB, userID uint, currency, cha
 
 	return tx.Save(&asset).Error
 }
+
+// UnfreezeAssetWithTx unfreezes asset within a transaction
+func (s *AssetService) UnfreezeAssetWithTx(tx *gorm.DB, userID uint, currency, chain string, amount decimal.Decimal) error {
+	var asset models.UserAsset
+	if err := tx.Where("user_id = ? AND currency = ? AND chain = ?", userID, currency, chain).
+		First(&asset).Error; err != nil {
+		return err
+	}
+
+	if asset.Frozen.LessThan(amount) {
+		return errors.New("insufficient frozen balance")
+	}
+
+	asset.Frozen = asset.Frozen.Sub(amount)
+	asset.Available = asset.Available.Add(amount)
+	asset.UpdateTime = time.Now()
+
+	return tx.Save(&asset).Error
+}
@@ -6,11 +6,16 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/easitradecoins/backend/internal/matching"
 	"github.com/easitradecoins/backend/internal/models"
+	"github.com/easitradecoins/backend/internal/services"
 	"github.com/gorilla/websocket"
 )
 
@@ -21,9 +26,16 @@ type Client struct {
 	Send          chan []byte
 	Hub           *Hub
 	Subscriptions map[string]bool // channel -> subscribed
+	UserID        *uint           // set for authenticated sessions
 	mu            sync.RWMutex
+
+	cancelOnDisconnect bool
+	cancelTimeout      time.Duration // grace period before orders are cancelled
 }
 
+// MaxCancelOnDisconnectTimeout caps the grace period of the cancel-on-disconnect switch
+const MaxCancelOnDisconnectTimeout = 5 * time.Minute
+
 // Hub manages WebSocket clients and broadcasts
 type Hub struct {
 	clients    map[*Client]bool
@@ -31,16 +43,106 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+
+	cancelOrders func(userID uint)    // mass-cancels a user's orders
+	cancelTimers map[uint]*time.Timer // user -> armed cancel-on-disconnect
+	timersMu     sync.Mutex
 }
 
 // NewHub creates a new Hub
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 10000),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		clients:      make(map[*Client]bool),
+		broadcast:    make(chan []byte, 10000),
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		cancelTimers: make(map[uint]*time.Timer),
+	}
+}
+
+// Register adds a connected client to the hub
+func (h *Hub) Register(c *Client) {
+	h.register <- c
+}
+
+// SetCancelOrdersFunc sets the function used to cancel a user's orders when
+// a session with cancel-on-disconnect enabled drops
+func (h *Hub) SetCancelOrdersFunc(fn func(userID uint)) {
+	h.timersMu.Lock()
+	defer h.timersMu.Unlock()
+	h.cancelOrders = fn
+}
+
+// EnableCancelOnDisconnect arms the client's dead-man's switch: if the
+// session drops, the user's orders are cancelled after timeout unless the
+// user opts in again from a new session first
+func (h *Hub) EnableCancelOnDisconnect(c *Client, timeout time.Duration) error {
+	if c.UserID == nil {
+		return errors.New("cancel on disconnect requires an authenticated session")
 	}
+	if timeout < 0 || timeout > MaxCancelOnDisconnectTimeout {
+		return fmt.Errorf("timeout must be between 0 and %s", MaxCancelOnDisconnectTimeout)
+	}
+
+	c.mu.Lock()
+	c.cancelOnDisconnect = true
+	c.cancelTimeout = timeout
+	c.mu.Unlock()
+
+	// The user is back: disarm a switch left by an earlier session
+	h.timersMu.Lock()
+	if timer, exists := h.cancelTimers[*c.UserID]; exists {
+		timer.Stop()
+		delete(h.cancelTimers, *c.UserID)
+	}
+	h.timersMu.Unlock()
+
+	return nil
+}
+
+// DisableCancelOnDisconnect turns the client's dead-man's switch off
+func (h *Hub) DisableCancelOnDisconnect(c *Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelOnDisconnect = false
+}
+
+// armCancelOnDisconnect schedules the mass cancel for a dropped client
+func (h *Hub) armCancelOnDisconnect(c *Client) {
+	c.mu.RLock()
+	enabled, timeout := c.cancelOnDisconnect, c.cancelTimeout
+	c.mu.RUnlock()
+	if !enabled || c.UserID == nil {
+		return
+	}
+	userID := *c.UserID
+
+	h.timersMu.Lock()
+	defer h.timersMu.Unlock()
+
+	if h.cancelOrders == nil {
+		return
+	}
+	if timer, exists := h.cancelTimers[userID]; exists {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		h.timersMu.Lock()
+		if h.cancelTimers[userID] != timer {
+			// Disarmed or re-armed meanwhile
+			h.timersMu.Unlock()
+			return
+		}
+		delete(h.cancelTimers, userID)
+		cancelOrders := h.cancelOrders
+		h.timersMu.Unlock()
+
+		log.Printf("Cancelling orders of user %d after disconnect", userID)
+		cancelOrders(userID)
+	})
+	h.cancelTimers[userID] = timer
 }
 
 // Run starts the hub
@@ -60,6 +162,7 @@ func (h *Hub) Run() {
 				close(client.Send)
 			}
 			h.mu.Unlock()
+			h.armCancelOnDisconnect(client)
 			log.Printf("Client unregistered: %s, total clients: %d", client.ID, len(h.clients))
 
 		case message := <-h.broadcast:
@@ -105,6 +208,19 @@ func (h *Hub) BroadcastToChannel(channel string, data interface{}) {
 	}
 }
 
+// hasSubscribers reports whether any client is subscribed to channel
+func (h *Hub) hasSubscribers(channel string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		if client.IsSubscribed(channel) {
+			return true
+		}
+	}
+	return false
+}
+
 // Message represents a WebSocket message
 type Message struct {
 	Type    string      `json:"type"`
@@ -113,7 +229,10 @@ type Message struct {
 	Error   string      `json:"error,omitempty"`
 }
 
-// SubscribeMessage represents a subscribe message
+// SubscribeMessage represents a client request: SUBSCRIBE and UNSUBSCRIBE
+// with channels as params, or CANCEL_ON_DISCONNECT with params
+// ["true", timeout in milliseconds] to arm the dead-man's switch and
+// ["false"] to turn it off
 type SubscribeMessage struct {
 	Method string   `json:"method"`
 	Params []string `json:"params"`
@@ -142,9 +261,13 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		// Handle subscribe/unsubscribe messages
 		var subMsg SubscribeMessage
-		if err := json.Unmarshal(message, &subMsg); err == nil {
+		if err := json.Unmarshal(message, &subMsg); err != nil {
+			continue
+		}
+		if subMsg.Method == "CANCEL_ON_DISCONNECT" {
+			c.handleCancelOnDisconnect(&subMsg)
+		} else {
 			c.handleSubscription(&subMsg)
 		}
 	}
@@ -223,6 +346,38 @@ func (c *Client) handleSubscription(msg *SubscribeMessage) {
 	}
 }
 
+// handleCancelOnDisconnect turns the session's dead-man's switch on or off
+func (c *Client) handleCancelOnDisconnect(msg *SubscribeMessage) {
+	enabled := len(msg.Params) == 0 || msg.Params[0] != "false"
+	var timeout time.Duration
+	if enabled && len(msg.Params) > 1 {
+		ms, err := strconv.ParseInt(msg.Params[1], 10, 64)
+		if err != nil {
+			c.sendMessage(Message{Type: "error", Error: "invalid timeout"})
+			return
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	if enabled {
+		if err := c.Hub.EnableCancelOnDisconnect(c, timeout); err != nil {
+			c.sendMessage(Message{Type: "error", Error: err.Error()})
+			return
+		}
+	} else {
+		c.Hub.DisableCancelOnDisconnect(c)
+	}
+
+	c.sendMessage(Message{
+		Type: "cancel_on_disconnect",
+		Data: map[string]interface{}{
+			"id":      msg.ID,
+			"enabled": enabled,
+			"timeout": timeout.Milliseconds(),
+		},
+	})
+}
+
 // IsSubscribed checks if client is subscribed to a channel
 func (c *Client) IsSubscribed(channel string) bool {
 	c.mu.RLock()
@@ -256,13 +411,141 @@ func (h *Hub) BroadcastTrade(trade *models.Trade) {
 	})
 }
 
-// BroadcastOrderBookUpdate broadcasts order book update
-func (h *Hub) BroadcastOrderBookUpdate(symbol string, bids, asks interface{}) {
-	channel := symbol + "@depth"
+// BroadcastKline broadcasts a bar change of one interval; x is set once the
+// bar is closed
+func (h *Hub) BroadcastKline(kline *models.Kline, closed bool) {
+	channel := kline.Symbol + "@kline_" + kline.Interval
+	h.BroadcastToChannel(channel, map[string]interface{}{
+		"e": "kline",
+		"E": time.Now().UnixMilli(),
+		"s": kline.Symbol,
+		"k": map[string]interface{}{
+			"t": kline.OpenTime.UnixMilli(),
+			"T": kline.CloseTime.UnixMilli(),
+			"s": kline.Symbol,
+			"i": kline.Interval,
+			"o": kline.Open.String(),
+			"c": kline.Close.String(),
+			"h": kline.High.String(),
+			"l": kline.Low.String(),
+			"v": kline.Volume.String(),
+			"q": kline.QuoteVolume.String(),
+			"n": kline.TradeCount,
+			"x": closed,
+		},
+	})
+}
+
+// BroadcastTickers broadcasts the full ticker of each changed symbol to its
+// @ticker channel and their mini tickers together to !miniTicker
+func (h *Hub) BroadcastTickers(tickers []services.Ticker) {
+	now := time.Now().UnixMilli()
+	minis := make([]map[string]interface{}, 0, len(tickers))
+	for _, t := range tickers {
+		h.BroadcastToChannel(t.Symbol+"@ticker", map[string]interface{}{
+			"e": "24hrTicker",
+			"E": now,
+			"s": t.Symbol,
+			"p": t.PriceChange.String(),
+			"P": t.PriceChangePercent.String(),
+			"c": t.LastPrice.String(),
+			"Q": t.LastQty.String(),
+			"b": t.BestBid.String(),
+			"B": t.BestBidQty.String(),
+			"a": t.BestAsk.String(),
+			"A": t.BestAskQty.String(),
+			"o": t.OpenPrice.String(),
+			"h": t.HighPrice.String(),
+			"l": t.LowPrice.String(),
+			"v": t.Volume.String(),
+			"q": t.QuoteVolume.String(),
+			"O": t.OpenTime.UnixMilli(),
+			"C": t.CloseTime.UnixMilli(),
+			"n": t.TradeCount,
+		})
+		minis = append(minis, map[string]interface{}{
+			"e": "24hrMiniTicker",
+			"E": now,
+			"s": t.Symbol,
+			"c": t.LastPrice.String(),
+			"o": t.OpenPrice.String(),
+			"h": t.HighPrice.String(),
+			"l": t.LowPrice.String(),
+			"v": t.Volume.String(),
+			"q": t.QuoteVolume.String(),
+		})
+	}
+	h.BroadcastToChannel("!miniTicker", minis)
+}
+
+// BroadcastMarkPrice broadcasts a symbol's index and mark price
+func (h *Hub) BroadcastMarkPrice(price *models.MarkPrice) {
+	channel := price.Symbol + "@markPrice"
+	h.BroadcastToChannel(channel, map[string]interface{}{
+		"e": "markPriceUpdate",
+		"E": price.UpdateTime.UnixMilli(),
+		"s": price.Symbol,
+		"p": price.MarkPrice.String(),
+		"i": price.IndexPrice.String(),
+	})
+}
+
+// BroadcastOrderBookUpdate broadcasts an incremental depth update. Levels
+// are [price, volume] pairs and a zero volume removes the level.
+func (h *Hub) BroadcastOrderBookUpdate(update *matching.DepthUpdate) {
+	channel := update.Symbol + "@depth"
+	if !h.hasSubscribers(channel) {
+		return
+	}
+
+	update.SetChecksum()
 	h.BroadcastToChannel(channel, map[string]interface{}{
 		"e": "depthUpdate",
-		"s": symbol,
-		"b": bids,
-		"a": asks,
+		"E": update.Time.UnixMilli(),
+		"s": update.Symbol,
+		"U": update.FirstUpdateID,
+		"u": update.FinalUpdateID,
+		"b": depthLevels(update.Bids),
+		"a": depthLevels(update.Asks),
+		"c": update.Checksum,
 	})
 }
+
+// BroadcastL3Update broadcasts the order events of one command. u is the
+// command's sequence number, shared with its trades, and pu the sequence of
+// the symbol's previous L3 update.
+func (h *Hub) BroadcastL3Update(update *matching.L3Update) {
+	channel := update.Symbol + "@l3"
+	events := make([]map[string]interface{}, 0, len(update.Events))
+	for _, event := range update.Events {
+		e := map[string]interface{}{
+			"x": event.Type,
+			"i": event.OrderID,
+			"S": event.Side,
+			"p": event.Price.String(),
+			"q": event.Quantity.String(),
+		}
+		if event.TradeID != "" {
+			e["t"] = event.TradeID
+		}
+		events = append(events, e)
+	}
+
+	h.BroadcastToChannel(channel, map[string]interface{}{
+		"e":  "l3Update",
+		"E":  update.Time.UnixMilli(),
+		"s":  update.Symbol,
+		"u":  update.Seq,
+		"pu": update.PrevSeq,
+		"o":  events,
+	})
+}
+
+// depthLevels formats levels as [price, volume] pairs
+func depthLevels(levels []matching.PriceLevelInfo) [][2]string {
+	pairs := make([][2]string, 0, len(levels))
+	for _, level := range levels {
+		pairs = append(pairs, [2]string{level.Price.String(), level.Volume.String()})
+	}
+	return pairs
+}
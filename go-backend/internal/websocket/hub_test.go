@@ -0,0 +1,114 @@
+//Author:Aitachi
+//Email:44158892@qq.com
+//Date: 11-02-2025 17
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHub runs a hub behind a test server whose sessions belong to
+// userID, and returns the server URL and the users whose orders it cancels
+func newTestHub(t *testing.T, userID uint) (string, <-chan uint) {
+	t.Helper()
+
+	cancelled := make(chan uint, 10)
+	hub := NewHub()
+	hub.SetCancelOrdersFunc(func(userID uint) { cancelled <- userID })
+	go hub.Run()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		id := userID
+		client := &Client{
+			ID:            r.RemoteAddr,
+			Conn:          conn,
+			Send:          make(chan []byte, 256),
+			Hub:           hub,
+			Subscriptions: make(map[string]bool),
+			UserID:        &id,
+		}
+		hub.Register(client)
+
+		go client.WritePump()
+		go client.ReadPump()
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), cancelled
+}
+
+// dialSwitch opens a session and sends a CANCEL_ON_DISCONNECT request,
+// waiting for its acknowledgement
+func dialSwitch(t *testing.T, url string, params ...string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteJSON(SubscribeMessage{Method: "CANCEL_ON_DISCONNECT", Params: params, ID: 1}))
+
+	var ack Message
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	require.NoError(t, conn.ReadJSON(&ack))
+	require.Equal(t, "cancel_on_disconnect", ack.Type, "error %q", ack.Error)
+	return conn
+}
+
+// TestCancelOnDisconnect checks a session that armed the switch has its
+// user's orders cancelled once the timeout passes after it drops
+func TestCancelOnDisconnect(t *testing.T) {
+	url, cancelled := newTestHub(t, 7)
+
+	conn := dialSwitch(t, url, "true", "200")
+	dropped := time.Now()
+	require.NoError(t, conn.Close())
+
+	select {
+	case userID := <-cancelled:
+		assert.Equal(t, uint(7), userID)
+		assert.GreaterOrEqual(t, time.Since(dropped), 200*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("orders were not cancelled after the session dropped")
+	}
+}
+
+// TestCancelOnDisconnectReconnect checks a new session opting in within the
+// timeout disarms the switch, and a disabled switch never fires
+func TestCancelOnDisconnectReconnect(t *testing.T) {
+	url, cancelled := newTestHub(t, 7)
+
+	first := dialSwitch(t, url, "true", "300")
+	require.NoError(t, first.Close())
+	// Let the hub see the drop and arm the switch
+	time.Sleep(100 * time.Millisecond)
+
+	second := dialSwitch(t, url, "true", "300")
+	dialSwitch(t, url, "false").Close()
+
+	select {
+	case <-cancelled:
+		t.Fatal("orders were cancelled although the user reconnected")
+	case <-time.After(600 * time.Millisecond):
+	}
+
+	second.Close()
+	select {
+	case userID := <-cancelled:
+		assert.Equal(t, uint(7), userID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("orders were not cancelled after the second session dropped")
+	}
+}